@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/endpoint"
+)
+
+// command is a subcommand of the client, args describes the arguments it takes
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *cli, ctx context.Context, args []string) error
+}
+
+// commands is every command in the order the usage lists them
+var commands = []command{
+	{"createUser", "<username> <email> <password>", "Sign up a user, a verification code is sent to their email", (*cli).createUser},
+	{"confirmUser", "<username> <code>", "Confirm a user with their verification code", (*cli).confirmUser},
+	{"resendConfirmation", "<username>", "Resend a user's verification code", (*cli).resendConfirmation},
+	{"usernameTaken", "<username>", "Check whether a username is taken", (*cli).usernameTaken},
+	{"login", "<username> <password>", "Log in, saving the tokens to the credentials file", (*cli).login},
+	{"refreshSession", "", "Get new tokens with the saved refresh token", (*cli).refreshSession},
+	{"forgotPassword", "<username>", "Send a user a code to reset their password", (*cli).forgotPassword},
+	{"confirmForgotPassword", "<username> <code> <password>", "Reset a user's password with the code they were sent", (*cli).confirmForgotPassword},
+	{"changePassword", "[--token <jwt>] [--sign-out-others] <old password> <new password>", "Change the password", (*cli).changePassword},
+	{"logout", "", "Revoke the saved refresh token and forget the saved tokens", (*cli).logout},
+	{"globalSignOut", "[--token <jwt>]", "Sign out of every session", (*cli).globalSignOut},
+	{"verifyJWT", "[--token <jwt>]", "Verify a token and show its claims", (*cli).verifyJWT},
+	{"userDetails", "[--token <jwt>]", "Show the details of the user a token belongs to", (*cli).userDetails},
+	{"passwordPolicy", "", "Show the rules passwords must follow", (*cli).passwordPolicy},
+	{"getProfile", "[--token <jwt>]", "Show the profile of the user a token belongs to", (*cli).getProfile},
+	{"updateContactDetails", "[--token <jwt>] [--email <email>] [--phone-number <+447700900123>]", "Change the email or phone number, a code is sent to verify the new value", (*cli).updateContactDetails},
+	{"verifyAttribute", "[--token <jwt>] <email|phone_number> <code>", "Verify the email or phone number with the code sent to it", (*cli).verifyAttribute},
+	{"updateProfile", "[--token <jwt>] [--first-name <name>] [--date-of-birth <yyyy-mm-dd>] ...", "Change the given fields of the profile, leaving the rest", (*cli).updateProfile},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// cli holds what the commands need to call the service and report the result
+type cli struct {
+	user        endpoint.Endpoints
+	output      string
+	credentials string
+	cmd         command
+	stdout      io.Writer
+	stderr      io.Writer
+}
+
+// okResult is printed by the commands the service only answers with success
+type okResult struct {
+	OK bool `json:"ok"`
+}
+
+type usernameTakenResult struct {
+	Username string `json:"username"`
+	Taken    bool   `json:"taken"`
+}
+
+type changePasswordResult struct {
+	OK      bool           `json:"ok"`
+	Session *model.Session `json:"session,omitempty"`
+}
+
+// userResult is a user without the password, which the service never returns
+type userResult struct {
+	ID                  int    `json:"id"`
+	Username            string `json:"username"`
+	Email               string `json:"email"`
+	PhoneNumber         string `json:"phoneNumber"`
+	Confirmed           bool   `json:"confirmed"`
+	EmailVerified       bool   `json:"emailVerified"`
+	PhoneNumberVerified bool   `json:"phoneNumberVerified"`
+}
+
+func newUserResult(user *model.User) userResult {
+	return userResult{
+		ID:                  user.ID,
+		Username:            user.Username,
+		Email:               user.Email,
+		PhoneNumber:         user.PhoneNumber,
+		Confirmed:           user.Confirmed,
+		EmailVerified:       user.EmailVerified,
+		PhoneNumberVerified: user.PhoneNumberVerified,
+	}
+}
+
+// contactDetailsResult lists where the codes to verify the changed values were sent
+type contactDetailsResult struct {
+	CodeDeliveries []model.CodeDelivery `json:"codeDeliveries"`
+}
+
+// profileResult is a profile with its date of birth as the service takes it
+type profileResult struct {
+	FirstName   string    `json:"firstName"`
+	LastName    string    `json:"lastName"`
+	DisplayName string    `json:"displayName"`
+	AvatarURL   string    `json:"avatarUrl"`
+	Bio         string    `json:"bio"`
+	Location    string    `json:"location"`
+	Locale      string    `json:"locale"`
+	Timezone    string    `json:"timezone"`
+	DateOfBirth string    `json:"dateOfBirth"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func newProfileResult(profile *model.Profile) profileResult {
+	result := profileResult{
+		FirstName:   profile.FirstName,
+		LastName:    profile.LastName,
+		DisplayName: profile.DisplayName,
+		AvatarURL:   profile.AvatarURL,
+		Bio:         profile.Bio,
+		Location:    profile.Location,
+		Locale:      profile.Locale,
+		Timezone:    profile.Timezone,
+		UpdatedAt:   profile.UpdatedAt,
+	}
+	if !profile.DateOfBirth.IsZero() {
+		result.DateOfBirth = profile.DateOfBirth.Format(endpoint.DateLayout)
+	}
+	return result
+}
+
+// flags is a flag set for the command being run, its usage describes the command
+func (c *cli) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.cmd.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: client [options] %s %s\n\n%s\n", c.cmd.name, c.cmd.args, c.cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the command's flags, failing unless n arguments are left
+func (c *cli) parse(fs *flag.FlagSet, args []string, n int) error {
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		return errHelp
+	}
+	if err != nil {
+		return errUsage
+	}
+	if fs.NArg() != n {
+		fmt.Fprintf(c.stderr, "%s takes %d arguments, got %d\n\n", c.cmd.name, n, fs.NArg())
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// tokenFlag adds the --token flag to the commands authenticating with an access token
+func tokenFlag(fs *flag.FlagSet) *string {
+	return fs.String("token", "", "access token to use, defaults to the one saved by login")
+}
+
+// accessToken is the token given with --token, or the one saved by login
+func (c *cli) accessToken(token string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+
+	creds, err := loadCredentials(c.credentials)
+	if err != nil {
+		return "", err
+	}
+	return creds.AccessToken, nil
+}
+
+func (c *cli) createUser(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 3); err != nil {
+		return err
+	}
+
+	user, err := c.user.CreateUser(ctx, fs.Arg(0), fs.Arg(1), fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	return c.print(newUserResult(user))
+}
+
+func (c *cli) confirmUser(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 2); err != nil {
+		return err
+	}
+
+	err := c.user.ConfirmUser(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return c.print(okResult{true})
+}
+
+func (c *cli) resendConfirmation(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	err := c.user.ResendConfirmation(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.print(okResult{true})
+}
+
+func (c *cli) usernameTaken(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	taken, err := c.user.UsernameTaken(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.print(usernameTakenResult{Username: fs.Arg(0), Taken: taken})
+}
+
+func (c *cli) login(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 2); err != nil {
+		return err
+	}
+
+	session, err := c.user.Login(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	err = saveCredentials(c.credentials, newCredentials(fs.Arg(0), session, ""))
+	if err != nil {
+		return err
+	}
+	return c.print(session)
+}
+
+func (c *cli) refreshSession(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	creds, err := loadCredentials(c.credentials)
+	if err != nil {
+		return err
+	}
+
+	session, err := c.user.RefreshSession(ctx, creds.Username, creds.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	err = saveCredentials(c.credentials, newCredentials(creds.Username, session, creds.RefreshToken))
+	if err != nil {
+		return err
+	}
+	return c.print(session)
+}
+
+func (c *cli) forgotPassword(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	err := c.user.ForgotPassword(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.print(okResult{true})
+}
+
+func (c *cli) confirmForgotPassword(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 3); err != nil {
+		return err
+	}
+
+	err := c.user.ConfirmForgotPassword(ctx, fs.Arg(0), fs.Arg(1), fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	return c.print(okResult{true})
+}
+
+func (c *cli) changePassword(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	signOutOthers := fs.Bool("sign-out-others", false, "sign out of every other session, the new tokens are saved")
+	if err := c.parse(fs, args, 2); err != nil {
+		return err
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	session, err := c.user.ChangePassword(ctx, jwt, fs.Arg(0), fs.Arg(1), *signOutOthers)
+	if err != nil {
+		return err
+	}
+
+	// The saved tokens were revoked with the other sessions, the new ones replace them
+	if session != nil && *token == "" {
+		creds, err := loadCredentials(c.credentials)
+		if err != nil {
+			return err
+		}
+		err = saveCredentials(c.credentials, newCredentials(creds.Username, session, ""))
+		if err != nil {
+			return err
+		}
+	}
+	return c.print(changePasswordResult{OK: true, Session: session})
+}
+
+func (c *cli) logout(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	creds, err := loadCredentials(c.credentials)
+	if err != nil {
+		return err
+	}
+
+	err = c.user.Logout(ctx, creds.Username, creds.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	err = removeCredentials(c.credentials)
+	if err != nil {
+		return err
+	}
+	return c.print(okResult{true})
+}
+
+func (c *cli) globalSignOut(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	err = c.user.GlobalSignOut(ctx, jwt)
+	if err != nil {
+		return err
+	}
+
+	// The saved tokens were revoked with the rest
+	if *token == "" {
+		err = removeCredentials(c.credentials)
+		if err != nil {
+			return err
+		}
+	}
+	return c.print(okResult{true})
+}
+
+func (c *cli) verifyJWT(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	claims, err := c.user.VerifyJWT(ctx, jwt)
+	if err != nil {
+		return err
+	}
+	return c.print(claims)
+}
+
+func (c *cli) userDetails(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	user, err := c.user.UserDetails(ctx, jwt)
+	if err != nil {
+		return err
+	}
+	return c.print(newUserResult(user))
+}
+
+func (c *cli) passwordPolicy(ctx context.Context, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	policy, err := c.user.GetPasswordPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(policy)
+}
+
+func (c *cli) updateContactDetails(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	email := fs.String("email", "", "new email")
+	phoneNumber := fs.String("phone-number", "", "new phone number in E.164 format")
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := c.user.UpdateContactDetails(ctx, jwt, *email, *phoneNumber)
+	if err != nil {
+		return err
+	}
+	return c.print(contactDetailsResult{CodeDeliveries: deliveries})
+}
+
+func (c *cli) verifyAttribute(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	if err := c.parse(fs, args, 2); err != nil {
+		return err
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	err = c.user.VerifyAttribute(ctx, jwt, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return c.print(okResult{true})
+}
+
+func (c *cli) getProfile(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	profile, err := c.user.GetProfile(ctx, jwt)
+	if err != nil {
+		return err
+	}
+	return c.print(newProfileResult(profile))
+}
+
+// updateProfile changes only the fields given as flags, the service replaces the whole profile so the
+// rest are read first. An empty value clears the field.
+func (c *cli) updateProfile(ctx context.Context, args []string) error {
+	fs := c.flags()
+	token := tokenFlag(fs)
+	fs.String("first-name", "", "first name")
+	fs.String("last-name", "", "last name")
+	fs.String("display-name", "", "name shown to other users")
+	fs.String("avatar-url", "", "http or https address of the avatar image")
+	fs.String("bio", "", "about the user")
+	fs.String("location", "", "where the user is")
+	fs.String("locale", "", "BCP 47 language tag, like en-GB")
+	fs.String("timezone", "", "IANA time zone, like Europe/London")
+	birth := fs.String("date-of-birth", "", "date of birth as yyyy-mm-dd")
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	var dateOfBirth time.Time
+	if *birth != "" {
+		var err error
+		dateOfBirth, err = time.Parse(endpoint.DateLayout, *birth)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "--date-of-birth must be yyyy-mm-dd, got %q\n\n", *birth)
+			fs.Usage()
+			return errUsage
+		}
+	}
+
+	jwt, err := c.accessToken(*token)
+	if err != nil {
+		return err
+	}
+
+	profile, err := c.user.GetProfile(ctx, jwt)
+	if err != nil {
+		return err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "first-name":
+			profile.FirstName = f.Value.String()
+		case "last-name":
+			profile.LastName = f.Value.String()
+		case "display-name":
+			profile.DisplayName = f.Value.String()
+		case "avatar-url":
+			profile.AvatarURL = f.Value.String()
+		case "bio":
+			profile.Bio = f.Value.String()
+		case "location":
+			profile.Location = f.Value.String()
+		case "locale":
+			profile.Locale = f.Value.String()
+		case "timezone":
+			profile.Timezone = f.Value.String()
+		case "date-of-birth":
+			profile.DateOfBirth = dateOfBirth
+		}
+	})
+
+	profile, err = c.user.UpdateProfile(ctx, jwt, profile)
+	if err != nil {
+		return err
+	}
+	return c.print(newProfileResult(profile))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PedPet/user/model"
+	"github.com/pkg/errors"
+)
+
+// errNotLoggedIn is returned when a command needs the saved tokens and there aren't any
+var errNotLoggedIn = errors.New("Not logged in, run login first or pass --token")
+
+// credentials are the tokens login saves for the commands run after it
+type credentials struct {
+	Username     string    `json:"username"`
+	AccessToken  string    `json:"accessToken"`
+	IDToken      string    `json:"idToken"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// newCredentials saves the session's tokens, refreshToken is kept when the session doesn't have a new one
+func newCredentials(username string, session *model.Session, refreshToken string) credentials {
+	if session.RefreshToken != "" {
+		refreshToken = session.RefreshToken
+	}
+
+	return credentials{
+		Username:     username,
+		AccessToken:  session.AccessToken,
+		IDToken:      session.IDToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(session.ExpiresIn) * time.Second).UTC().Truncate(time.Second),
+	}
+}
+
+// defaultCredentialsPath is the credentials file in the user's config directory
+func defaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".pedpet-credentials.json"
+	}
+	return filepath.Join(dir, "pedpet", "credentials.json")
+}
+
+func loadCredentials(path string) (credentials, error) {
+	var creds credentials
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return creds, errNotLoggedIn
+	}
+	if err != nil {
+		return creds, errors.Wrap(err, "Failed to read credentials")
+	}
+
+	err = json.Unmarshal(data, &creds)
+	if err != nil {
+		return creds, errors.Wrap(err, "Failed to decode credentials "+path)
+	}
+	return creds, nil
+}
+
+// saveCredentials writes the credentials so only the user can read them
+func saveCredentials(path string, creds credentials) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode credentials")
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create credentials directory")
+	}
+
+	// Written alongside and renamed so a failed write doesn't lose the saved tokens
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Failed to write credentials")
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "Failed to write credentials")
+	}
+	return nil
+}
+
+func removeCredentials(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Failed to remove credentials")
+	}
+	return nil
+}
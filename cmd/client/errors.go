@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/service"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes, so scripts can tell why a command failed
+const (
+	exitOK              = 0
+	exitFailure         = 1
+	exitUsage           = 2
+	exitInvalid         = 3
+	exitUnauthenticated = 4
+	exitNotFound        = 5
+	exitConflict        = 6
+	exitPrecondition    = 7
+	exitTooManyAttempts = 8
+	exitUnavailable     = 9
+)
+
+var (
+	// errUsage is returned when a command is run with the wrong arguments, the usage has been printed
+	errUsage = errors.New("usage")
+	// errHelp is returned when a command's --help was asked for
+	errHelp = errors.New("help")
+)
+
+// errorExits is the exit code of each of the service's errors
+var errorExits = map[error]int{
+	service.ErrUserNotFound:     exitNotFound,
+	service.ErrUsernameExists:   exitConflict,
+	service.ErrInvalidCode:      exitInvalid,
+	service.ErrCodeExpired:      exitPrecondition,
+	service.ErrUserNotConfirmed: exitPrecondition,
+	service.ErrWrongPassword:    exitUnauthenticated,
+	service.ErrNotAuthorized:    exitUnauthenticated,
+	service.ErrTooManyAttempts:  exitTooManyAttempts,
+	service.ErrInvalidPassword:  exitInvalid,
+	service.ErrPhoneUnsupported: exitPrecondition,
+	errNotLoggedIn:              exitUnauthenticated,
+}
+
+// codeExits is the exit code of the statuses the client doesn't turn back into the service's errors
+var codeExits = map[codes.Code]int{
+	codes.InvalidArgument:    exitInvalid,
+	codes.Unauthenticated:    exitUnauthenticated,
+	codes.PermissionDenied:   exitUnauthenticated,
+	codes.NotFound:           exitNotFound,
+	codes.AlreadyExists:      exitConflict,
+	codes.FailedPrecondition: exitPrecondition,
+	codes.ResourceExhausted:  exitTooManyAttempts,
+	codes.Unavailable:        exitUnavailable,
+	codes.DeadlineExceeded:   exitUnavailable,
+}
+
+// exitCode is the exit code a command failing with err exits with
+func exitCode(err error) int {
+	switch err {
+	case nil, errHelp:
+		return exitOK
+	case errUsage:
+		return exitUsage
+	}
+
+	if code, ok := errorExits[err]; ok {
+		return code
+	}
+	if _, ok := err.(*auth.TokenError); ok {
+		return exitUnauthenticated
+	}
+	if st, ok := status.FromError(err); ok {
+		if code, ok := codeExits[st.Code()]; ok {
+			return code
+		}
+	}
+	return exitFailure
+}
+
+// fail reports err and returns the exit code for it. Invalid requests list each invalid field.
+func fail(w io.Writer, err error) int {
+	code := exitCode(err)
+	if err == nil || err == errUsage || err == errHelp {
+		return code
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		fmt.Fprintln(w, "Error:", err)
+		return code
+	}
+
+	fmt.Fprintln(w, "Error:", st.Message())
+	for _, detail := range st.Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		for _, violation := range badRequest.FieldViolations {
+			fmt.Fprintf(w, "  %s: %s\n", violation.Field, violation.Description)
+		}
+	}
+	return code
+}
@@ -2,51 +2,140 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"time"
 
 	grpcClient "github.com/PedPet/user/pkg/grpc"
-	"github.com/PedPet/user/pkg/service"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	grpcCredentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
+// options are the flags shared by every command, given before the command's name
+type options struct {
+	addr               string
+	useTLS             bool
+	caCert             string
+	serverName         string
+	insecureSkipVerify bool
+	output             string
+	credentials        string
+	timeout            time.Duration
+}
+
 func main() {
-	grpcAddr := os.Getenv("PORT")
-	ctx := context.Background()
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command in args and returns the exit code, errors are written to stderr
+func run(args []string, stdout, stderr io.Writer) int {
+	defaultAddr := "localhost:8080"
+	if port := os.Getenv("PORT"); port != "" {
+		defaultAddr = ":" + port
+	}
 
-	conn, err := grpc.Dial(":"+grpcAddr, grpc.WithInsecure())
+	var o options
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.addr, "addr", defaultAddr, "address of the user service")
+	fs.BoolVar(&o.useTLS, "tls", false, "connect with TLS")
+	fs.StringVar(&o.caCert, "ca-cert", "", "PEM file of the CA to verify the server with, implies --tls")
+	fs.StringVar(&o.serverName, "server-name", "", "name to verify the server's certificate against, defaults to the host of --addr")
+	fs.BoolVar(&o.insecureSkipVerify, "insecure-skip-verify", false, "don't verify the server's certificate")
+	fs.StringVar(&o.output, "output", outputTable, "output format, json or table")
+	fs.StringVar(&o.credentials, "credentials", defaultCredentialsPath(), "file the tokens are saved to after login")
+	fs.DurationVar(&o.timeout, "timeout", 10*time.Second, "time to wait for the call")
+	fs.Usage = func() { usage(fs) }
+
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK
+	}
 	if err != nil {
-		log.Fatal("gRPC dial:", err)
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if o.output != outputTable && o.output != outputJSON {
+		fmt.Fprintf(stderr, "Unknown output %q, expected %s or %s\n", o.output, outputJSON, outputTable)
+		return exitUsage
 	}
-	defer conn.Close()
-	userService := grpcClient.NewClient(conn)
 
-	flag.Parse()
-	args := flag.Args()
-	cmd := args[0]
-	args = args[1:]
+	cmd, ok := findCommand(fs.Arg(0))
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %q\n\n", fs.Arg(0))
+		fs.Usage()
+		return exitUsage
+	}
 
-	switch cmd {
-	case "createUser":
-		var username, email, password string
+	conn, err := dial(o)
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer conn.Close()
 
-		username = args[0]
-		email = args[1]
-		password = args[2]
-		createUser(ctx, userService, username, email, password)
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
 
-	default:
-		log.Fatalln("unknown command")
+	c := &cli{
+		user:        grpcClient.NewClient(conn),
+		output:      o.output,
+		credentials: o.credentials,
+		cmd:         cmd,
+		stdout:      stdout,
+		stderr:      stderr,
 	}
+	return fail(stderr, cmd.run(c, ctx, fs.Args()[1:]))
 }
 
-func createUser(ctx context.Context, service service.User, username, email, password string) {
-	user, err := service.CreateUser(ctx, username, email, password)
+// dial connects to the service, in plain text unless TLS is asked for
+func dial(o options) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if o.useTLS || o.caCert != "" || o.insecureSkipVerify {
+		cfg := &tls.Config{
+			ServerName:         o.serverName,
+			InsecureSkipVerify: o.insecureSkipVerify,
+		}
+		if o.caCert != "" {
+			pem, err := os.ReadFile(o.caCert)
+			if err != nil {
+				return nil, errors.Wrap(err, "Failed to read CA certificate")
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.Errorf("No certificates found in %s", o.caCert)
+			}
+			cfg.RootCAs = pool
+		}
+		creds = grpcCredentials.NewTLS(cfg)
+	}
+
+	conn, err := grpc.NewClient(o.addr, grpc.WithTransportCredentials(creds))
 	if err != nil {
-		log.Fatalln("err:", err)
+		return nil, errors.Wrap(err, "Failed to connect to "+o.addr)
 	}
+	return conn, nil
+}
 
-	fmt.Println("user:", user)
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintln(w, "Usage: client [options] <command> [command options] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-24s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Options:")
+	fs.PrintDefaults()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run client <command> --help for the command's arguments.")
 }
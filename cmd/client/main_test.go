@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/PedPet/proto/api/user"
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/endpoint"
+	userGrpc "github.com/PedPet/user/pkg/grpc"
+	"github.com/PedPet/user/pkg/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+)
+
+// serviceStub is a service answering the calls the tests make
+type serviceStub struct {
+	service.User
+}
+
+func (s serviceStub) Login(ctx context.Context, username, password string) (*model.Session, error) {
+	if password != "Swarleyfin1!" {
+		return nil, service.ErrWrongPassword
+	}
+	return &model.Session{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600, TokenType: "Bearer"}, nil
+}
+
+func (s serviceStub) UserDetails(ctx context.Context, token string) (*model.User, error) {
+	if token != "access" {
+		return nil, auth.ErrTokenSignature
+	}
+	return &model.User{ID: 1, Username: "SC7639", Email: "scott@example.com"}, nil
+}
+
+func (s serviceStub) Logout(ctx context.Context, username, refreshToken string) error {
+	if username != "SC7639" || refreshToken != "refresh" {
+		return service.ErrNotAuthorized
+	}
+	return nil
+}
+
+func (s serviceStub) ConfirmUser(ctx context.Context, username, otp string) error {
+	return service.ErrUserNotFound
+}
+
+func (s serviceStub) GetProfile(ctx context.Context, token string) (*model.Profile, error) {
+	return &model.Profile{FirstName: "Scott", Bio: "Dog person"}, nil
+}
+
+func (s serviceStub) UpdateProfile(ctx context.Context, token string, profile *model.Profile) (*model.Profile, error) {
+	return profile, nil
+}
+
+func (s serviceStub) VerifyAttribute(ctx context.Context, token, attribute, code string) error {
+	if code != "123456" {
+		return service.ErrInvalidCode
+	}
+	return nil
+}
+
+// startServer serves the stub over grpc and returns its address
+func startServer(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	e := endpoint.MakeEndpoints(serviceStub{}, config.DefaultValidation(), noop.NewTracerProvider().Tracer(""))
+	pb.RegisterUserServer(s, userGrpc.NewGRPCServer(context.Background(), e))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	addr := startServer(t)
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+
+	client := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"--addr", addr, "--credentials", credentials}, args...)
+		code := run(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, _, stderr := client("userDetails")
+	assert.Equal(t, exitUnauthenticated, code, "Expected to need to log in")
+	assert.Contains(t, stderr, "Not logged in")
+
+	code, _, _ = client("login", "SC7639", "Swarleyfin2!")
+	assert.Equal(t, exitUnauthenticated, code, "Expected the wrong password to be rejected")
+
+	code, _, _ = client("login", "SC7639")
+	assert.Equal(t, exitUsage, code, "Expected the missing password to be a usage error")
+
+	code, stdout, _ := client("login", "SC7639", "Swarleyfin1!")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "accessToken")
+
+	creds, err := loadCredentials(credentials)
+	require.NoError(t, err)
+	assert.Equal(t, "SC7639", creds.Username)
+	assert.Equal(t, "refresh", creds.RefreshToken)
+
+	code, stdout, _ = client("--output", "json", "userDetails")
+	require.Equal(t, exitOK, code)
+	var user userResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &user))
+	assert.Equal(t, userResult{ID: 1, Username: "SC7639", Email: "scott@example.com"}, user)
+
+	code, _, _ = client("userDetails", "--token", "forged")
+	assert.Equal(t, exitUnauthenticated, code, "Expected the given token to be used")
+
+	code, stdout, _ = client("--output", "json", "updateProfile", "--bio", "Cat person", "--date-of-birth", "1990-03-14")
+	require.Equal(t, exitOK, code)
+	var profile profileResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &profile))
+	assert.Equal(t, "Scott", profile.FirstName, "Expected the fields not given to be kept")
+	assert.Equal(t, "Cat person", profile.Bio)
+	assert.Equal(t, "1990-03-14", profile.DateOfBirth)
+
+	code, _, _ = client("updateProfile", "--date-of-birth", "14/03/1990")
+	assert.Equal(t, exitUsage, code)
+
+	code, _, _ = client("verifyAttribute", "email")
+	assert.Equal(t, exitUsage, code, "Expected the missing code to be a usage error")
+
+	code, _, _ = client("verifyAttribute", "email", "654321")
+	assert.Equal(t, exitInvalid, code)
+
+	code, _, _ = client("verifyAttribute", "email", "123456")
+	assert.Equal(t, exitOK, code)
+
+	code, _, stderr = client("confirmUser", "SC7639", "12")
+	assert.Equal(t, exitInvalid, code)
+	assert.Contains(t, stderr, "code:", "Expected the invalid field to be listed")
+
+	code, _, _ = client("confirmUser", "SC7640", "123456")
+	assert.Equal(t, exitNotFound, code)
+
+	code, _, _ = client("logout")
+	require.Equal(t, exitOK, code)
+	_, err = loadCredentials(credentials)
+	assert.Equal(t, errNotLoggedIn, err, "Expected the credentials to be removed")
+
+	code, _, _ = client("unknown")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestPrintTable(t *testing.T) {
+	var out bytes.Buffer
+	err := printTable(&out, &config.Password{
+		Required: true,
+		Length:   config.Length{Min: 8, Max: 64},
+		Regex:    []string{"[a-z]", "[0-9]"},
+	})
+	require.NoError(t, err)
+
+	expected := "required    true\n" +
+		"length.min  8\n" +
+		"length.max  64\n" +
+		"regex       [a-z], [0-9]\n"
+	assert.Equal(t, expected, out.String())
+}
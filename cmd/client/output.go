@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	outputJSON  = "json"
+	outputTable = "table"
+)
+
+// print writes a command's result in the chosen output
+func (c *cli) print(v interface{}) error {
+	if c.output == outputJSON {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	return printTable(c.stdout, v)
+}
+
+// printTable writes a field per line, named by their json tags. Nested structs are flattened with
+// their fields named parent.field.
+func printTable(w io.Writer, v interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	writeRows(tw, "", reflect.ValueOf(v))
+	return tw.Flush()
+}
+
+func writeRows(w io.Writer, prefix string, v reflect.Value) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct || v.Type() == reflect.TypeOf(time.Time{}) {
+		fmt.Fprintf(w, "%s\t%s\n", prefix, formatValue(v))
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		writeRows(w, name, v.Field(i))
+	}
+}
+
+func formatValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.Format(time.RFC3339)
+	}
+
+	if v.Kind() == reflect.Slice {
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = formatValue(v.Index(i))
+		}
+		return strings.Join(values, ", ")
+	}
+	return fmt.Sprint(v.Interface())
+}
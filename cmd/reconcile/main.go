@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/logging"
+	"github.com/PedPet/user/pkg/mail"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/PedPet/user/pkg/service"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// reconcile compares the users table with the identity provider's users once, fixing the differences
+// unless --dry-run is given, and prints a summary. --link-subs-only only links rows to their sub, leaving
+// every other difference. It exits with 1 when any difference couldn't be checked or fixed.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the differences without fixing them")
+	linkSubsOnly := flag.Bool("link-subs-only", false, "only link rows to their sub, never create, delete or rename rows")
+	output := flag.String("output", "text", "format of the report, text or json")
+	flag.Parse()
+
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output %q, expected text or json\n", *output)
+		os.Exit(2)
+	}
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.NewSyncLogger(logger)
+		logger = logging.NewRedactingLogger(logger)
+		logger = log.With(logger,
+			"service", "reconcile",
+			"time:", log.DefaultTimestampUTC,
+			"caller", log.DefaultCaller,
+		)
+	}
+
+	settings, err := config.LoadSettings()
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(1)
+	}
+
+	dbSource := settings.DB.User + ":" + settings.DB.Password +
+		"@tcp(" + settings.DB.Host + ")/" + settings.DB.Database + "?parseTime=true"
+	db, err := sql.Open("mysql", dbSource)
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cc, err := identityProvider(ctx, settings, db, logger)
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(1)
+	}
+
+	users := repository.NewRepo(db, logger)
+	signUps := repository.NewSignUpRepo(db, logger)
+	reconciler := service.NewReconciler(users, signUps, cc, settings.Reconcile, logger)
+
+	reconcile := reconciler.Reconcile
+	if *linkSubsOnly {
+		reconcile = reconciler.LinkSubs
+	}
+	report, err := reconcile(ctx, *dryRun)
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(1)
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printReport(os.Stdout, report)
+	}
+
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// identityProvider creates the identity provider in the settings, as the server does
+func identityProvider(
+	ctx context.Context,
+	settings *config.Settings,
+	db *sql.DB,
+	logger log.Logger,
+) (service.CognitoClient, error) {
+	switch settings.Identity.Provider {
+	case config.ProviderLocal:
+		// Reconciling never sends mail
+		identities := repository.NewIdentityRepo(db, logger)
+		return service.NewLocalClient(identities, mail.NewLogMailer(logger), settings.Identity.Local, logger)
+
+	case config.ProviderCognito, "":
+		conf := &aws.Config{
+			Region:      aws.String("eu-west-1"),
+			Credentials: credentials.NewStaticCredentials(settings.Aws.AccessKeyID, settings.Aws.SecretAccessKey, ""),
+		}
+		if settings.Aws.Endpoint != "" {
+			conf.Endpoint = aws.String(settings.Aws.Endpoint)
+		}
+		sess, err := session.NewSession(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		return service.NewCognitoClient(ctx, cognito.New(sess), settings.Aws, auth.DiscardJWKSMetrics(), logger)
+	}
+
+	return nil, fmt.Errorf("unknown identity provider %s", settings.Identity.Provider)
+}
+
+func printReport(w io.Writer, report *service.ReconcileReport) {
+	fix := "fixed"
+	if report.DryRun {
+		fix = "dry run, not fixed"
+	}
+
+	fmt.Fprintf(w, "Identity provider users: %d\n", report.IdentityUsers)
+	fmt.Fprintf(w, "Stored users:            %d\n", report.StoredUsers)
+	if report.SubsOnly {
+		fmt.Fprintf(w, "Only linking subs, other differences weren't checked\n")
+	}
+
+	fmt.Fprintf(w, "\nMissing rows (%s): %d\n", fix, len(report.MissingRows))
+	for _, username := range report.MissingRows {
+		fmt.Fprintf(w, "  %s\n", username)
+	}
+
+	fmt.Fprintf(w, "\nOrphaned rows (%s): %d\n", fix, len(report.OrphanedRows))
+	for _, user := range report.OrphanedRows {
+		fmt.Fprintf(w, "  #%d %s\n", user.ID, user.Username)
+	}
+
+	fmt.Fprintf(w, "\nUsername mismatches (%s): %d\n", fix, len(report.Mismatches))
+	for _, mismatch := range report.Mismatches {
+		fmt.Fprintf(w, "  #%d %s -> %s\n", mismatch.ID, mismatch.Stored, mismatch.Identity)
+	}
+
+	fmt.Fprintf(w, "\nRows not linked to their sub (%s): %d\n", fix, len(report.SubMismatches))
+	for _, mismatch := range report.SubMismatches {
+		stored := mismatch.Stored
+		if stored == "" {
+			stored = "none"
+		}
+		fmt.Fprintf(w, "  #%d %s %s -> %s\n", mismatch.ID, mismatch.Username, stored, mismatch.Identity)
+	}
+
+	fmt.Fprintf(w, "\nLeft to sign up recovery: %d\n", len(report.InProgress))
+	for _, username := range report.InProgress {
+		fmt.Fprintf(w, "  %s\n", username)
+	}
+
+	fmt.Fprintf(w, "\nFixed: %d\n", report.Fixed)
+	fmt.Fprintf(w, "Errors: %d\n", len(report.Errors))
+	for _, err := range report.Errors {
+		fmt.Fprintf(w, "  %s\n", err)
+	}
+}
@@ -5,16 +5,23 @@ import (
 	"database/sql"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	pb "github.com/PedPet/proto/api/user"
 	"github.com/PedPet/user/config"
 	"github.com/PedPet/user/pkg/endpoint"
 	userGrpc "github.com/PedPet/user/pkg/grpc"
+	"github.com/PedPet/user/pkg/health"
+	userHttp "github.com/PedPet/user/pkg/http"
+	"github.com/PedPet/user/pkg/logging"
+	"github.com/PedPet/user/pkg/mail"
 	"github.com/PedPet/user/pkg/repository"
 	"github.com/PedPet/user/pkg/service"
+	"github.com/PedPet/user/pkg/tracing"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -22,17 +29,30 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	_ "github.com/go-sql-driver/mysql"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
 	grpcAddr := os.Getenv("PORT")
+	httpAddr := os.Getenv("HTTP_PORT")
+	if httpAddr == "" {
+		httpAddr = "8081"
+	}
+	metricsAddr := os.Getenv("METRICS_PORT")
+	if metricsAddr == "" {
+		metricsAddr = "9090"
+	}
 
 	// Instantiate logger
 	var logger log.Logger
 	{
 		logger = log.NewLogfmtLogger(os.Stderr)
 		logger = log.NewSyncLogger(logger)
+		logger = logging.NewRedactingLogger(logger)
 		logger = log.With(logger,
 			"service", "login",
 			"time:", log.DefaultTimestampUTC,
@@ -55,7 +75,7 @@ func main() {
 		var err error
 
 		dbSource := settings.DB.User + ":" + settings.DB.Password +
-			"@tcp(" + settings.DB.Host + ")/" + settings.DB.Database
+			"@tcp(" + settings.DB.Host + ")/" + settings.DB.Database + "?parseTime=true"
 		db, err = sql.Open("mysql", dbSource)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
@@ -69,15 +89,60 @@ func main() {
 		}
 	}
 
-	ctx := context.Background()
+	// ctx is cancelled on shutdown, stopping background work such as the key set refresher
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Instantiate tracing, spans are sent to the exporter in the settings
+	tracerProvider, err := tracing.NewTracerProvider(ctx, settings.Tracing, "user")
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(-1)
+	}
+	tracer := tracerProvider.Tracer(tracing.InstrumentationName)
+
+	// Instantiate metrics, served from /metrics
+	serviceMetrics := newRequestMetrics("service", "requests")
+	cognitoMetrics := newRequestMetrics("identity", "calls to the identity provider")
+	jwksMetrics := newJWKSMetrics()
+	stdprometheus.MustRegister(newDBStatsCollector(db))
 
-	// Instantiate conginto client
+	// Instantiate health checks, the user service only serves while its dependencies are healthy
+	healthServer := grpchealth.NewServer()
+	checker := health.NewChecker(healthServer, []string{"User"}, settings.Health.Interval, settings.Health.Timeout, logger)
+	checker.Add("mysql", db.PingContext)
+
+	// Instantiate the identity provider, either aws cognito or our self-hosted one
 	var cc service.CognitoClient
-	{
+	switch settings.Identity.Provider {
+	case config.ProviderLocal:
+		var mailer mail.Mailer
+		switch {
+		case settings.Identity.Local.SMTP.Host != "":
+			mailer = mail.NewSMTPMailer(settings.Identity.Local.SMTP)
+		case settings.Identity.Local.LogMailer:
+			level.Warn(logger).Log("msg", "emails are written to the log, verification codes included, don't use in production")
+			mailer = mail.NewLogMailer(logger)
+		default:
+			level.Error(logger).Log("exit", "the local identity provider needs smtp.host, or logMailer in development")
+			os.Exit(-1)
+		}
+
+		identities := repository.NewIdentityRepo(db, logger)
+		cc, err = service.NewLocalClient(identities, mailer, settings.Identity.Local, logger)
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+
+	case config.ProviderCognito, "":
 		conf := &aws.Config{
 			Region:      aws.String("eu-west-1"),
 			Credentials: credentials.NewStaticCredentials(settings.Aws.AccessKeyID, settings.Aws.SecretAccessKey, ""),
 		}
+		if settings.Aws.Endpoint != "" {
+			conf.Endpoint = aws.String(settings.Aws.Endpoint)
+		}
 		sess, err := session.NewSession(conf)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
@@ -85,29 +150,59 @@ func main() {
 		}
 
 		identity := cognito.New(sess)
-		cc, err = service.NewCognitoClient(identity, settings.Aws, logger)
+		cc, err = service.NewCognitoClient(ctx, identity, settings.Aws, jwksMetrics, logger)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+
+	default:
+		level.Error(logger).Log("exit", "unknown identity provider "+settings.Identity.Provider)
+		os.Exit(-1)
+	}
+	if hc, ok := cc.(service.HealthChecker); ok {
+		for name, check := range hc.HealthChecks() {
+			checker.Add(name, check)
 		}
 	}
+	cc = service.InstrumentingCognitoMiddleware(cognitoMetrics.requests, cognitoMetrics.errors, cognitoMetrics.latency)(cc)
+	cc = service.TracingCognitoMiddleware(tracer)(cc)
 
-	// Instantiate service
+	// Instantiate service, the recovery of sign ups it left unfinished and the reconciliation of the
+	// users table with the identity provider
 	var srv service.User
+	var recovery *service.SignUpRecovery
+	var reconciler *service.Reconciler
 	{
-		repository := repository.NewRepo(db, logger)
-		srv = service.NewUserService(repository, cc, logger)
+		sessions := repository.NewSessionRepo(db, logger)
+		signUps := repository.NewSignUpRepo(db, logger)
+		profiles := repository.NewProfileRepo(db, logger)
+		repository := repository.NewTracingRepo(repository.NewRepo(db, logger), tracer)
+		srv = service.NewUserService(repository, sessions, signUps, profiles, cc, logger)
+		recovery = service.NewSignUpRecovery(repository, signUps, cc, settings.SignUp, logger)
+		reconciler = service.NewReconciler(repository, signUps, cc, settings.Reconcile, logger)
+		srv = service.LoggingMiddleware(logger)(srv)
+		srv = service.InstrumentingMiddleware(serviceMetrics.requests, serviceMetrics.errors, serviceMetrics.latency)(srv)
 	}
 
 	errs := make(chan error)
-	// Notify if sigterm ctrl+c is pressed to cancel running service
+	// Notify if sigterm ctrl+c is pressed to stop the running service. It's reported unhealthy first and
+	// keeps serving for the drain delay, so load balancers stop routing to it before it stops accepting.
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		errs <- fmt.Errorf("%s", <-c)
+		sig := <-c
+		checker.Shutdown()
+		level.Info(logger).Log("msg", "draining", "signal", sig, "delay", settings.Server.DrainDelay)
+		time.Sleep(settings.Server.DrainDelay)
+		errs <- fmt.Errorf("%s", sig)
 	}()
 
 	// Start service running
-	endpoints := endpoint.MakeEndpoints(srv)
+	endpoints := endpoint.MakeEndpoints(srv, settings.Validation, tracer)
+	gRPCServer := grpc.NewServer(grpc.UnaryInterceptor(userGrpc.TracingInterceptor(tracer)))
+	pb.RegisterUserServer(gRPCServer, userGrpc.NewGRPCServer(ctx, endpoints))
+	healthpb.RegisterHealthServer(gRPCServer, healthServer)
 	go func() {
 		listener, err := net.Listen("tcp", ":"+grpcAddr)
 		if err != nil {
@@ -115,11 +210,50 @@ func main() {
 			return
 		}
 
-		handler := userGrpc.NewGRPCServer(ctx, endpoints)
-		gRPCServer := grpc.NewServer()
-		pb.RegisterUserServer(gRPCServer, handler)
 		errs <- gRPCServer.Serve(listener)
 	}()
 
+	// The same endpoints as JSON REST routes for the web front end
+	httpServer := &http.Server{Addr: ":" + httpAddr, Handler: userHttp.NewHTTPHandler(endpoints, tracer)}
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	go checker.Run(ctx)
+	go recovery.Run(ctx)
+	go reconciler.Run(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{Addr: ":" + metricsAddr, Handler: mux}
+	go func() {
+		errs <- metricsServer.ListenAndServe()
+	}()
+
 	level.Error(logger).Log("exit", <-errs)
+
+	// Stop taking traffic and let in-flight requests finish, a sign up dropped between cognito and
+	// mysql leaves them out of step. Only what's still running at the deadline is cut off. The background
+	// work is cancelled once the requests are done.
+	checker.Shutdown()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), settings.Server.ShutdownTimeout)
+	defer cancelShutdown()
+
+	drained := make(chan struct{})
+	go func() {
+		gRPCServer.GracefulStop()
+		close(drained)
+	}()
+	httpServer.Shutdown(shutdownCtx)
+	select {
+	case <-drained:
+	case <-shutdownCtx.Done():
+		level.Warn(logger).Log("msg", "shutdown deadline passed, dropping in-flight requests")
+		gRPCServer.Stop()
+	}
+	cancel()
+
+	metricsServer.Shutdown(shutdownCtx)
+	tracerProvider.Shutdown(shutdownCtx)
+	db.Close()
 }
@@ -2,27 +2,41 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"io/ioutil"
 	logg "log"
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	pb "github.com/PedPet/proto/api/user"
 	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/pkg/auth"
 	"github.com/PedPet/user/pkg/endpoint"
 	grpcClient "github.com/PedPet/user/pkg/grpc"
 	userGrpc "github.com/PedPet/user/pkg/grpc"
+	"github.com/PedPet/user/pkg/health"
+	"github.com/PedPet/user/pkg/logging"
 	"github.com/PedPet/user/pkg/repository"
 	"github.com/PedPet/user/pkg/service"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/PedPet/user/pkg/service/cognitotest"
+	"github.com/PedPet/user/pkg/tracing"
 	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 	"github.com/bxcodec/faker/v3"
 	"github.com/go-kit/kit/log"
 	_ "github.com/go-sql-driver/mysql"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
 
@@ -41,11 +55,20 @@ var mockGBL sqlmock.Sqlmock
 var username string = faker.Username()
 var password string = faker.Password() + "1!"
 var jwt string
+var refreshToken string
+var fakeCognito *cognitotest.Server
+var spans *tracetest.SpanRecorder
+var checker *health.Checker
 
 func init() {
 	ctx = context.Background()
 	lis = bufconn.Listen(bufSize)
-	s := grpc.NewServer()
+
+	// Instantiate tracer, recording spans so tests can check them
+	spans = tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer(tracing.InstrumentationName)
+
+	s := grpc.NewServer(grpc.UnaryInterceptor(userGrpc.TracingInterceptor(tracer)))
 
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -60,39 +83,61 @@ func init() {
 		logger = LoggerStub{}
 	}
 
-	settings, err := config.LoadSettings()
-	if err != nil {
-		logg.Fatalf("Failed to load settings: %v", err)
-	}
-
-	// Instantiate conginto client
+	// Instantiate conginto client against an in-process fake user pool
 	var cc service.CognitoClient
 	{
-		conf := &aws.Config{
-			Region:      aws.String("eu-west-1"),
-			Credentials: credentials.NewStaticCredentials(settings.Aws.AccessKeyID, settings.Aws.SecretAccessKey, ""),
-		}
-		sess, err := session.NewSession(conf)
+		fakeCognito = cognitotest.NewServer()
+		sess, err := fakeCognito.Session()
 		if err != nil {
 			logg.Fatalf("Failed to create new sessions: %v", err)
 		}
 
 		identity := cognito.New(sess)
-		cc, err = service.NewCognitoClient(identity, settings.Aws, logger)
+		cc, err = service.NewCognitoClient(ctx, identity, fakeCognito.Settings(), auth.DiscardJWKSMetrics(), logger)
 		if err != nil {
 			logg.Fatalf("Failed to create cognito identity: %v", err)
 		}
+
+		// Instantiate health checks of the identity provider, the database is a mock
+		healthServer := grpchealth.NewServer()
+		healthpb.RegisterHealthServer(s, healthServer)
+		checker = health.NewChecker(healthServer, []string{"User"}, 0, 0, logger)
+		for name, check := range cc.(service.HealthChecker).HealthChecks() {
+			checker.Add(name, check)
+		}
+
+		cc = service.TracingCognitoMiddleware(tracer)(cc)
 	}
 
 	// Instantiate service
 	var srv service.User
 	{
-		repository := repository.NewRepo(db, logger)
-		srv = service.NewUserService(repository, cc, logger)
+		sessions := repository.NewSessionRepo(db, logger)
+		signUps := repository.NewSignUpRepo(db, logger)
+		profiles := repository.NewProfileRepo(db, logger)
+		repository := repository.NewTracingRepo(repository.NewRepo(db, logger), tracer)
+		srv = service.NewUserService(repository, sessions, signUps, profiles, cc, logger)
+		srv = service.LoggingMiddleware(logger)(srv)
+	}
+
+	var rules config.Validation
+	{
+		validation, err := ioutil.ReadFile("../../config/validation.json")
+		if err != nil {
+			logg.Fatalf("Failed to open validation.json: %v", err)
+		}
+		err = json.Unmarshal(validation, &rules)
+		if err != nil {
+			logg.Fatalf("Failed to decode validation.json: %v", err)
+		}
+		err = rules.Compile()
+		if err != nil {
+			logg.Fatalf("Failed to compile validation.json: %v", err)
+		}
 	}
 
 	go func() {
-		endpoints := endpoint.MakeEndpoints(srv)
+		endpoints := endpoint.MakeEndpoints(srv, rules, tracer)
 		handler := userGrpc.NewGRPCServer(ctx, endpoints)
 		pb.RegisterUserServer(s, handler)
 
@@ -117,10 +162,14 @@ func TestCreateUser(t *testing.T) {
 
 	svc := grpcClient.NewClient(conn)
 
+	mockGBL.ExpectExec("INSERT IGNORE INTO sign_ups").
+		WithArgs(username, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	mockGBL.ExpectPrepare("INSERT INTO users").
 		ExpectExec().
-		WithArgs(username).
+		WithArgs(username, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockGBL.ExpectExec("DELETE FROM sign_ups").WithArgs(username).WillReturnResult(sqlmock.NewResult(0, 1))
 
 	_, err = svc.CreateUser(ctx, username, "scrott@gmail.com", password)
 	if err != nil {
@@ -128,8 +177,7 @@ func TestCreateUser(t *testing.T) {
 	}
 }
 
-func TestConfirmUser(t *testing.T) {
-	t.Skip("Has to be set up from pervious test")
+func TestResendConfirmation(t *testing.T) {
 	ctx := context.Background()
 
 	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
@@ -139,13 +187,13 @@ func TestConfirmUser(t *testing.T) {
 	defer conn.Close()
 
 	svc := grpcClient.NewClient(conn)
-	err = svc.ConfirmUser(ctx, username, "")
+	err = svc.ResendConfirmation(ctx, username)
 	if err != nil {
-		t.Fatalf("Failed to confirm user: %v", err)
+		t.Fatalf("Failed to resend confirmation: %v", err)
 	}
 }
 
-func TestResendConfirmation(t *testing.T) {
+func TestConfirmUser(t *testing.T) {
 	ctx := context.Background()
 
 	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
@@ -155,9 +203,9 @@ func TestResendConfirmation(t *testing.T) {
 	defer conn.Close()
 
 	svc := grpcClient.NewClient(conn)
-	err = svc.ResendConfirmation(ctx, username)
+	err = svc.ConfirmUser(ctx, username, fakeCognito.Code(username))
 	if err != nil {
-		t.Fatalf("Failed to resend confirmation: %v", err)
+		t.Fatalf("Failed to confirm user: %v", err)
 	}
 }
 
@@ -195,20 +243,46 @@ func TestLogin(t *testing.T) {
 	defer conn.Close()
 
 	svc := grpcClient.NewClient(conn)
-	jwt, err = svc.Login(ctx, username, password)
+	session, err := svc.Login(ctx, username, password)
 	if err != nil {
-		if strings.Contains(err.Error(), "UserNotConfirmedException") {
+		if err == service.ErrUserNotConfirmed {
 			t.Fatal("User is not confirmed")
 		}
 		t.Fatalf("Failed to login: %s", err)
 	}
 
+	jwt = session.AccessToken
+	refreshToken = session.RefreshToken
 	t.Logf("JWT: %s", jwt)
 }
 
-func TestVerifyJWT(t *testing.T) {
-	t.Skip("Needs to be set up with a JWT")
+func TestRefreshSession(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	if refreshToken == "" {
+		t.Fatalf("Refresh token is empty: %s", refreshToken)
+	}
+
+	mockGBL.ExpectQuery("SELECT id FROM revoked_tokens").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	svc := grpcClient.NewClient(conn)
+	session, err := svc.RefreshSession(ctx, username, refreshToken)
+	if err != nil {
+		t.Fatalf("Failed to refresh session: %s", err)
+	}
 
+	if session.AccessToken == "" || session.IDToken == "" {
+		t.Fatalf("Refreshed session is missing tokens: %v", session)
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
 	ctx := context.Background()
 
 	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
@@ -217,18 +291,26 @@ func TestVerifyJWT(t *testing.T) {
 	}
 	defer conn.Close()
 
+	mockGBL.ExpectQuery("SELECT signed_out_at FROM sign_outs").WillReturnRows(sqlmock.NewRows([]string{"signed_out_at"}))
+
 	svc := grpcClient.NewClient(conn)
-	verified, err := svc.VerifyJWT(ctx, jwt)
+	claims, err := svc.VerifyJWT(ctx, jwt)
 	if err != nil {
 		t.Fatalf("Failed to verify JWT: %s", err)
 	}
 
-	t.Logf("Verified: %v", verified)
+	if claims.Username != username || claims.Subject == "" {
+		t.Fatalf("Verified claims don't identify the user: %v", claims)
+	}
+	if len(claims.Scopes) == 0 {
+		t.Fatalf("Verified access token has no scopes: %v", claims)
+	}
+	if !claims.ExpiresAt.After(claims.IssuedAt) {
+		t.Fatalf("Verified claims have an invalid lifetime: %v", claims)
+	}
 }
 
 func TestUserDetails(t *testing.T) {
-	t.Skip("Needs to be set up with a JWT")
-
 	ctx := context.Background()
 
 	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
@@ -243,7 +325,7 @@ func TestUserDetails(t *testing.T) {
 
 	rows := sqlmock.NewRows([]string{"id"}).
 		AddRow(1)
-	mockGBL.ExpectQuery("SELECT").WillReturnRows(rows)
+	mockGBL.ExpectQuery("SELECT id FROM users WHERE cognito_sub").WillReturnRows(rows)
 
 	svc := grpcClient.NewClient(conn)
 	user, err := svc.UserDetails(ctx, jwt)
@@ -253,3 +335,298 @@ func TestUserDetails(t *testing.T) {
 
 	t.Logf("User: %v", user)
 }
+
+func TestUserDetailsTrace(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	mockGBL.ExpectQuery("SELECT id FROM users WHERE cognito_sub").WillReturnRows(rows)
+
+	svc := grpcClient.NewClient(conn)
+	_, err = svc.UserDetails(ctx, jwt)
+	if err != nil {
+		t.Fatalf("Failed to get user details: %s", err)
+	}
+
+	// Each span's parent, by name, the handler's parent is the caller's span
+	ids := map[string]trace.SpanID{"caller": spanID}
+	parents := map[string]trace.SpanID{}
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			ids[span.Name()] = span.SpanContext().SpanID()
+			parents[span.Name()] = span.Parent().SpanID()
+		}
+	}
+
+	for name, parent := range map[string]string{
+		"/User/UserDetails":       "caller",
+		"endpoint.UserDetails":    "/User/UserDetails",
+		"identity.GetUserDetails": "endpoint.UserDetails",
+		"repository.GetUserBySub": "endpoint.UserDetails",
+	} {
+		if _, ok := parents[name]; !ok {
+			t.Errorf("Expected a %s span in the caller's trace", name)
+			continue
+		}
+		if parents[name] != ids[parent] {
+			t.Errorf("Expected the %s span to be a child of %s", name, parent)
+		}
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	svc := grpcClient.NewClient(conn)
+	err = svc.ForgotPassword(ctx, username)
+	if err != nil {
+		t.Fatalf("Failed to start forgot password: %s", err)
+	}
+
+	newPassword := faker.Password() + "2@"
+	err = svc.ConfirmForgotPassword(ctx, username, fakeCognito.Code(username), newPassword)
+	if err != nil {
+		t.Fatalf("Failed to reset password: %s", err)
+	}
+	password = newPassword
+
+	_, err = svc.Login(ctx, username, password)
+	if err != nil {
+		t.Fatalf("Failed to login with new password: %s", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	svc := grpcClient.NewClient(conn)
+	session, err := svc.Login(ctx, username, password)
+	if err != nil {
+		t.Fatalf("Failed to login: %s", err)
+	}
+
+	mockGBL.ExpectExec("INSERT INTO sign_outs").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	newPassword := faker.Password() + "3#"
+	changed, err := svc.ChangePassword(ctx, session.AccessToken, password, newPassword, true)
+	if err != nil {
+		t.Fatalf("Failed to change password: %s", err)
+	}
+	password = newPassword
+
+	if changed == nil || changed.AccessToken == "" {
+		t.Fatalf("Expected a new session after signing out other sessions: %v", changed)
+	}
+	jwt = changed.AccessToken
+	refreshToken = changed.RefreshToken
+
+	mockGBL.ExpectQuery("SELECT id FROM revoked_tokens").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err = svc.RefreshSession(ctx, username, session.RefreshToken)
+	if err == nil {
+		t.Fatalf("Expected the old refresh token to be revoked")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	mockGBL.ExpectExec("INSERT IGNORE INTO revoked_tokens").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	svc := grpcClient.NewClient(conn)
+	err = svc.Logout(ctx, username, refreshToken)
+	if err != nil {
+		t.Fatalf("Failed to logout: %s", err)
+	}
+
+	rows := sqlmock.NewRows([]string{"id"}).
+		AddRow(1)
+	mockGBL.ExpectQuery("SELECT id FROM revoked_tokens").WillReturnRows(rows)
+
+	_, err = svc.RefreshSession(ctx, username, refreshToken)
+	if err == nil {
+		t.Fatalf("Expected the logged out refresh token to be rejected")
+	}
+}
+
+func TestGlobalSignOut(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	mockGBL.ExpectExec("INSERT INTO sign_outs").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	svc := grpcClient.NewClient(conn)
+	err = svc.GlobalSignOut(ctx, jwt)
+	if err != nil {
+		t.Fatalf("Failed to sign out: %s", err)
+	}
+
+	// The token was issued within the same second as the sign out, so report a later sign out to
+	// check tokens issued before it are rejected
+	rows := sqlmock.NewRows([]string{"signed_out_at"}).
+		AddRow(time.Now().Add(time.Minute))
+	mockGBL.ExpectQuery("SELECT signed_out_at FROM sign_outs").WillReturnRows(rows)
+
+	_, err = svc.VerifyJWT(ctx, jwt)
+	if err == nil {
+		t.Fatalf("Expected a token issued before the sign out to be rejected")
+	}
+}
+
+func TestInvalidRequest(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	svc := grpcClient.NewClient(conn)
+	_, err = svc.CreateUser(ctx, faker.Username(), "scrott@", "short")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected an invalid argument error: %v", err)
+	}
+
+	var fields []string
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				fields = append(fields, violation.Field)
+			}
+		}
+	}
+	if strings.Join(fields, ",") != "email,password" {
+		t.Fatalf("Expected email and password field violations: %v", fields)
+	}
+}
+
+func TestGetPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	svc := grpcClient.NewClient(conn)
+	policy, err := svc.GetPasswordPolicy(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get password policy: %s", err)
+	}
+
+	if policy.Length.Min != 8 || policy.Length.Max != 100 || len(policy.Regex) != 4 {
+		t.Errorf("Password policy does not match validation.json: %+v", policy)
+	}
+}
+
+func TestServiceErrors(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	svc := grpcClient.NewClient(conn)
+	_, err = svc.Login(ctx, username, password+"wrong")
+	if err != service.ErrWrongPassword {
+		t.Errorf("Expected a wrong password error: %v", err)
+	}
+
+	err = svc.ConfirmForgotPassword(ctx, faker.Username(), "123456", password)
+	if err != service.ErrUserNotFound {
+		t.Errorf("Expected a user not found error: %v", err)
+	}
+
+	// Without the client's translation the code is still usable by other grpc clients
+	_, err = pb.NewUserClient(conn).Login(ctx, &pb.LoginRequest{Username: username, Password: password + "wrong"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected an unauthenticated status: %v", err)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	var header metadata.MD
+	ctx = metadata.AppendToOutgoingContext(ctx, logging.RequestIDHeader, "test-request")
+	_, err = pb.NewUserClient(conn).GetPasswordPolicy(ctx, &pb.GetPasswordPolicyRequest{}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("Failed to get password policy: %s", err)
+	}
+
+	if ids := header.Get(logging.RequestIDHeader); len(ids) != 1 || ids[0] != "test-request" {
+		t.Errorf("Expected the request id to be returned: %v", ids)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+
+	conn, err := grpc.DialContext(ctx, "User", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %s", err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	if !checker.CheckAll(ctx) {
+		t.Fatal("Expected the identity provider to be healthy")
+	}
+
+	for _, service := range []string{"", "User", "cognito", "jwks"} {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Failed to check health of %q: %s", service, err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Expected %q to be serving: %s", service, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "pedpet_user"
+
+// requestMetrics are what the service and identity provider instrumenting middlewares record
+type requestMetrics struct {
+	requests metrics.Counter
+	errors   metrics.Counter
+	latency  metrics.Histogram
+}
+
+// newRequestMetrics creates the request metrics of the subsystem, what describes the requests in the
+// metrics' help
+func newRequestMetrics(subsystem, what string) requestMetrics {
+	return requestMetrics{
+		requests: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Number of " + what + ".",
+		}, []string{"method"}),
+		errors: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Number of failed " + what + ", by kind of error.",
+		}, []string{"method", "error"}),
+		latency: kitprometheus.NewHistogramFrom(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Time taken by " + what + ".",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"method"}),
+	}
+}
+
+func newJWKSMetrics() auth.JWKSMetrics {
+	return auth.JWKSMetrics{
+		RefreshFailures: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "jwks",
+			Name:      "refresh_failures_total",
+			Help:      "Number of failed refreshes of the JSON web key set.",
+		}, nil),
+		Lookups: kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "jwks",
+			Name:      "lookups_total",
+			Help:      "Number of key lookups, by whether the key was cached.",
+		}, []string{"result"}),
+		KeyAge: kitprometheus.NewGaugeFrom(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "jwks",
+			Name:      "key_age_seconds",
+			Help:      "Time since the JSON web key set was last fetched.",
+		}, nil),
+	}
+}
+
+// dbStatsCollector exposes the database connection pool's stats
+type dbStatsCollector struct {
+	db *sql.DB
+
+	maxOpen      *prometheus.Desc
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func newDBStatsCollector(db *sql.DB) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, nil, nil)
+	}
+
+	return &dbStatsCollector{
+		db:           db,
+		maxOpen:      desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:         desc("open_connections", "The number of established connections both in use and idle."),
+		inUse:        desc("in_use_connections", "The number of connections currently in use."),
+		idle:         desc("idle_connections", "The number of idle connections."),
+		waitCount:    desc("wait_count_total", "The total number of connections waited for."),
+		waitDuration: desc("wait_duration_seconds_total", "The total time blocked waiting for a new connection."),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
@@ -4,12 +4,19 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
+	"time"
 
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
 const settingsPath = "/app/config"
 
+const defaultShutdownTimeout = 30 * time.Second
+
+const defaultDrainDelay = 5 * time.Second
+
 var settingsFiles = map[string]string{
 	"development": path.Join(settingsPath, "appConfig.dev.yml"),
 	"production":  path.Join(settingsPath, "appConfig.yml"),
@@ -23,6 +30,15 @@ type AWSSettings struct {
 	CognitoAppClientID  string `yaml:"cognitoAppClientID"`
 	CognitoClientSecret string `yaml:"cognitoClientSecret"`
 	Region              string `yaml:"region"`
+	// Endpoint and JWKSURL override the AWS defaults, e.g. to point at a local stand-in
+	Endpoint string `yaml:"endpoint"`
+	JWKSURL  string `yaml:"jwksURL"`
+	// JWKSRefreshInterval is how often the key set is refetched, JWKSMinRefreshInterval limits how
+	// often a token signed with an unknown key can trigger an early refetch
+	JWKSRefreshInterval    time.Duration `yaml:"jwksRefreshInterval"`
+	JWKSMinRefreshInterval time.Duration `yaml:"jwksMinRefreshInterval"`
+	// ClockSkew is how far token timestamps may be off from our clock
+	ClockSkew time.Duration `yaml:"clockSkew"`
 }
 
 // DBSettings contains the settings used for the database connection
@@ -33,25 +49,185 @@ type DBSettings struct {
 	Database string `yaml:"database"`
 }
 
+// Identity providers that can back the user service
+const (
+	ProviderCognito = "cognito"
+	ProviderLocal   = "local"
+)
+
+// IdentitySettings selects which identity provider the user service uses
+type IdentitySettings struct {
+	Provider string        `yaml:"provider"`
+	Local    LocalSettings `yaml:"local"`
+}
+
+// LocalSettings contains the settings used for the self-hosted identity provider
+type LocalSettings struct {
+	Issuer          string        `yaml:"issuer"`
+	ClientID        string        `yaml:"clientID"`
+	KeyID           string        `yaml:"keyID"`
+	PrivateKeyPath  string        `yaml:"privateKeyPath"`
+	HashAlgorithm   string        `yaml:"hashAlgorithm"`
+	AccessTokenTTL  time.Duration `yaml:"accessTokenTTL"`
+	RefreshTokenTTL time.Duration `yaml:"refreshTokenTTL"`
+	CodeTTL         time.Duration `yaml:"codeTTL"`
+	ClockSkew       time.Duration `yaml:"clockSkew"`
+	SMTP            SMTPSettings  `yaml:"smtp"`
+	// LogMailer writes emails, verification codes included, to the log instead of sending them. It's for
+	// development and CI only, anyone who can read the logs could take over accounts.
+	LogMailer bool `yaml:"logMailer"`
+}
+
+// SMTPSettings contains the settings used to send emails such as verification codes
+type SMTPSettings struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// Tracing exporters spans can be sent to
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// TracingSettings selects where spans are exported, tracing is off without an exporter
+type TracingSettings struct {
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP collector's grpc address, host:port
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	// SampleRatio is the fraction of new traces that are sampled, every trace when unset. Calls that
+	// carry a trace keep the caller's sampling decision.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// ServerSettings contains the settings of the grpc server
+type ServerSettings struct {
+	// DrainDelay is how long the server keeps serving after reporting itself unhealthy on a stop signal,
+	// so load balancers stop routing to it before it stops accepting requests
+	DrainDelay time.Duration `yaml:"drainDelay"`
+	// ShutdownTimeout is how long in-flight requests have to finish once the server is stopping
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// HealthSettings sets how often the dependencies are checked and how long each check may take
+type HealthSettings struct {
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// SignUpSettings sets how often unfinished sign ups are recovered and how long a sign up is left
+// unchanged before it's taken to be interrupted
+type SignUpSettings struct {
+	Interval   time.Duration `yaml:"interval"`
+	RetryAfter time.Duration `yaml:"retryAfter"`
+}
+
+// ReconcileSettings sets how often the users table is compared with the identity provider, it isn't
+// while Interval is unset. Differences are only reported unless Fix is set.
+type ReconcileSettings struct {
+	Interval time.Duration `yaml:"interval"`
+	Fix      bool          `yaml:"fix"`
+}
+
 // Validation contains the centrealized settings for validation
 type Validation struct {
-	Password Password `json:"password"`
+	Password Password `json:"password" yaml:"password"`
+	Username Username `json:"username" yaml:"username"`
+	OTP      OTP      `json:"otp" yaml:"otp"`
+}
+
+// Length contains the bounds of a value's length
+type Length struct {
+	Min int `json:"min" yaml:"min"`
+	Max int `json:"max" yaml:"max"`
 }
 
-// Password contains the validation config
+// Password contains the validation config, Regexps are the compiled Regex set by Validation.Compile
 type Password struct {
-	Required bool `json:"required"`
-	Length   struct {
-		Min int `json:"min"`
-		Max int `json:"max"`
-	} `json:"length"`
-	Regex []string `json:"regex"`
+	Required bool             `json:"required" yaml:"required"`
+	Length   Length           `json:"length" yaml:"length"`
+	Regex    []string         `json:"regex" yaml:"regex"`
+	Regexps  []*regexp.Regexp `json:"-" yaml:"-"`
+}
+
+// Username contains the username validation config, Pattern must match the whole username and
+// PatternRegexp is the compiled Pattern set by Validation.Compile
+type Username struct {
+	Length        Length         `json:"length" yaml:"length"`
+	Pattern       string         `json:"pattern" yaml:"pattern"`
+	PatternRegexp *regexp.Regexp `json:"-" yaml:"-"`
+}
+
+// OTP contains the verification code validation config
+type OTP struct {
+	Length int  `json:"length" yaml:"length"`
+	Digits bool `json:"digits" yaml:"digits"`
+}
+
+// Compile compiles the username pattern and password regexes, so a bad pattern fails at startup
+// instead of on every request
+func (v *Validation) Compile() error {
+	v.Username.PatternRegexp = nil
+	if v.Username.Pattern != "" {
+		re, err := regexp.Compile(v.Username.Pattern)
+		if err != nil {
+			return errors.Wrap(err, "Invalid username pattern")
+		}
+		v.Username.PatternRegexp = re
+	}
+
+	v.Password.Regexps = make([]*regexp.Regexp, 0, len(v.Password.Regex))
+	for _, regex := range v.Password.Regex {
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid password regex %q", regex)
+		}
+		v.Password.Regexps = append(v.Password.Regexps, re)
+	}
+
+	return nil
+}
+
+// DefaultValidation is used for any validation settings missing from the yml file
+func DefaultValidation() Validation {
+	rules := Validation{
+		Password: Password{
+			Required: true,
+			Length:   Length{Min: 8, Max: 100},
+			Regex:    []string{"[a-z]", "[A-Z]", "[0-9]", "[^a-zA-Z0-9]"},
+		},
+		Username: Username{
+			Length:  Length{Min: 2, Max: 100},
+			Pattern: "^[a-zA-Z0-9_.@+-]+$",
+		},
+		OTP: OTP{
+			Length: 6,
+			Digits: true,
+		},
+	}
+
+	// The defaults are known to compile
+	if err := rules.Compile(); err != nil {
+		panic(err)
+	}
+	return rules
 }
 
 // Settings struct to unmarshal config yml setting
 type Settings struct {
-	Aws AWSSettings
-	DB  DBSettings
+	Server     ServerSettings
+	Aws        AWSSettings
+	DB         DBSettings
+	Identity   IdentitySettings
+	Validation Validation
+	Tracing    TracingSettings
+	Health     HealthSettings
+	SignUp     SignUpSettings
+	Reconcile  ReconcileSettings
 }
 
 var environment string = os.Getenv("Environment")
@@ -63,11 +239,19 @@ func LoadSettings() (*Settings, error) {
 		return nil, err
 	}
 
-	settings := &Settings{}
+	settings := &Settings{
+		Server:     ServerSettings{DrainDelay: defaultDrainDelay, ShutdownTimeout: defaultShutdownTimeout},
+		Validation: DefaultValidation(),
+	}
 	err = yaml.Unmarshal(config, settings)
 	if err != nil {
 		return nil, err
 	}
 
+	err = settings.Validation.Compile()
+	if err != nil {
+		return nil, err
+	}
+
 	return settings, nil
 }
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationCompile(t *testing.T) {
+	rules := DefaultValidation()
+	require.NotNil(t, rules.Username.PatternRegexp)
+	assert.Len(t, rules.Password.Regexps, len(rules.Password.Regex))
+
+	rules.Username.Pattern = "^[a-z"
+	assert.Error(t, rules.Compile(), "Expected a bad username pattern to fail")
+
+	rules = DefaultValidation()
+	rules.Password.Regex = append(rules.Password.Regex, "(")
+	assert.Error(t, rules.Compile(), "Expected a bad password regex to fail")
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Claims are the verified contents of a user's token, enough for other services to authorize requests
+type Claims struct {
+	Subject   string    `json:"sub"`
+	Username  string    `json:"username"`
+	Groups    []string  `json:"groups"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	IssuedAt  time.Time `json:"issuedAt"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Identity is a user's credentials as stored by the self-hosted identity provider. CodeAttribute is the
+// attribute the current code verifies, "password" when it resets the password and empty when it's for
+// signing up.
+type Identity struct {
+	ID                  int       `json:"id,omitempty"`
+	Sub                 string    `json:"sub"`
+	Username            string    `json:"username"`
+	Email               string    `json:"email"`
+	PhoneNumber         string    `json:"phoneNumber"`
+	PasswordHash        string    `json:"-"`
+	Confirmed           bool      `json:"confirmed"`
+	EmailVerified       bool      `json:"emailVerified"`
+	PhoneNumberVerified bool      `json:"phoneNumberVerified"`
+	CodeHash            string    `json:"-"`
+	CodeAttribute       string    `json:"-"`
+	CodeExpiresAt       time.Time `json:"-"`
+	CreatedAt           time.Time `json:"createdAt"`
+}
+
+// RefreshToken is a refresh token issued by the self-hosted identity provider, only its hash is stored
+type RefreshToken struct {
+	ID        int       `json:"id,omitempty"`
+	TokenHash string    `json:"-"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Revoked   bool      `json:"revoked"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Profile is what a user tells about themselves beyond their credentials, a user who hasn't set it has
+// an empty one. DateOfBirth is zero when unset.
+type Profile struct {
+	UserID      int       `json:"userId,omitempty"`
+	FirstName   string    `json:"firstName"`
+	LastName    string    `json:"lastName"`
+	DisplayName string    `json:"displayName"`
+	AvatarURL   string    `json:"avatarUrl"`
+	Bio         string    `json:"bio"`
+	Location    string    `json:"location"`
+	Locale      string    `json:"locale"`
+	Timezone    string    `json:"timezone"`
+	DateOfBirth time.Time `json:"dateOfBirth"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
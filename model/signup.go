@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// Sign up states, a sign up is recorded as pending before the user is registered with the identity
+// provider and as failed when the registration couldn't be rolled back
+const (
+	SignUpPending = "pending"
+	SignUpFailed  = "failed"
+)
+
+// SignUp is a sign up that hasn't finished, the record is removed once the user is stored or the
+// registration is rolled back
+type SignUp struct {
+	ID        int       `json:"id,omitempty"`
+	Username  string    `json:"username"`
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -1,10 +1,28 @@
 package model
 
+// The attributes of a user that are verified with a code sent to them
+const (
+	AttributeEmail       = "email"
+	AttributePhoneNumber = "phone_number"
+)
+
+// User is a user's account. Confirmed is whether they confirmed their sign up, EmailVerified and
+// PhoneNumberVerified whether the current values were verified, which they stop being when changed.
 type User struct {
-	ID          int    `json:"id,omitempty"`
-	Username    string `json:"username"`
-	Password    string `json:"password"`
-	Email       string `json:"email"`
-	PhoneNumber string `json:"phoneNumber"`
-	Confirmed   bool   `json:"confirmed"`
+	ID                  int    `json:"id,omitempty"`
+	Sub                 string `json:"sub,omitempty"`
+	Username            string `json:"username"`
+	Password            string `json:"password"`
+	Email               string `json:"email"`
+	PhoneNumber         string `json:"phoneNumber"`
+	Confirmed           bool   `json:"confirmed"`
+	EmailVerified       bool   `json:"emailVerified"`
+	PhoneNumberVerified bool   `json:"phoneNumberVerified"`
+}
+
+// CodeDelivery is where a verification code was sent, the destination is masked
+type CodeDelivery struct {
+	AttributeName  string `json:"attributeName"`
+	DeliveryMedium string `json:"deliveryMedium"`
+	Destination    string `json:"destination"`
 }
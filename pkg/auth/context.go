@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/PedPet/user/model"
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the verified claims
+func NewContext(ctx context.Context, claims *model.Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the verified claims put in the context by the interceptors
+func FromContext(ctx context.Context) (*model.Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*model.Claims)
+	return claims, ok
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationKey is the metadata key clients send their token under, as "Bearer <token>"
+const authorizationKey = "authorization"
+
+// UnaryServerInterceptor rejects calls without a valid bearer token with codes.Unauthenticated and
+// puts the token's claims into the handler's context
+func UnaryServerInterceptor(v *Verifier) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := v.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams without a valid bearer token with codes.Unauthenticated and
+// puts the token's claims into the stream's context
+func StreamServerInterceptor(v *Verifier) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := v.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate verifies the bearer token in the incoming metadata
+func (v *Verifier) authenticate(ctx context.Context) (context.Context, error) {
+	claims, err := v.Verify(bearerToken(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return NewContext(ctx, claims), nil
+}
+
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(authorizationKey)
+	if len(values) == 0 {
+		return ""
+	}
+
+	const prefix = "bearer "
+	if len(values[0]) < len(prefix) || !strings.EqualFold(values[0][:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(values[0][len(prefix):])
+}
+
+// authenticatedStream swaps the stream's context for one carrying the claims
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type serverStreamStub struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s serverStreamStub) Context() context.Context {
+	return s.ctx
+}
+
+func TestInterceptors(t *testing.T) {
+	privateKey, set := newTestKeySet(t)
+	v := NewVerifier(set, Config{Issuer: testIssuer, ClientID: testClientID})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":       "0b3b4c3e-1f1e-4d5c-9d6c-6f1b2a3c4d5e",
+		"iss":       testIssuer,
+		"client_id": testClientID,
+		"token_use": "access",
+		"username":  "SC7639",
+		"iat":       time.Now().Unix(),
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(privateKey)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		authorization []string
+		expected      codes.Code
+	}{
+		{
+			name:          "Valid",
+			authorization: []string{"Bearer " + signed},
+			expected:      codes.OK,
+		},
+		{
+			name:          "Lower case scheme",
+			authorization: []string{"bearer " + signed},
+			expected:      codes.OK,
+		},
+		{
+			name:     "Missing",
+			expected: codes.Unauthenticated,
+		},
+		{
+			name:          "Wrong scheme",
+			authorization: []string{"Basic " + signed},
+			expected:      codes.Unauthenticated,
+		},
+		{
+			name:          "Invalid token",
+			authorization: []string{"Bearer " + signed + "x"},
+			expected:      codes.Unauthenticated,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			if tc.authorization != nil {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationKey, tc.authorization[0]))
+			}
+
+			var username string
+			unary := UnaryServerInterceptor(v)
+			_, err := unary(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				claims, ok := FromContext(ctx)
+				require.True(t, ok)
+				username = claims.Username
+				return nil, nil
+			})
+			assert.Equal(t, tc.expected, status.Code(err))
+
+			stream := StreamServerInterceptor(v)
+			err = stream(nil, serverStreamStub{ctx: ctx}, &grpc.StreamServerInfo{}, func(srv interface{}, ss grpc.ServerStream) error {
+				_, ok := FromContext(ss.Context())
+				require.True(t, ok)
+				return nil
+			})
+			assert.Equal(t, tc.expected, status.Code(err))
+
+			if tc.expected == codes.OK {
+				assert.Equal(t, "SC7639", username)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/lestrrat/go-jwx/jwk"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultJWKSRefreshInterval    = time.Hour
+	defaultJWKSMinRefreshInterval = 30 * time.Second
+	jwksFetchTimeout              = 10 * time.Second
+)
+
+// JWKSMetrics instruments a JWKS. Lookups is labelled with "result", a hit or a miss of the cached
+// set, and KeyAge is the seconds since the set was last fetched, updated on every lookup and refresh.
+type JWKSMetrics struct {
+	RefreshFailures metrics.Counter
+	Lookups         metrics.Counter
+	KeyAge          metrics.Gauge
+}
+
+// DiscardJWKSMetrics is for when a JWKS's metrics aren't collected
+func DiscardJWKSMetrics() JWKSMetrics {
+	return JWKSMetrics{
+		RefreshFailures: discard.NewCounter(),
+		Lookups:         discard.NewCounter(),
+		KeyAge:          discard.NewGauge(),
+	}
+}
+
+// JWKS keeps a JSON web key set fresh so key rotations are picked up without a restart. The set
+// is refetched in the background every refreshInterval, and straight away when a token names a key
+// we don't have, though no more than once every minRefreshInterval so bogus tokens can't hammer the
+// key set url. A failed refresh keeps serving the previous keys. A fetch gives up after jwksFetchTimeout
+// or once the refresher's ctx is done, so a hung key set url can't hold up lookups.
+type JWKS struct {
+	ctx                context.Context
+	url                string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	client             *http.Client
+	fetch              func(ctx context.Context, url string) (*jwk.Set, error)
+	metrics            JWKSMetrics
+	logger             log.Logger
+
+	mu          sync.RWMutex
+	set         *jwk.Set
+	fetchedAt   time.Time
+	refreshMu   sync.Mutex
+	lastAttempt time.Time
+}
+
+// NewJWKS fetches the key set at url, failing if it can't, and starts refreshing it in the background
+// until ctx is done
+func NewJWKS(
+	ctx context.Context,
+	url string,
+	refreshInterval, minRefreshInterval time.Duration,
+	jwksMetrics JWKSMetrics,
+	logger log.Logger,
+) (*JWKS, error) {
+	if refreshInterval == 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	if minRefreshInterval == 0 {
+		minRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+
+	c := &JWKS{
+		ctx:                ctx,
+		url:                url,
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minRefreshInterval,
+		client:             &http.Client{Timeout: jwksFetchTimeout},
+		metrics:            jwksMetrics,
+		logger:             log.With(logger, "component", "jwks"),
+	}
+	c.fetch = c.fetchHTTP
+
+	err := c.refresh(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	go c.run(ctx)
+	return c, nil
+}
+
+func (c *JWKS) run(ctx context.Context) {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refresh(ctx, 0)
+			c.recordKeyAge()
+		}
+	}
+}
+
+// LookupKeyID finds the key in the cached set, refetching the set once if the key is unknown
+func (c *JWKS) LookupKeyID(kid string) []jwk.Key {
+	defer c.recordKeyAge()
+
+	keys := c.keys().LookupKeyID(kid)
+	if len(keys) > 0 {
+		c.metrics.Lookups.With("result", "hit").Add(1)
+		return keys
+	}
+	c.metrics.Lookups.With("result", "miss").Add(1)
+
+	c.refresh(c.ctx, c.minRefreshInterval)
+	return c.keys().LookupKeyID(kid)
+}
+
+func (c *JWKS) keys() *jwk.Set {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.set
+}
+
+// Check fails once the set has gone two refresh intervals without a successful fetch
+func (c *JWKS) Check(ctx context.Context) error {
+	c.mu.RLock()
+	age := time.Since(c.fetchedAt)
+	c.mu.RUnlock()
+
+	if age > 2*c.refreshInterval {
+		return errors.Errorf("Key set is stale, last fetched %s ago", age.Round(time.Second))
+	}
+
+	return nil
+}
+
+func (c *JWKS) recordKeyAge() {
+	c.mu.RLock()
+	fetchedAt := c.fetchedAt
+	c.mu.RUnlock()
+
+	c.metrics.KeyAge.Set(time.Since(fetchedAt).Seconds())
+}
+
+// refresh fetches the key set unless the last attempt was less than minInterval ago, only one fetch runs
+// at a time. Checking and recording the attempt under the same lock means a burst of lookups for an
+// unknown key only fetches once.
+func (c *JWKS) refresh(ctx context.Context, minInterval time.Duration) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if time.Since(c.lastAttempt) < minInterval {
+		return nil
+	}
+	c.lastAttempt = time.Now()
+
+	set, err := c.fetch(ctx, c.url)
+	if err != nil {
+		err = errors.Wrap(err, "Failed to get well known JSON web token key set")
+		c.metrics.RefreshFailures.Add(1)
+		level.Error(c.logger).Log("err", err)
+		return err
+	}
+
+	c.mu.Lock()
+	c.set = set
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+
+	c.logger.Log("Refresh key set", len(set.Keys))
+	return nil
+}
+
+// fetchHTTP gets the key set at url with the JWKS's client, jwk.Fetch can't be given a timeout
+func (c *JWKS) fetchHTTP(ctx context.Context, url string) (*jwk.Set, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Key set url returned status %d", res.StatusCode)
+	}
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwk.Parse(buf)
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/lestrrat/go-jwx/jwk"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSRotation(t *testing.T) {
+	_, oldSet := newTestKeySet(t)
+	_, newSet := newTestKeySet(t)
+	newSet.Keys[0].Set(jwk.KeyIDKey, "rotated-key")
+
+	var fetches int32
+	current := oldSet
+	failures := generic.NewCounter("jwks_refresh_failures")
+	keyAge := generic.NewGauge("jwks_key_age_seconds")
+	c := &JWKS{
+		ctx:                context.Background(),
+		url:                "https://issuer.example.com/pool/.well-known/jwks.json",
+		refreshInterval:    time.Hour,
+		minRefreshInterval: time.Hour,
+		metrics: JWKSMetrics{
+			RefreshFailures: failures,
+			Lookups:         generic.NewCounter("jwks_lookups"),
+			KeyAge:          keyAge,
+		},
+		logger: log.NewNopLogger(),
+	}
+	c.fetch = func(ctx context.Context, url string) (*jwk.Set, error) {
+		atomic.AddInt32(&fetches, 1)
+		if current == nil {
+			return nil, errors.New("key set unavailable")
+		}
+		return current, nil
+	}
+	require.NoError(t, c.refresh(context.Background(), 0))
+
+	assert.Len(t, c.LookupKeyID(testKeyID), 1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	assert.True(t, keyAge.Value() < time.Minute.Seconds(), "Key age should be measured from the fetch")
+
+	// An unknown kid refetches at most once per minRefreshInterval
+	current = newSet
+	assert.Empty(t, c.LookupKeyID("rotated-key"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	c.lastAttempt = time.Now().Add(-2 * time.Hour)
+	assert.Len(t, c.LookupKeyID("rotated-key"), 1)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// A failed refresh is counted and keeps serving the keys we had
+	current = nil
+	assert.Error(t, c.refresh(context.Background(), 0))
+	assert.Equal(t, float64(1), failures.Value())
+	assert.Len(t, c.LookupKeyID("rotated-key"), 1)
+
+	// The set only fails its health check once it's gone two refresh intervals without a fetch
+	assert.NoError(t, c.Check(context.Background()))
+	c.fetchedAt = time.Now().Add(-3 * time.Hour)
+	assert.Error(t, c.Check(context.Background()))
+}
+
+func TestJWKSConcurrentUnknownKeys(t *testing.T) {
+	_, set := newTestKeySet(t)
+
+	var fetches int32
+	c := &JWKS{
+		ctx:                context.Background(),
+		minRefreshInterval: time.Hour,
+		metrics:            DiscardJWKSMetrics(),
+		logger:             log.NewNopLogger(),
+		set:                set,
+		fetchedAt:          time.Now(),
+	}
+	c.fetch = func(ctx context.Context, url string) (*jwk.Set, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(10 * time.Millisecond)
+		return set, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.LookupKeyID("unknown-key")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Expected a burst of unknown keys to fetch once")
+}
+
+func TestJWKSStopsRefreshing(t *testing.T) {
+	c := &JWKS{refreshInterval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.run(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Refresher kept running after its context was cancelled")
+	}
+}
+
+func TestJWKSFetchGivesUp(t *testing.T) {
+	released := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-released:
+		}
+	}))
+	defer server.Close()
+	defer close(released)
+
+	c := &JWKS{
+		url:     server.URL,
+		client:  &http.Client{Timeout: 50 * time.Millisecond},
+		metrics: DiscardJWKSMetrics(),
+		logger:  log.NewNopLogger(),
+	}
+	c.fetch = c.fetchHTTP
+
+	start := time.Now()
+	assert.Error(t, c.refresh(context.Background(), 0), "Expected a hung key set url to time out")
+	assert.True(t, time.Since(start) < time.Second, "Expected the fetch to give up at the client's timeout")
+
+	c.client = &http.Client{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, c.refresh(ctx, 0), "Expected a cancelled refresher not to fetch")
+}
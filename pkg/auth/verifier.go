@@ -0,0 +1,281 @@
+// Package auth verifies tokens issued by the user service without calling it, so other PedPet
+// services can authorize requests locally. Verification checks the signature against the issuer's
+// key set and the claims against the issuer and app client. It can't know about logouts and global
+// sign outs, call the user service's VerifyJWT when revocation matters.
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"strings"
+	"time"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/lestrrat/go-jwx/jwk"
+)
+
+// TokenError explains why a token was rejected. Every reason is a package level value so callers
+// can compare against them, or check for the type to treat all token failures alike.
+type TokenError struct {
+	reason string
+}
+
+func (e *TokenError) Error() string {
+	return e.reason
+}
+
+// Reasons a token can fail validation
+var (
+	ErrTokenMissing     = &TokenError{"Token is missing"}
+	ErrTokenMalformed   = &TokenError{"Token is malformed"}
+	ErrTokenAlgorithm   = &TokenError{"Token is not signed with RS256"}
+	ErrTokenKeyID       = &TokenError{"Token was not signed by a known key"}
+	ErrTokenSignature   = &TokenError{"Token signature is invalid"}
+	ErrTokenExpired     = &TokenError{"Token has expired"}
+	ErrTokenNotYetValid = &TokenError{"Token is not valid yet"}
+	ErrTokenIssuer      = &TokenError{"Token was issued by an unexpected issuer"}
+	ErrTokenAudience    = &TokenError{"Token was issued for a different client"}
+	ErrTokenUse         = &TokenError{"Token is neither an access nor an id token"}
+	// ErrTokenRevoked is only returned by the user service, which tracks logouts
+	ErrTokenRevoked = &TokenError{"Token has been revoked"}
+)
+
+// KeySet finds the keys a token may have been signed with, both *jwk.Set and *JWKS satisfy it
+type KeySet interface {
+	LookupKeyID(kid string) []jwk.Key
+}
+
+// Config describes which tokens a Verifier trusts. ClockSkew is allowed either side of the time
+// based claims.
+type Config struct {
+	Issuer    string
+	ClientID  string
+	ClockSkew time.Duration
+}
+
+// Verifier checks a token's signature against a key set and its claims against the issuer and
+// client the tokens must come from
+type Verifier struct {
+	keys      KeySet
+	issuer    string
+	clientID  string
+	clockSkew time.Duration
+	now       func() time.Time
+}
+
+// NewVerifier creates a Verifier trusting tokens signed by the keys in the set
+func NewVerifier(keys KeySet, cfg Config) *Verifier {
+	return &Verifier{
+		keys:      keys,
+		issuer:    cfg.Issuer,
+		clientID:  cfg.ClientID,
+		clockSkew: cfg.ClockSkew,
+		now:       time.Now,
+	}
+}
+
+// Keys is the key set tokens are verified against
+func (v *Verifier) Keys() KeySet {
+	return v.keys
+}
+
+// NewCognitoVerifier creates a Verifier for tokens from the user pool in the settings, keeping the
+// pool's key set fresh in the background until ctx is done
+func NewCognitoVerifier(ctx context.Context, cfg config.AWSSettings, jwksMetrics JWKSMetrics, logger log.Logger) (*Verifier, error) {
+	issuer := CognitoIssuer(cfg.Region, cfg.CognitoUserPoolID, cfg.Endpoint)
+
+	url := cfg.JWKSURL
+	if url == "" {
+		url = issuer + "/.well-known/jwks.json"
+	}
+
+	keys, err := NewJWKS(ctx, url, cfg.JWKSRefreshInterval, cfg.JWKSMinRefreshInterval, jwksMetrics, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewVerifier(keys, Config{
+		Issuer:    issuer,
+		ClientID:  cfg.CognitoAppClientID,
+		ClockSkew: cfg.ClockSkew,
+	}), nil
+}
+
+// CognitoIssuer is the iss claim of tokens from a user pool, served from the endpoint when it's
+// overridden, e.g. by a local stand-in
+func CognitoIssuer(region, userPoolID, endpoint string) string {
+	if endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/" + userPoolID
+	}
+
+	// https://cognito-idp.<region>.amazonaws.com/<pool_id>
+	return "https://cognito-idp." + region + ".amazonaws.com/" + userPoolID
+}
+
+// Parse parses a token, returning one of the TokenError values when it can't be trusted
+func (v *Verifier) Parse(tokenString string) (*jwt.Token, error) {
+	if tokenString == "" {
+		return nil, ErrTokenMissing
+	}
+
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	t, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, ErrTokenAlgorithm
+		}
+
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, ErrTokenKeyID
+		}
+
+		// Looking up the key id will return an array of just one key
+		keys := v.keys.LookupKeyID(kid)
+		if len(keys) == 0 {
+			return nil, ErrTokenKeyID
+		}
+
+		// Build the public RSA key
+		key, err := keys[0].Materialize()
+		if err != nil {
+			return nil, ErrTokenKeyID
+		}
+
+		rsaPublicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, ErrTokenKeyID
+		}
+		return rsaPublicKey, nil
+	})
+	if err != nil {
+		return nil, parseError(err)
+	}
+
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrTokenMalformed
+	}
+
+	err = v.validateClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Verify parses a token and returns its claims
+func (v *Verifier) Verify(tokenString string) (*model.Claims, error) {
+	t, err := v.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return Claims(t.Claims.(jwt.MapClaims)), nil
+}
+
+func (v *Verifier) validateClaims(claims jwt.MapClaims) error {
+	now := v.now().Unix()
+	skew := int64(v.clockSkew / time.Second)
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return ErrTokenMalformed
+	}
+	if now > int64(exp)+skew {
+		return ErrTokenExpired
+	}
+
+	if iat, ok := claims["iat"].(float64); ok && now < int64(iat)-skew {
+		return ErrTokenNotYetValid
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf)-skew {
+		return ErrTokenNotYetValid
+	}
+
+	if claims["iss"] != v.issuer {
+		return ErrTokenIssuer
+	}
+
+	// Access tokens name the app client in client_id while id tokens use aud
+	switch claims["token_use"] {
+	case "access":
+		if claims["client_id"] != v.clientID {
+			return ErrTokenAudience
+		}
+	case "id":
+		if claims["aud"] != v.clientID {
+			return ErrTokenAudience
+		}
+	default:
+		return ErrTokenUse
+	}
+
+	return nil
+}
+
+// parseError translates jwt-go's validation errors into our own
+func parseError(err error) error {
+	ve, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return ErrTokenMalformed
+	}
+	if te, ok := ve.Inner.(*TokenError); ok {
+		return te
+	}
+
+	switch {
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return ErrTokenMalformed
+	case ve.Errors&jwt.ValidationErrorUnverifiable != 0:
+		// The signing method named in the header isn't one jwt-go knows
+		return ErrTokenAlgorithm
+	default:
+		return ErrTokenSignature
+	}
+}
+
+// Claims picks the claims services authorize with out of an access or id token
+func Claims(claims jwt.MapClaims) *model.Claims {
+	c := &model.Claims{
+		Username: Username(claims),
+		Groups:   []string{},
+		Scopes:   []string{},
+	}
+	c.Subject, _ = claims["sub"].(string)
+
+	if groups, ok := claims["cognito:groups"].([]interface{}); ok {
+		for _, group := range groups {
+			if group, ok := group.(string); ok {
+				c.Groups = append(c.Groups, group)
+			}
+		}
+	}
+
+	// Only access tokens carry scopes, as a space separated list
+	if scope, ok := claims["scope"].(string); ok {
+		c.Scopes = strings.Fields(scope)
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		c.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		c.IssuedAt = time.Unix(int64(iat), 0)
+	}
+
+	return c
+}
+
+// Username reads the username from an access token, or from an id token which names it differently
+func Username(claims jwt.MapClaims) string {
+	if username, ok := claims["username"].(string); ok {
+		return username
+	}
+
+	username, _ := claims["cognito:username"].(string)
+	return username
+}
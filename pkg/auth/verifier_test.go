@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat/go-jwx/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKeyID    = "test-key"
+	testIssuer   = "https://issuer.example.com/pool"
+	testClientID = "test-client"
+)
+
+func newTestKeySet(t *testing.T) (*rsa.PrivateKey, *jwk.Set) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key, err := jwk.New(&privateKey.PublicKey)
+	require.NoError(t, err)
+	key.Set(jwk.KeyIDKey, testKeyID)
+
+	return privateKey, &jwk.Set{Keys: []jwk.Key{key}}
+}
+
+func TestVerifierParse(t *testing.T) {
+	privateKey, set := newTestKeySet(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	now := time.Unix(1600000000, 0)
+	v := NewVerifier(set, Config{
+		Issuer:    testIssuer,
+		ClientID:  testClientID,
+		ClockSkew: 30 * time.Second,
+	})
+	v.now = func() time.Time { return now }
+
+	accessClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":       testIssuer,
+			"client_id": testClientID,
+			"token_use": "access",
+			"username":  "SC7639",
+			"iat":       now.Add(-time.Minute).Unix(),
+			"exp":       now.Add(time.Hour).Unix(),
+		}
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, kid interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+	withClaim := func(name string, value interface{}) jwt.MapClaims {
+		claims := accessClaims()
+		if value == nil {
+			delete(claims, name)
+			return claims
+		}
+		claims[name] = value
+		return claims
+	}
+
+	testCases := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{
+			name:  "Valid access token",
+			token: sign(jwt.SigningMethodRS256, privateKey, testKeyID, accessClaims()),
+		},
+		{
+			name: "Valid id token",
+			token: sign(jwt.SigningMethodRS256, privateKey, testKeyID, jwt.MapClaims{
+				"iss":       testIssuer,
+				"aud":       testClientID,
+				"token_use": "id",
+				"exp":       now.Add(time.Hour).Unix(),
+			}),
+		},
+		{
+			name:  "Expired within clock skew",
+			token: sign(jwt.SigningMethodRS256, privateKey, testKeyID, withClaim("exp", now.Add(-10*time.Second).Unix())),
+		},
+		{
+			name:     "Missing",
+			token:    "",
+			expected: ErrTokenMissing,
+		},
+		{
+			name:     "Malformed",
+			token:    "not-a-token",
+			expected: ErrTokenMalformed,
+		},
+		{
+			name:     "Missing kid",
+			token:    sign(jwt.SigningMethodRS256, privateKey, nil, accessClaims()),
+			expected: ErrTokenKeyID,
+		},
+		{
+			name:     "Non string kid",
+			token:    sign(jwt.SigningMethodRS256, privateKey, 42, accessClaims()),
+			expected: ErrTokenKeyID,
+		},
+		{
+			name:     "Unknown kid",
+			token:    sign(jwt.SigningMethodRS256, privateKey, "other-key", accessClaims()),
+			expected: ErrTokenKeyID,
+		},
+		{
+			name:     "HMAC signed",
+			token:    sign(jwt.SigningMethodHS256, []byte("secret"), testKeyID, accessClaims()),
+			expected: ErrTokenAlgorithm,
+		},
+		{
+			name:     "Wrong key",
+			token:    sign(jwt.SigningMethodRS256, otherKey, testKeyID, accessClaims()),
+			expected: ErrTokenSignature,
+		},
+		{
+			name:     "Expired",
+			token:    sign(jwt.SigningMethodRS256, privateKey, testKeyID, withClaim("exp", now.Add(-time.Minute).Unix())),
+			expected: ErrTokenExpired,
+		},
+		{
+			name:     "Missing exp",
+			token:    sign(jwt.SigningMethodRS256, privateKey, testKeyID, withClaim("exp", nil)),
+			expected: ErrTokenMalformed,
+		},
+		{
+			name:     "Issued in the future",
+			token:    sign(jwt.SigningMethodRS256, privateKey, testKeyID, withClaim("iat", now.Add(time.Minute).Unix())),
+			expected: ErrTokenNotYetValid,
+		},
+		{
+			name:     "Wrong issuer",
+			token:    sign(jwt.SigningMethodRS256, privateKey, testKeyID, withClaim("iss", "https://evil.example.com")),
+			expected: ErrTokenIssuer,
+		},
+		{
+			name:     "Wrong client",
+			token:    sign(jwt.SigningMethodRS256, privateKey, testKeyID, withClaim("client_id", "other-client")),
+			expected: ErrTokenAudience,
+		},
+		{
+			name:     "Unknown token use",
+			token:    sign(jwt.SigningMethodRS256, privateKey, testKeyID, withClaim("token_use", "refresh")),
+			expected: ErrTokenUse,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := v.Parse(tc.token)
+			if tc.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Equal(t, tc.expected, err)
+		})
+	}
+}
+
+func TestClaims(t *testing.T) {
+	claims := Claims(jwt.MapClaims{
+		"sub":            "0b3b4c3e-1f1e-4d5c-9d6c-6f1b2a3c4d5e",
+		"cognito:groups": []interface{}{"admin", "breeder"},
+		"scope":          "aws.cognito.signin.user.admin openid",
+		"username":       "SC7639",
+		"iat":            float64(1600000000),
+		"exp":            float64(1600003600),
+	})
+
+	assert.Equal(t, "0b3b4c3e-1f1e-4d5c-9d6c-6f1b2a3c4d5e", claims.Subject)
+	assert.Equal(t, "SC7639", claims.Username)
+	assert.Equal(t, []string{"admin", "breeder"}, claims.Groups)
+	assert.Equal(t, []string{"aws.cognito.signin.user.admin", "openid"}, claims.Scopes)
+	assert.Equal(t, time.Unix(1600000000, 0), claims.IssuedAt)
+	assert.Equal(t, time.Unix(1600003600, 0), claims.ExpiresAt)
+
+	idClaims := Claims(jwt.MapClaims{"cognito:username": "SC7639"})
+	assert.Equal(t, "SC7639", idClaims.Username)
+	assert.Empty(t, idClaims.Groups)
+	assert.Empty(t, idClaims.Scopes)
+}
@@ -2,34 +2,65 @@ package endpoint
 
 import (
 	"context"
+	"time"
 
+	"github.com/PedPet/user/config"
 	"github.com/PedPet/user/model"
 	service "github.com/PedPet/user/pkg/service"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Endpoints is a struct that contains all the endpoint available in this microservice
 type Endpoints struct {
-	CreateUserEndpoint         endpoint.Endpoint
-	ConfirmUserEndpoint        endpoint.Endpoint
-	ResendConfirmationEndpoint endpoint.Endpoint
-	UsernameTakenEndpoint      endpoint.Endpoint
-	LoginEndpoint              endpoint.Endpoint
-	VerifyJWTEndpoint          endpoint.Endpoint
-	UserDetailsEndpoint        endpoint.Endpoint
+	CreateUserEndpoint            endpoint.Endpoint
+	ConfirmUserEndpoint           endpoint.Endpoint
+	ResendConfirmationEndpoint    endpoint.Endpoint
+	UsernameTakenEndpoint         endpoint.Endpoint
+	LoginEndpoint                 endpoint.Endpoint
+	RefreshSessionEndpoint        endpoint.Endpoint
+	ForgotPasswordEndpoint        endpoint.Endpoint
+	ConfirmForgotPasswordEndpoint endpoint.Endpoint
+	ChangePasswordEndpoint        endpoint.Endpoint
+	LogoutEndpoint                endpoint.Endpoint
+	GlobalSignOutEndpoint         endpoint.Endpoint
+	VerifyJWTEndpoint             endpoint.Endpoint
+	UserDetailsEndpoint           endpoint.Endpoint
+	GetPasswordPolicyEndpoint     endpoint.Endpoint
+	GetProfileEndpoint            endpoint.Endpoint
+	UpdateProfileEndpoint         endpoint.Endpoint
+	UpdateContactDetailsEndpoint  endpoint.Endpoint
+	VerifyAttributeEndpoint       endpoint.Endpoint
 }
 
-// MakeEndpoints give the required dependencies to the Endpoints
-func MakeEndpoints(s service.User) Endpoints {
+// MakeEndpoints give the required dependencies to the Endpoints, every request is validated against
+// the rules before it reaches the service and every endpoint is traced
+func MakeEndpoints(s service.User, rules config.Validation, tracer trace.Tracer) Endpoints {
+	validate := ValidationMiddleware(rules)
+	traced := func(name string, e endpoint.Endpoint) endpoint.Endpoint {
+		return TracingMiddleware(tracer, name)(e)
+	}
+
 	return Endpoints{
-		CreateUserEndpoint:         makeCreateUserEndpoint(s),
-		ConfirmUserEndpoint:        makeConfirmUser(s),
-		ResendConfirmationEndpoint: makeResendConfirmation(s),
-		UsernameTakenEndpoint:      makeUsernameTaken(s),
-		LoginEndpoint:              makeLogin(s),
-		VerifyJWTEndpoint:          makeVerifyJWT(s),
-		UserDetailsEndpoint:        makeUserDetails(s),
+		CreateUserEndpoint:            traced("CreateUser", validate(makeCreateUserEndpoint(s))),
+		ConfirmUserEndpoint:           traced("ConfirmUser", validate(makeConfirmUser(s))),
+		ResendConfirmationEndpoint:    traced("ResendConfirmation", validate(makeResendConfirmation(s))),
+		UsernameTakenEndpoint:         traced("UsernameTaken", validate(makeUsernameTaken(s))),
+		LoginEndpoint:                 traced("Login", validate(makeLogin(s))),
+		RefreshSessionEndpoint:        traced("RefreshSession", validate(makeRefreshSession(s))),
+		ForgotPasswordEndpoint:        traced("ForgotPassword", validate(makeForgotPassword(s))),
+		ConfirmForgotPasswordEndpoint: traced("ConfirmForgotPassword", validate(makeConfirmForgotPassword(s))),
+		ChangePasswordEndpoint:        traced("ChangePassword", validate(makeChangePassword(s))),
+		LogoutEndpoint:                traced("Logout", validate(makeLogout(s))),
+		GlobalSignOutEndpoint:         traced("GlobalSignOut", validate(makeGlobalSignOut(s))),
+		VerifyJWTEndpoint:             traced("VerifyJWT", validate(makeVerifyJWT(s))),
+		UserDetailsEndpoint:           traced("UserDetails", validate(makeUserDetails(s))),
+		GetPasswordPolicyEndpoint:     traced("GetPasswordPolicy", makeGetPasswordPolicy(rules.Password)),
+		GetProfileEndpoint:            traced("GetProfile", validate(makeGetProfile(s))),
+		UpdateProfileEndpoint:         traced("UpdateProfile", validate(makeUpdateProfile(s))),
+		UpdateContactDetailsEndpoint:  traced("UpdateContactDetails", validate(makeUpdateContactDetails(s))),
+		VerifyAttributeEndpoint:       traced("VerifyAttribute", validate(makeVerifyAttribute(s))),
 	}
 }
 
@@ -162,17 +193,17 @@ func (e Endpoints) UsernameTaken(ctx context.Context, username string) (bool, er
 func makeLogin(s service.User) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(LoginRequest)
-		token, err := s.Login(ctx, req.Username, req.Password)
+		session, err := s.Login(ctx, req.Username, req.Password)
 		if err != nil {
 			return nil, err
 		}
 
-		return LoginResponse{Jwt: token}, nil
+		return sessionToLoginResponse(session), nil
 	}
 }
 
 // Login calls the login endpoint
-func (e Endpoints) Login(ctx context.Context, username, password string) (string, error) {
+func (e Endpoints) Login(ctx context.Context, username, password string) (*model.Session, error) {
 	req := LoginRequest{
 		Username: username,
 		Password: password,
@@ -180,43 +211,273 @@ func (e Endpoints) Login(ctx context.Context, username, password string) (string
 
 	resp, err := e.LoginEndpoint(ctx, req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	loginResp := resp.(LoginResponse)
+	return loginResponseToSession(loginResp), nil
+}
 
-	return loginResp.Jwt, nil
+func makeRefreshSession(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RefreshSessionRequest)
+		session, err := s.RefreshSession(ctx, req.Username, req.RefreshToken)
+		if err != nil {
+			return nil, err
+		}
+
+		return sessionToLoginResponse(session), nil
+	}
 }
 
-func makeVerifyJWT(s service.User) endpoint.Endpoint {
+// RefreshSession calls the refresh session endpoint
+func (e Endpoints) RefreshSession(ctx context.Context, username, refreshToken string) (*model.Session, error) {
+	req := RefreshSessionRequest{
+		Username:     username,
+		RefreshToken: refreshToken,
+	}
+
+	resp, err := e.RefreshSessionEndpoint(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshResp := resp.(LoginResponse)
+	return loginResponseToSession(refreshResp), nil
+}
+
+func makeForgotPassword(s service.User) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(VerifyJWTRequest)
-		verified, err := s.VerifyJWT(ctx, req.Jwt)
+		req := request.(ForgotPasswordRequest)
+		err := s.ForgotPassword(ctx, req.Username)
 		if err != nil {
 			return nil, err
 		}
 
-		if verified != true {
-			return ConfirmResponse{Ok: false}, nil
+		return ConfirmResponse{Ok: true}, nil
+	}
+}
+
+// ForgotPassword calls the forgot password endpoint
+func (e Endpoints) ForgotPassword(ctx context.Context, username string) error {
+	req := ForgotPasswordRequest{
+		Username: username,
+	}
+
+	resp, err := e.ForgotPasswordEndpoint(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	forgotPasswordResp := resp.(ConfirmResponse)
+	if forgotPasswordResp.Ok != true {
+		return errors.New("Failed to start forgot password")
+	}
+	return nil
+}
+
+func makeConfirmForgotPassword(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ConfirmForgotPasswordRequest)
+		err := s.ConfirmForgotPassword(ctx, req.Username, req.Code, req.Password)
+		if err != nil {
+			return nil, err
 		}
 
 		return ConfirmResponse{Ok: true}, nil
 	}
 }
 
+// ConfirmForgotPassword calls the confirm forgot password endpoint
+func (e Endpoints) ConfirmForgotPassword(ctx context.Context, username, code, password string) error {
+	req := ConfirmForgotPasswordRequest{
+		Username: username,
+		Code:     code,
+		Password: password,
+	}
+
+	resp, err := e.ConfirmForgotPasswordEndpoint(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	confirmForgotPasswordResp := resp.(ConfirmResponse)
+	if confirmForgotPasswordResp.Ok != true {
+		return errors.New("Failed to reset password")
+	}
+	return nil
+}
+
+func makeChangePassword(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ChangePasswordRequest)
+		session, err := s.ChangePassword(ctx, req.Jwt, req.OldPassword, req.NewPassword, req.SignOutOtherSessions)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := ChangePasswordResponse{Ok: true}
+		if session != nil {
+			loginResp := sessionToLoginResponse(session)
+			resp.Session = &loginResp
+		}
+		return resp, nil
+	}
+}
+
+// ChangePassword calls the change password endpoint
+func (e Endpoints) ChangePassword(
+	ctx context.Context,
+	token, oldPassword, newPassword string,
+	signOutOthers bool,
+) (*model.Session, error) {
+	req := ChangePasswordRequest{
+		Jwt:                  token,
+		OldPassword:          oldPassword,
+		NewPassword:          newPassword,
+		SignOutOtherSessions: signOutOthers,
+	}
+
+	resp, err := e.ChangePasswordEndpoint(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	changePasswordResp := resp.(ChangePasswordResponse)
+	if changePasswordResp.Ok != true {
+		return nil, errors.New("Failed to change password")
+	}
+	if changePasswordResp.Session == nil {
+		return nil, nil
+	}
+	return loginResponseToSession(*changePasswordResp.Session), nil
+}
+
+func makeLogout(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LogoutRequest)
+		err := s.Logout(ctx, req.Username, req.RefreshToken)
+		if err != nil {
+			return nil, err
+		}
+
+		return ConfirmResponse{Ok: true}, nil
+	}
+}
+
+// Logout calls the logout endpoint
+func (e Endpoints) Logout(ctx context.Context, username, refreshToken string) error {
+	req := LogoutRequest{
+		Username:     username,
+		RefreshToken: refreshToken,
+	}
+
+	resp, err := e.LogoutEndpoint(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	logoutResp := resp.(ConfirmResponse)
+	if logoutResp.Ok != true {
+		return errors.New("Failed to logout")
+	}
+	return nil
+}
+
+func makeGlobalSignOut(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GlobalSignOutRequest)
+		err := s.GlobalSignOut(ctx, req.Jwt)
+		if err != nil {
+			return nil, err
+		}
+
+		return ConfirmResponse{Ok: true}, nil
+	}
+}
+
+// GlobalSignOut calls the global sign out endpoint
+func (e Endpoints) GlobalSignOut(ctx context.Context, token string) error {
+	req := GlobalSignOutRequest{
+		Jwt: token,
+	}
+
+	resp, err := e.GlobalSignOutEndpoint(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	globalSignOutResp := resp.(ConfirmResponse)
+	if globalSignOutResp.Ok != true {
+		return errors.New("Failed to sign out")
+	}
+	return nil
+}
+
+func sessionToLoginResponse(session *model.Session) LoginResponse {
+	return LoginResponse{
+		Jwt:          session.AccessToken,
+		IDToken:      session.IDToken,
+		RefreshToken: session.RefreshToken,
+		ExpiresIn:    session.ExpiresIn,
+		TokenType:    session.TokenType,
+	}
+}
+
+func loginResponseToSession(resp LoginResponse) *model.Session {
+	return &model.Session{
+		AccessToken:  resp.Jwt,
+		IDToken:      resp.IDToken,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+		TokenType:    resp.TokenType,
+	}
+}
+
+func makeVerifyJWT(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(VerifyJWTRequest)
+		claims, err := s.VerifyJWT(ctx, req.Jwt)
+		if err != nil {
+			return nil, err
+		}
+
+		return VerifyJWTResponse{
+			Ok:        true,
+			Subject:   claims.Subject,
+			Username:  claims.Username,
+			Groups:    claims.Groups,
+			Scopes:    claims.Scopes,
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+		}, nil
+	}
+}
+
 // VerifyJWT calls the verify jwt endpoint
-func (e Endpoints) VerifyJWT(ctx context.Context, token string) (bool, error) {
+func (e Endpoints) VerifyJWT(ctx context.Context, token string) (*model.Claims, error) {
 	req := VerifyJWTRequest{
 		Jwt: token,
 	}
 
 	resp, err := e.VerifyJWTEndpoint(ctx, req)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	verifyJWTResp := resp.(ConfirmResponse)
-	return verifyJWTResp.Ok, nil
+	verifyJWTResp := resp.(VerifyJWTResponse)
+	if verifyJWTResp.Ok != true {
+		return nil, errors.New("Failed to verify jwt")
+	}
+
+	return &model.Claims{
+		Subject:   verifyJWTResp.Subject,
+		Username:  verifyJWTResp.Username,
+		Groups:    verifyJWTResp.Groups,
+		Scopes:    verifyJWTResp.Scopes,
+		ExpiresAt: time.Unix(verifyJWTResp.ExpiresAt, 0),
+		IssuedAt:  time.Unix(verifyJWTResp.IssuedAt, 0),
+	}, nil
 }
 
 func makeUserDetails(s service.User) endpoint.Endpoint {
@@ -228,11 +489,13 @@ func makeUserDetails(s service.User) endpoint.Endpoint {
 		}
 
 		return UserDetailsResponse{
-			ID:          user.ID,
-			Username:    user.Username,
-			Email:       user.Email,
-			PhoneNumber: user.PhoneNumber,
-			Confirmed:   user.Confirmed,
+			ID:                  user.ID,
+			Username:            user.Username,
+			Email:               user.Email,
+			PhoneNumber:         user.PhoneNumber,
+			Confirmed:           user.Confirmed,
+			EmailVerified:       user.EmailVerified,
+			PhoneNumberVerified: user.PhoneNumberVerified,
 		}, nil
 	}
 }
@@ -250,10 +513,228 @@ func (e Endpoints) UserDetails(ctx context.Context, token string) (*model.User,
 
 	userDetailsResp := resp.(UserDetailsResponse)
 	return &model.User{
-		ID:          userDetailsResp.ID,
-		Username:    userDetailsResp.Username,
-		Email:       userDetailsResp.Email,
-		PhoneNumber: userDetailsResp.PhoneNumber,
-		Confirmed:   userDetailsResp.Confirmed,
+		ID:                  userDetailsResp.ID,
+		Username:            userDetailsResp.Username,
+		Email:               userDetailsResp.Email,
+		PhoneNumber:         userDetailsResp.PhoneNumber,
+		Confirmed:           userDetailsResp.Confirmed,
+		EmailVerified:       userDetailsResp.EmailVerified,
+		PhoneNumberVerified: userDetailsResp.PhoneNumberVerified,
+	}, nil
+}
+
+func makeGetPasswordPolicy(rules config.Password) endpoint.Endpoint {
+	return func(_ context.Context, _ interface{}) (interface{}, error) {
+		return PasswordPolicyResponse{
+			Required:  rules.Required,
+			MinLength: rules.Length.Min,
+			MaxLength: rules.Length.Max,
+			Regex:     rules.Regex,
+		}, nil
+	}
+}
+
+// GetPasswordPolicy calls the get password policy endpoint
+func (e Endpoints) GetPasswordPolicy(ctx context.Context) (*config.Password, error) {
+	resp, err := e.GetPasswordPolicyEndpoint(ctx, GetPasswordPolicyRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	policyResp := resp.(PasswordPolicyResponse)
+	return &config.Password{
+		Required: policyResp.Required,
+		Length:   config.Length{Min: policyResp.MinLength, Max: policyResp.MaxLength},
+		Regex:    policyResp.Regex,
 	}, nil
 }
+
+func makeGetProfile(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetProfileRequest)
+		profile, err := s.GetProfile(ctx, req.Jwt)
+		if err != nil {
+			return nil, err
+		}
+
+		return profileResponse(profile), nil
+	}
+}
+
+// GetProfile calls the get profile endpoint
+func (e Endpoints) GetProfile(ctx context.Context, token string) (*model.Profile, error) {
+	req := GetProfileRequest{
+		Jwt: token,
+	}
+
+	resp, err := e.GetProfileEndpoint(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return responseProfile(resp.(ProfileResponse)), nil
+}
+
+func makeUpdateProfile(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UpdateProfileRequest)
+		profile := &model.Profile{
+			FirstName:   req.FirstName,
+			LastName:    req.LastName,
+			DisplayName: req.DisplayName,
+			AvatarURL:   req.AvatarURL,
+			Bio:         req.Bio,
+			Location:    req.Location,
+			Locale:      req.Locale,
+			Timezone:    req.Timezone,
+		}
+		if req.DateOfBirth != "" {
+			// Validated already
+			profile.DateOfBirth, _ = time.Parse(DateLayout, req.DateOfBirth)
+		}
+
+		profile, err := s.UpdateProfile(ctx, req.Jwt, profile)
+		if err != nil {
+			return nil, err
+		}
+
+		return profileResponse(profile), nil
+	}
+}
+
+// UpdateProfile calls the update profile endpoint
+func (e Endpoints) UpdateProfile(ctx context.Context, token string, profile *model.Profile) (*model.Profile, error) {
+	req := UpdateProfileRequest{
+		Jwt:         token,
+		FirstName:   profile.FirstName,
+		LastName:    profile.LastName,
+		DisplayName: profile.DisplayName,
+		AvatarURL:   profile.AvatarURL,
+		Bio:         profile.Bio,
+		Location:    profile.Location,
+		Locale:      profile.Locale,
+		Timezone:    profile.Timezone,
+	}
+	if !profile.DateOfBirth.IsZero() {
+		req.DateOfBirth = profile.DateOfBirth.Format(DateLayout)
+	}
+
+	resp, err := e.UpdateProfileEndpoint(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return responseProfile(resp.(ProfileResponse)), nil
+}
+
+func profileResponse(profile *model.Profile) ProfileResponse {
+	resp := ProfileResponse{
+		FirstName:   profile.FirstName,
+		LastName:    profile.LastName,
+		DisplayName: profile.DisplayName,
+		AvatarURL:   profile.AvatarURL,
+		Bio:         profile.Bio,
+		Location:    profile.Location,
+		Locale:      profile.Locale,
+		Timezone:    profile.Timezone,
+	}
+	if !profile.DateOfBirth.IsZero() {
+		resp.DateOfBirth = profile.DateOfBirth.Format(DateLayout)
+	}
+	if !profile.UpdatedAt.IsZero() {
+		resp.UpdatedAt = profile.UpdatedAt.Unix()
+	}
+	return resp
+}
+
+func responseProfile(resp ProfileResponse) *model.Profile {
+	profile := &model.Profile{
+		FirstName:   resp.FirstName,
+		LastName:    resp.LastName,
+		DisplayName: resp.DisplayName,
+		AvatarURL:   resp.AvatarURL,
+		Bio:         resp.Bio,
+		Location:    resp.Location,
+		Locale:      resp.Locale,
+		Timezone:    resp.Timezone,
+	}
+	if resp.DateOfBirth != "" {
+		profile.DateOfBirth, _ = time.Parse(DateLayout, resp.DateOfBirth)
+	}
+	if resp.UpdatedAt != 0 {
+		profile.UpdatedAt = time.Unix(resp.UpdatedAt, 0)
+	}
+	return profile
+}
+
+func makeUpdateContactDetails(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UpdateContactDetailsRequest)
+		deliveries, err := s.UpdateContactDetails(ctx, req.Jwt, req.Email, req.PhoneNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := UpdateContactDetailsResponse{CodeDeliveries: make([]CodeDelivery, 0, len(deliveries))}
+		for _, d := range deliveries {
+			resp.CodeDeliveries = append(resp.CodeDeliveries, CodeDelivery(d))
+		}
+		return resp, nil
+	}
+}
+
+// UpdateContactDetails calls the update contact details endpoint
+func (e Endpoints) UpdateContactDetails(
+	ctx context.Context,
+	token, email, phoneNumber string,
+) ([]model.CodeDelivery, error) {
+	req := UpdateContactDetailsRequest{
+		Jwt:         token,
+		Email:       email,
+		PhoneNumber: phoneNumber,
+	}
+
+	resp, err := e.UpdateContactDetailsEndpoint(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	contactResp := resp.(UpdateContactDetailsResponse)
+	deliveries := make([]model.CodeDelivery, 0, len(contactResp.CodeDeliveries))
+	for _, d := range contactResp.CodeDeliveries {
+		deliveries = append(deliveries, model.CodeDelivery(d))
+	}
+	return deliveries, nil
+}
+
+func makeVerifyAttribute(s service.User) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(VerifyAttributeRequest)
+		err := s.VerifyAttribute(ctx, req.Jwt, req.Attribute, req.Code)
+		if err != nil {
+			return nil, err
+		}
+
+		return ConfirmResponse{Ok: true}, nil
+	}
+}
+
+// VerifyAttribute calls the verify attribute endpoint
+func (e Endpoints) VerifyAttribute(ctx context.Context, token, attribute, code string) error {
+	req := VerifyAttributeRequest{
+		Jwt:       token,
+		Attribute: attribute,
+		Code:      code,
+	}
+
+	resp, err := e.VerifyAttributeEndpoint(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	verifyResp := resp.(ConfirmResponse)
+	if verifyResp.Ok != true {
+		return errors.New("Failed to verify attribute")
+	}
+	return nil
+}
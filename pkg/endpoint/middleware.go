@@ -0,0 +1,44 @@
+package endpoint
+
+import (
+	"context"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/pkg/tracing"
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// validator is a request that can validate itself against the rules
+type validator interface {
+	Validate(rules config.Validation) error
+}
+
+// ValidationMiddleware validates requests before they reach the service. Invalid requests fail with
+// the validation.Errors describing each invalid field.
+func ValidationMiddleware(rules config.Validation) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if req, ok := request.(validator); ok {
+				err := req.Validate(rules)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// TracingMiddleware wraps the endpoint in a span named after it, validation failures included
+func TracingMiddleware(tracer trace.Tracer, name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := tracer.Start(ctx, "endpoint."+name)
+			defer func() { tracing.End(span, err) }()
+
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package endpoint
+
+import (
+	"context"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestValidationMiddleware(t *testing.T) {
+	called := false
+	next := func(ctx context.Context, request interface{}) (interface{}, error) {
+		called = true
+		return ConfirmResponse{Ok: true}, nil
+	}
+	e := ValidationMiddleware(rules)(next)
+
+	_, err := e(context.Background(), LoginRequest{Username: "SC7639"})
+	assert.IsType(t, validation.Errors{}, err)
+	assert.False(t, called, "Invalid request reached the service")
+
+	_, err = e(context.Background(), &UserDetailsRequest{})
+	assert.EqualError(t, err, "jwt: cannot be blank.")
+	assert.False(t, called, "Invalid request reached the service")
+
+	_, err = e(context.Background(), LoginRequest{Username: "SC7639", Password: "Swarleyfin1!"})
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+	e := TracingMiddleware(tracer, "Login")(ValidationMiddleware(rules)(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			return ConfirmResponse{Ok: true}, nil
+		},
+	))
+
+	e(context.Background(), LoginRequest{Username: "SC7639", Password: "Swarleyfin1!"})
+	e(context.Background(), LoginRequest{Username: "SC7639"})
+
+	ended := spans.Ended()
+	if assert.Len(t, ended, 2) {
+		assert.Equal(t, "endpoint.Login", ended[0].Name())
+		assert.Equal(t, codes.Unset, ended[0].Status().Code)
+		assert.Equal(t, codes.Error, ended[1].Status().Code)
+	}
+}
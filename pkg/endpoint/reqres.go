@@ -3,13 +3,22 @@ package endpoint
 import (
 	"context"
 	"regexp"
+	"time"
+	// Time zones are checked against the embedded database, whatever the host has installed
+	_ "time/tzdata"
 
 	pb "github.com/PedPet/proto/api/user"
 	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/pkg/errors"
+	"golang.org/x/text/language"
 )
 
+// DateLayout is the format of dates like the date of birth
+const DateLayout = "2006-01-02"
+
 type (
 	// ConfirmResponse test
 	ConfirmResponse struct {
@@ -48,6 +57,53 @@ type (
 
 	// LoginResponse test
 	LoginResponse struct {
+		Jwt          string `json:"jwt"`
+		IDToken      string `json:"idToken"`
+		RefreshToken string `json:"refreshToken,omitempty"`
+		ExpiresIn    int64  `json:"expiresIn"`
+		TokenType    string `json:"tokenType"`
+	}
+
+	// RefreshSessionRequest is a struct to convert a refresh session request to and from json
+	RefreshSessionRequest struct {
+		Username     string `json:"username"`
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	// ForgotPasswordRequest is a struct to convert a forgot password request to and from json
+	ForgotPasswordRequest struct {
+		Username string `json:"username"`
+	}
+
+	// ConfirmForgotPasswordRequest is a struct to convert a reset password request to and from json
+	ConfirmForgotPasswordRequest struct {
+		Username string `json:"username"`
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+
+	// ChangePasswordRequest is a struct to convert a change password request to and from json
+	ChangePasswordRequest struct {
+		Jwt                  string `json:"jwt"`
+		OldPassword          string `json:"oldPassword"`
+		NewPassword          string `json:"newPassword"`
+		SignOutOtherSessions bool   `json:"signOutOtherSessions"`
+	}
+
+	// ChangePasswordResponse carries the new session when other sessions were signed out
+	ChangePasswordResponse struct {
+		Ok      bool           `json:"ok"`
+		Session *LoginResponse `json:"session,omitempty"`
+	}
+
+	// LogoutRequest is a struct to convert a logout request to and from json
+	LogoutRequest struct {
+		Username     string `json:"username"`
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	// GlobalSignOutRequest is a struct to convert a global sign out request to and from json
+	GlobalSignOutRequest struct {
 		Jwt string `json:"jwt"`
 	}
 
@@ -56,6 +112,17 @@ type (
 		Jwt string `json:"jwt"`
 	}
 
+	// VerifyJWTResponse carries the verified claims of the token
+	VerifyJWTResponse struct {
+		Ok        bool     `json:"ok"`
+		Subject   string   `json:"sub"`
+		Username  string   `json:"username"`
+		Groups    []string `json:"groups"`
+		Scopes    []string `json:"scopes"`
+		ExpiresAt int64    `json:"expiresAt"`
+		IssuedAt  int64    `json:"issuedAt"`
+	}
+
 	// UserDetailsRequest test
 	UserDetailsRequest struct {
 		Jwt string `json:"jwt"`
@@ -63,11 +130,86 @@ type (
 
 	// UserDetailsResponse test
 	UserDetailsResponse struct {
-		ID          int    `json:"id"`
-		Username    string `json:"username"`
+		ID                  int    `json:"id"`
+		Username            string `json:"username"`
+		Email               string `json:"email"`
+		PhoneNumber         string `json:"phoneNumber"`
+		Confirmed           bool   `json:"confirmed"`
+		EmailVerified       bool   `json:"emailVerified"`
+		PhoneNumberVerified bool   `json:"phoneNumberVerified"`
+	}
+
+	// GetPasswordPolicyRequest is a struct to convert a get password policy request to and from json
+	GetPasswordPolicyRequest struct{}
+
+	// PasswordPolicyResponse carries the password rules so clients can validate the same way
+	PasswordPolicyResponse struct {
+		Required  bool     `json:"required"`
+		MinLength int      `json:"minLength"`
+		MaxLength int      `json:"maxLength"`
+		Regex     []string `json:"regex"`
+	}
+
+	// GetProfileRequest is a struct to convert a get profile request to and from json
+	GetProfileRequest struct {
+		Jwt string `json:"jwt"`
+	}
+
+	// UpdateProfileRequest is a struct to convert an update profile request to and from json. The
+	// profile is replaced, fields left empty are cleared.
+	UpdateProfileRequest struct {
+		Jwt         string `json:"jwt"`
+		FirstName   string `json:"firstName"`
+		LastName    string `json:"lastName"`
+		DisplayName string `json:"displayName"`
+		AvatarURL   string `json:"avatarUrl"`
+		Bio         string `json:"bio"`
+		Location    string `json:"location"`
+		Locale      string `json:"locale"`
+		Timezone    string `json:"timezone"`
+		DateOfBirth string `json:"dateOfBirth"`
+	}
+
+	// ProfileResponse carries the user's profile, DateOfBirth is in the DateLayout format and empty
+	// when unset. UpdatedAt is zero until the profile is first stored.
+	ProfileResponse struct {
+		FirstName   string `json:"firstName"`
+		LastName    string `json:"lastName"`
+		DisplayName string `json:"displayName"`
+		AvatarURL   string `json:"avatarUrl"`
+		Bio         string `json:"bio"`
+		Location    string `json:"location"`
+		Locale      string `json:"locale"`
+		Timezone    string `json:"timezone"`
+		DateOfBirth string `json:"dateOfBirth"`
+		UpdatedAt   int64  `json:"updatedAt"`
+	}
+
+	// UpdateContactDetailsRequest is a struct to convert an update contact details request to and from
+	// json, a field left empty is left as it is
+	UpdateContactDetailsRequest struct {
+		Jwt         string `json:"jwt"`
 		Email       string `json:"email"`
 		PhoneNumber string `json:"phoneNumber"`
-		Confirmed   bool   `json:"confirmed"`
+	}
+
+	// CodeDelivery is where a verification code was sent, the destination is masked
+	CodeDelivery struct {
+		AttributeName  string `json:"attributeName"`
+		DeliveryMedium string `json:"deliveryMedium"`
+		Destination    string `json:"destination"`
+	}
+
+	// UpdateContactDetailsResponse carries where the codes to verify the changed values were sent
+	UpdateContactDetailsResponse struct {
+		CodeDeliveries []CodeDelivery `json:"codeDeliveries"`
+	}
+
+	// VerifyAttributeRequest is a struct to convert a verify attribute request to and from json
+	VerifyAttributeRequest struct {
+		Jwt       string `json:"jwt"`
+		Attribute string `json:"attribute"`
+		Code      string `json:"code"`
 	}
 )
 
@@ -181,7 +323,11 @@ func DecodeLoginRequest(_ context.Context, r interface{}) (interface{}, error) {
 func EncodeLoginResponse(_ context.Context, r interface{}) (interface{}, error) {
 	resp := r.(LoginResponse)
 	return &pb.LoginResponse{
-		Jwt: resp.Jwt,
+		Jwt:          resp.Jwt,
+		IdToken:      resp.IDToken,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+		TokenType:    resp.TokenType,
 	}, nil
 }
 
@@ -189,7 +335,156 @@ func EncodeLoginResponse(_ context.Context, r interface{}) (interface{}, error)
 func DecodeLoginResponse(_ context.Context, r interface{}) (interface{}, error) {
 	resp := r.(*pb.LoginResponse)
 	return LoginResponse{
-		Jwt: resp.Jwt,
+		Jwt:          resp.Jwt,
+		IDToken:      resp.IdToken,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+		TokenType:    resp.TokenType,
+	}, nil
+}
+
+// EncodeRefreshSessionRequest encode request into expected grpc request
+func EncodeRefreshSessionRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(RefreshSessionRequest)
+	return &pb.RefreshSessionRequest{
+		Username:     req.Username,
+		RefreshToken: req.RefreshToken,
+	}, nil
+}
+
+// DecodeRefreshSessionRequest decode grpc request into expected internal request type
+func DecodeRefreshSessionRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.RefreshSessionRequest)
+	return RefreshSessionRequest{
+		Username:     req.Username,
+		RefreshToken: req.RefreshToken,
+	}, nil
+}
+
+// EncodeForgotPasswordRequest encodes internal request into the expected grpc request type
+func EncodeForgotPasswordRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(ForgotPasswordRequest)
+	return &pb.ForgotPasswordRequest{
+		Username: req.Username,
+	}, nil
+}
+
+// DecodeForgotPasswordRequest decode the grpc request into the expected internal request type
+func DecodeForgotPasswordRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.ForgotPasswordRequest)
+	return ForgotPasswordRequest{
+		Username: req.Username,
+	}, nil
+}
+
+// EncodeConfirmForgotPasswordRequest encodes internal request into the expected grpc request type
+func EncodeConfirmForgotPasswordRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(ConfirmForgotPasswordRequest)
+	return &pb.ConfirmForgotPasswordRequest{
+		Username: req.Username,
+		Code:     req.Code,
+		Password: req.Password,
+	}, nil
+}
+
+// DecodeConfirmForgotPasswordRequest decode the grpc request into the expected internal request type
+func DecodeConfirmForgotPasswordRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.ConfirmForgotPasswordRequest)
+	return ConfirmForgotPasswordRequest{
+		Username: req.Username,
+		Code:     req.Code,
+		Password: req.Password,
+	}, nil
+}
+
+// EncodeChangePasswordRequest encodes internal request into the expected grpc request type
+func EncodeChangePasswordRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(ChangePasswordRequest)
+	return &pb.ChangePasswordRequest{
+		Jwt:                  req.Jwt,
+		OldPassword:          req.OldPassword,
+		NewPassword:          req.NewPassword,
+		SignOutOtherSessions: req.SignOutOtherSessions,
+	}, nil
+}
+
+// DecodeChangePasswordRequest decode the grpc request into the expected internal request type
+func DecodeChangePasswordRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.ChangePasswordRequest)
+	return ChangePasswordRequest{
+		Jwt:                  req.Jwt,
+		OldPassword:          req.OldPassword,
+		NewPassword:          req.NewPassword,
+		SignOutOtherSessions: req.SignOutOtherSessions,
+	}, nil
+}
+
+// EncodeChangePasswordResponse encode the internal response into the expected grpc response type
+func EncodeChangePasswordResponse(ctx context.Context, r interface{}) (interface{}, error) {
+	resp := r.(ChangePasswordResponse)
+	pbResp := &pb.ChangePasswordResponse{
+		Ok: resp.Ok,
+	}
+	if resp.Session != nil {
+		session, err := EncodeLoginResponse(ctx, *resp.Session)
+		if err != nil {
+			return nil, err
+		}
+		pbResp.Session = session.(*pb.LoginResponse)
+	}
+
+	return pbResp, nil
+}
+
+// DecodeChangePasswordResponse decode the grpc response into the expected internal response type
+func DecodeChangePasswordResponse(ctx context.Context, r interface{}) (interface{}, error) {
+	resp := r.(*pb.ChangePasswordResponse)
+	changePasswordResp := ChangePasswordResponse{
+		Ok: resp.Ok,
+	}
+	if resp.Session != nil {
+		session, err := DecodeLoginResponse(ctx, resp.Session)
+		if err != nil {
+			return nil, err
+		}
+		loginResp := session.(LoginResponse)
+		changePasswordResp.Session = &loginResp
+	}
+
+	return changePasswordResp, nil
+}
+
+// EncodeLogoutRequest encodes internal request into the expected grpc request type
+func EncodeLogoutRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(LogoutRequest)
+	return &pb.LogoutRequest{
+		Username:     req.Username,
+		RefreshToken: req.RefreshToken,
+	}, nil
+}
+
+// DecodeLogoutRequest decode the grpc request into the expected internal request type
+func DecodeLogoutRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.LogoutRequest)
+	return LogoutRequest{
+		Username:     req.Username,
+		RefreshToken: req.RefreshToken,
+	}, nil
+}
+
+// EncodeGlobalSignOutRequest encodes internal request into the expected grpc request type
+func EncodeGlobalSignOutRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(GlobalSignOutRequest)
+	return &pb.GlobalSignOutRequest{
+		Jwt: req.Jwt,
+	}, nil
+}
+
+// DecodeGlobalSignOutRequest decode the grpc request into the expected internal request type
+func DecodeGlobalSignOutRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.GlobalSignOutRequest)
+	return GlobalSignOutRequest{
+		Jwt: req.Jwt,
 	}, nil
 }
 
@@ -225,15 +520,45 @@ func DecodeUserDetailsRequest(_ context.Context, r interface{}) (interface{}, er
 	}, nil
 }
 
+// EncodeVerifyJWTResponse encode the internal response into the expected grpc response type
+func EncodeVerifyJWTResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(VerifyJWTResponse)
+	return &pb.VerifyJWTResponse{
+		Ok:        resp.Ok,
+		Sub:       resp.Subject,
+		Username:  resp.Username,
+		Groups:    resp.Groups,
+		Scopes:    resp.Scopes,
+		ExpiresAt: resp.ExpiresAt,
+		IssuedAt:  resp.IssuedAt,
+	}, nil
+}
+
+// DecodeVerifyJWTResponse decode the grpc response into the expected internal response type
+func DecodeVerifyJWTResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(*pb.VerifyJWTResponse)
+	return VerifyJWTResponse{
+		Ok:        resp.Ok,
+		Subject:   resp.Sub,
+		Username:  resp.Username,
+		Groups:    resp.Groups,
+		Scopes:    resp.Scopes,
+		ExpiresAt: resp.ExpiresAt,
+		IssuedAt:  resp.IssuedAt,
+	}, nil
+}
+
 // EncodeUserDetailsResponse encode the internal response into the expected grpc response type
 func EncodeUserDetailsResponse(_ context.Context, r interface{}) (interface{}, error) {
 	resp := r.(UserDetailsResponse)
 	return &pb.UserDetailsResponse{
-		Id:          int32(resp.ID),
-		Username:    resp.Username,
-		Email:       resp.Email,
-		PhoneNumber: resp.PhoneNumber,
-		Confirmed:   resp.Confirmed,
+		Id:                  int32(resp.ID),
+		Username:            resp.Username,
+		Email:               resp.Email,
+		PhoneNumber:         resp.PhoneNumber,
+		Confirmed:           resp.Confirmed,
+		EmailVerified:       resp.EmailVerified,
+		PhoneNumberVerified: resp.PhoneNumberVerified,
 	}, nil
 }
 
@@ -241,27 +566,253 @@ func EncodeUserDetailsResponse(_ context.Context, r interface{}) (interface{}, e
 func DecodeUserDetailsResponse(_ context.Context, r interface{}) (interface{}, error) {
 	resp := r.(*pb.UserDetailsResponse)
 	return UserDetailsResponse{
-		ID:          int(resp.Id),
-		Username:    resp.Username,
-		Email:       resp.Email,
-		PhoneNumber: resp.PhoneNumber,
-		Confirmed:   resp.Confirmed,
+		ID:                  int(resp.Id),
+		Username:            resp.Username,
+		Email:               resp.Email,
+		PhoneNumber:         resp.PhoneNumber,
+		Confirmed:           resp.Confirmed,
+		EmailVerified:       resp.EmailVerified,
+		PhoneNumberVerified: resp.PhoneNumberVerified,
+	}, nil
+}
+
+// EncodeGetPasswordPolicyRequest encodes internal request into the expected grpc request type
+func EncodeGetPasswordPolicyRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.GetPasswordPolicyRequest{}, nil
+}
+
+// DecodeGetPasswordPolicyRequest decode the grpc request into the expected internal request type
+func DecodeGetPasswordPolicyRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return GetPasswordPolicyRequest{}, nil
+}
+
+// EncodePasswordPolicyResponse encode the internal response into the expected grpc response type
+func EncodePasswordPolicyResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(PasswordPolicyResponse)
+	return &pb.PasswordPolicyResponse{
+		Required:  resp.Required,
+		MinLength: int32(resp.MinLength),
+		MaxLength: int32(resp.MaxLength),
+		Regex:     resp.Regex,
 	}, nil
 }
 
-// Username cannot be empty and must be between 2 and 100 characters
-func validUsername(username *string) *validation.FieldRules {
-	return validation.Field(username, validation.Required, validation.Length(2, 100))
+// DecodePasswordPolicyResponse decode the grpc response into the expected internal response type
+func DecodePasswordPolicyResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(*pb.PasswordPolicyResponse)
+	return PasswordPolicyResponse{
+		Required:  resp.Required,
+		MinLength: int(resp.MinLength),
+		MaxLength: int(resp.MaxLength),
+		Regex:     resp.Regex,
+	}, nil
 }
 
-// Password cannot be empty and must be between 8 and 100 characters
+// EncodeGetProfileRequest encodes the internal request into the grpc request type
+func EncodeGetProfileRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(GetProfileRequest)
+	return &pb.GetProfileRequest{
+		Jwt: req.Jwt,
+	}, nil
+}
+
+// DecodeGetProfileRequest decode the grpc request into the expected internal request type
+func DecodeGetProfileRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.GetProfileRequest)
+	return GetProfileRequest{
+		Jwt: req.Jwt,
+	}, nil
+}
+
+// EncodeUpdateProfileRequest encodes the internal request into the grpc request type
+func EncodeUpdateProfileRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(UpdateProfileRequest)
+	return &pb.UpdateProfileRequest{
+		Jwt:         req.Jwt,
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		DisplayName: req.DisplayName,
+		AvatarUrl:   req.AvatarURL,
+		Bio:         req.Bio,
+		Location:    req.Location,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+		DateOfBirth: req.DateOfBirth,
+	}, nil
+}
+
+// DecodeUpdateProfileRequest decode the grpc request into the expected internal request type
+func DecodeUpdateProfileRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.UpdateProfileRequest)
+	return UpdateProfileRequest{
+		Jwt:         req.Jwt,
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		DisplayName: req.DisplayName,
+		AvatarURL:   req.AvatarUrl,
+		Bio:         req.Bio,
+		Location:    req.Location,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+		DateOfBirth: req.DateOfBirth,
+	}, nil
+}
+
+// EncodeProfileResponse encode the internal response into the expected grpc response type
+func EncodeProfileResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(ProfileResponse)
+	return &pb.ProfileResponse{
+		FirstName:   resp.FirstName,
+		LastName:    resp.LastName,
+		DisplayName: resp.DisplayName,
+		AvatarUrl:   resp.AvatarURL,
+		Bio:         resp.Bio,
+		Location:    resp.Location,
+		Locale:      resp.Locale,
+		Timezone:    resp.Timezone,
+		DateOfBirth: resp.DateOfBirth,
+		UpdatedAt:   resp.UpdatedAt,
+	}, nil
+}
+
+// DecodeProfileResponse decode the grpc response into the expected internal response type
+func DecodeProfileResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(*pb.ProfileResponse)
+	return ProfileResponse{
+		FirstName:   resp.FirstName,
+		LastName:    resp.LastName,
+		DisplayName: resp.DisplayName,
+		AvatarURL:   resp.AvatarUrl,
+		Bio:         resp.Bio,
+		Location:    resp.Location,
+		Locale:      resp.Locale,
+		Timezone:    resp.Timezone,
+		DateOfBirth: resp.DateOfBirth,
+		UpdatedAt:   resp.UpdatedAt,
+	}, nil
+}
+
+// EncodeUpdateContactDetailsRequest encodes the internal request into the grpc request type
+func EncodeUpdateContactDetailsRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(UpdateContactDetailsRequest)
+	return &pb.UpdateContactDetailsRequest{
+		Jwt:         req.Jwt,
+		Email:       req.Email,
+		PhoneNumber: req.PhoneNumber,
+	}, nil
+}
+
+// DecodeUpdateContactDetailsRequest decode the grpc request into the expected internal request type
+func DecodeUpdateContactDetailsRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.UpdateContactDetailsRequest)
+	return UpdateContactDetailsRequest{
+		Jwt:         req.Jwt,
+		Email:       req.Email,
+		PhoneNumber: req.PhoneNumber,
+	}, nil
+}
+
+// EncodeUpdateContactDetailsResponse encode the internal response into the expected grpc response type
+func EncodeUpdateContactDetailsResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(UpdateContactDetailsResponse)
+	deliveries := make([]*pb.CodeDelivery, 0, len(resp.CodeDeliveries))
+	for _, d := range resp.CodeDeliveries {
+		deliveries = append(deliveries, &pb.CodeDelivery{
+			AttributeName:  d.AttributeName,
+			DeliveryMedium: d.DeliveryMedium,
+			Destination:    d.Destination,
+		})
+	}
+
+	return &pb.UpdateContactDetailsResponse{
+		CodeDeliveries: deliveries,
+	}, nil
+}
+
+// DecodeUpdateContactDetailsResponse decode the grpc response into the expected internal response type
+func DecodeUpdateContactDetailsResponse(_ context.Context, r interface{}) (interface{}, error) {
+	resp := r.(*pb.UpdateContactDetailsResponse)
+	deliveries := make([]CodeDelivery, 0, len(resp.CodeDeliveries))
+	for _, d := range resp.CodeDeliveries {
+		deliveries = append(deliveries, CodeDelivery{
+			AttributeName:  d.AttributeName,
+			DeliveryMedium: d.DeliveryMedium,
+			Destination:    d.Destination,
+		})
+	}
+
+	return UpdateContactDetailsResponse{
+		CodeDeliveries: deliveries,
+	}, nil
+}
+
+// EncodeVerifyAttributeRequest encodes the internal request into the grpc request type
+func EncodeVerifyAttributeRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(VerifyAttributeRequest)
+	return &pb.VerifyAttributeRequest{
+		Jwt:       req.Jwt,
+		Attribute: req.Attribute,
+		Code:      req.Code,
+	}, nil
+}
+
+// DecodeVerifyAttributeRequest decode the grpc request into the expected internal request type
+func DecodeVerifyAttributeRequest(_ context.Context, r interface{}) (interface{}, error) {
+	req := r.(*pb.VerifyAttributeRequest)
+	return VerifyAttributeRequest{
+		Jwt:       req.Jwt,
+		Attribute: req.Attribute,
+		Code:      req.Code,
+	}, nil
+}
+
+// notCompiled fails validation with an internal error when the rules' patterns weren't compiled by
+// config.Validation.Compile, rather than letting every value through
+var notCompiled = validation.By(func(interface{}) error {
+	return validation.NewInternalError(errors.New("Validation rules have not been compiled"))
+})
+
+// Username cannot be empty and must have the configured length and characters
+func validUsername(username *string, rules config.Username) *validation.FieldRules {
+	rr := []validation.Rule{
+		validation.Required,
+		validation.Length(rules.Length.Min, rules.Length.Max),
+	}
+	if rules.Pattern != "" {
+		if rules.PatternRegexp == nil {
+			rr = append(rr, notCompiled)
+		} else {
+			rr = append(rr, validation.Match(rules.PatternRegexp))
+		}
+	}
+
+	return validation.Field(username, rr...)
+}
+
+// Code cannot be empty and must have the configured length and format
+func validCode(code *string, rules config.OTP) *validation.FieldRules {
+	rr := []validation.Rule{
+		validation.Required,
+	}
+	if rules.Digits {
+		rr = append(rr, is.Digit)
+	}
+	rr = append(rr, validation.Length(rules.Length, rules.Length))
+
+	return validation.Field(code, rr...)
+}
+
+// Password cannot be empty and must have the configured length and match every regex
 func validPassword(password *string, rules config.Password) *validation.FieldRules {
 	rr := []validation.Rule{
 		validation.Required,
 		validation.Length(rules.Length.Min, rules.Length.Max),
 	}
-	for _, regex := range rules.Regex {
-		rr = append(rr, validation.Match(regexp.MustCompile(regex)))
+	if len(rules.Regexps) != len(rules.Regex) {
+		rr = append(rr, notCompiled)
+	}
+	for _, re := range rules.Regexps {
+		rr = append(rr, validation.Match(re))
 	}
 
 	return validation.Field(
@@ -271,56 +822,188 @@ func validPassword(password *string, rules config.Password) *validation.FieldRul
 }
 
 // Validate the request payload
-func (r CreateUserRequest) Validate(pwRules config.Password) error {
+func (r CreateUserRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+		// Email connot be empty and must be a valid email address, ownership is proven by the OTP so
+		// there's no need for is.Email's DNS lookup
+		validation.Field(&r.Email, validation.Required, is.EmailFormat),
+		validPassword(&r.Password, rules.Password),
+	)
+}
+
+// Validate the request payload
+func (r ConfirmUserRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+		validCode(&r.Code, rules.OTP),
+	)
+}
+
+// Validate the request payload
+func (r ResendConfirmationRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+	)
+}
+
+// Validate the request payload
+func (r UsernameTakenRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+	)
+}
+
+// Validate the request payload, the password isn't held to the policy as it may have been set under an
+// older one
+func (r LoginRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+		validation.Field(&r.Password, validation.Required, validation.Length(0, rules.Password.Length.Max)),
+	)
+}
+
+// Validate the request payload
+func (r RefreshSessionRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+		validation.Field(&r.RefreshToken, validation.Required),
+	)
+}
+
+// Validate the request payload
+func (r ForgotPasswordRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+	)
+}
+
+// Validate the request payload
+func (r ConfirmForgotPasswordRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validUsername(&r.Username, rules.Username),
+		validCode(&r.Code, rules.OTP),
+		validPassword(&r.Password, rules.Password),
+	)
+}
+
+// Validate the request payload
+func (r ChangePasswordRequest) Validate(rules config.Validation) error {
 	return validation.ValidateStruct(&r,
-		validUsername(&r.Username),
-		// Email connot be empty and must be a valid email address
-		validation.Field(&r.Email, validation.Required, is.Email),
-		validPassword(&r.Password, pwRules),
+		validation.Field(&r.Jwt, validation.Required),
+		validation.Field(&r.OldPassword, validation.Required),
+		validPassword(&r.NewPassword, rules.Password),
 	)
 }
 
 // Validate the request payload
-func (r ConfirmUserRequest) Validate() error {
+func (r LogoutRequest) Validate(rules config.Validation) error {
 	return validation.ValidateStruct(&r,
-		validUsername(&r.Username),
-		// Code cannot be empty and must be 6 digits
-		validation.Field(&r.Code, validation.Required, is.Digit, validation.Length(6, 6)),
+		validUsername(&r.Username, rules.Username),
+		validation.Field(&r.RefreshToken, validation.Required),
 	)
 }
 
 // Validate the request payload
-func (r ResendConfirmationRequest) Validate() error {
+func (r GlobalSignOutRequest) Validate(rules config.Validation) error {
 	return validation.ValidateStruct(&r,
-		validUsername(&r.Username),
+		validation.Field(&r.Jwt, validation.Required),
 	)
 }
 
 // Validate the request payload
-func (r UsernameTakenRequest) Validate() error {
+func (r VerifyJWTRequest) Validate(rules config.Validation) error {
 	return validation.ValidateStruct(&r,
-		validUsername(&r.Username),
+		validation.Field(&r.Jwt, validation.Required),
 	)
 }
 
 // Validate the request payload
-func (r LoginRequest) Validate(rules config.Password) error {
+func (r UserDetailsRequest) Validate(rules config.Validation) error {
 	return validation.ValidateStruct(&r,
-		validUsername(&r.Username),
-		validPassword(&r.Password, rules),
+		validation.Field(&r.Jwt, validation.Required),
 	)
 }
 
 // Validate the request payload
-func (r VerifyJWTRequest) Validate() error {
+func (r GetProfileRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Jwt, validation.Required),
+	)
+}
+
+// Validate the request payload, every field may be left empty and is limited to the size it's stored in
+func (r UpdateProfileRequest) Validate(rules config.Validation) error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Jwt, validation.Required),
+		validation.Field(&r.FirstName, validation.RuneLength(0, 100)),
+		validation.Field(&r.LastName, validation.RuneLength(0, 100)),
+		validation.Field(&r.DisplayName, validation.RuneLength(0, 50)),
+		// The avatar is shown by the front end, so it must be a web address
+		validation.Field(&r.AvatarURL, validation.Length(0, 2048), is.URL, validation.Match(webURL)),
+		validation.Field(&r.Bio, validation.RuneLength(0, 1000)),
+		validation.Field(&r.Location, validation.RuneLength(0, 100)),
+		validation.Field(&r.Locale, validation.Length(0, 35), validation.By(validLocale)),
+		validation.Field(&r.Timezone, validation.Length(0, 64), validation.By(validTimezone)),
+		validation.Field(&r.DateOfBirth,
+			validation.Date(DateLayout).Min(earliestDateOfBirth).Max(time.Now()).RangeErrorObject(errDateOfBirth),
+		),
+	)
+}
+
+// Validate the request payload, at least one of the email and phone number must be given
+func (r UpdateContactDetailsRequest) Validate(rules config.Validation) error {
 	return validation.ValidateStruct(&r,
 		validation.Field(&r.Jwt, validation.Required),
+		// Ownership of the new email or phone number is proven by the code sent to it
+		validation.Field(&r.Email, validation.When(r.PhoneNumber == "", validation.Required), is.EmailFormat),
+		// Phone numbers are stored in E.164 format, like +447700900123
+		validation.Field(&r.PhoneNumber, is.E164.Error("must be in E.164 format, like +447700900123")),
 	)
 }
 
 // Validate the request payload
-func (r UserDetailsRequest) Validate() error {
+func (r VerifyAttributeRequest) Validate(rules config.Validation) error {
 	return validation.ValidateStruct(&r,
 		validation.Field(&r.Jwt, validation.Required),
+		validation.Field(&r.Attribute, validation.Required, validation.In(model.AttributeEmail, model.AttributePhoneNumber)),
+		validCode(&r.Code, rules.OTP),
 	)
 }
+
+// webURL matches http and https addresses
+var webURL = regexp.MustCompile(`^https?://`)
+
+// earliestDateOfBirth is the earliest date of birth taken, earlier ones are surely mistakes
+var earliestDateOfBirth = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var errDateOfBirth = validation.NewError("validation_date_of_birth", "must not be in the future or before 1900")
+
+// Locale must be a BCP 47 language tag, like en-GB
+func validLocale(value interface{}) error {
+	locale, _ := value.(string)
+	if locale == "" {
+		return nil
+	}
+
+	_, err := language.Parse(locale)
+	if err != nil {
+		return validation.NewError("validation_is_locale", "must be a BCP 47 language tag, like en-GB")
+	}
+	return nil
+}
+
+// Timezone must be a name from the IANA time zone database, like Europe/London
+func validTimezone(value interface{}) error {
+	timezone, _ := value.(string)
+	if timezone == "" {
+		return nil
+	}
+
+	// LoadLocation also takes Local, which means nothing to anyone else
+	_, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "Local" {
+		return validation.NewError("validation_is_timezone", "must be an IANA time zone, like Europe/London")
+	}
+	return nil
+}
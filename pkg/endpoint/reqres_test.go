@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/PedPet/user/config"
 	"github.com/bxcodec/faker/v3"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,6 +24,25 @@ func init() {
 	if err != nil {
 		log.Panicf("Failed to decode json: %s", err)
 	}
+	err = rules.Compile()
+	if err != nil {
+		log.Panicf("Failed to compile validation rules: %s", err)
+	}
+}
+
+func TestUncompiledRulesFailClosed(t *testing.T) {
+	uncompiled := rules
+	uncompiled.Username.PatternRegexp = nil
+	uncompiled.Password.Regexps = nil
+
+	err := CreateUserRequest{
+		Username: faker.Username(),
+		Password: faker.Password() + "1!",
+		Email:    "scrott@gmail.com",
+	}.Validate(uncompiled)
+	_, internal := err.(validation.InternalError)
+	assert.True(t, internal, "Expected an internal error, not an invalid request")
+	assert.EqualError(t, err, "Validation rules have not been compiled")
 }
 
 func TestCreateUserRequestValidate(t *testing.T) {
@@ -88,7 +109,7 @@ func TestCreateUserRequestValidate(t *testing.T) {
 			payload: CreateUserRequest{
 				Username: faker.Username(),
 				Password: faker.Password() + "!4",
-				Email:    faker.Email(),
+				Email:    "scrott@",
 			},
 			expected: "email: must be a valid email address.",
 		},
@@ -98,7 +119,7 @@ func TestCreateUserRequestValidate(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := tc.payload.Validate(rules.Password)
+			err := tc.payload.Validate(rules)
 			if err != nil {
 				assert.EqualError(t, err, tc.expected)
 				return
@@ -167,7 +188,7 @@ func TestConfirmUserRequestValidate(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := tc.payload.Validate()
+			err := tc.payload.Validate(rules)
 			if err != nil {
 				assert.EqualError(t, err, tc.expected)
 				return
@@ -178,6 +199,17 @@ func TestConfirmUserRequestValidate(t *testing.T) {
 	}
 }
 
+func TestConfirmUserRequestValidateOTPRules(t *testing.T) {
+	otpRules := rules
+	otpRules.OTP = config.OTP{Length: 8, Digits: false}
+
+	err := ConfirmUserRequest{Username: faker.Username(), Code: "ab12cd34"}.Validate(otpRules)
+	assert.NoError(t, err)
+
+	err = ConfirmUserRequest{Username: faker.Username(), Code: "123456"}.Validate(otpRules)
+	assert.EqualError(t, err, "code: the length must be exactly 8.")
+}
+
 func TestResendConfirmationRequest(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -209,7 +241,7 @@ func TestResendConfirmationRequest(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := tc.payload.Validate()
+			err := tc.payload.Validate(rules)
 			if err != nil {
 				assert.EqualError(t, err, tc.expected)
 				return
@@ -245,13 +277,20 @@ func TestUsernameTakenRequestValidate(t *testing.T) {
 			},
 			expected: "username: the length must be between 2 and 100.",
 		},
+		{
+			name: "Invalid username characters",
+			payload: UsernameTakenRequest{
+				Username: "scott crossan",
+			},
+			expected: "username: must be in a valid format.",
+		},
 	}
 
 	for _, tc := range testCases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := tc.payload.Validate()
+			err := tc.payload.Validate(rules)
 			if err != nil {
 				assert.EqualError(t, err, tc.expected)
 				return
@@ -300,9 +339,110 @@ func TestLoginRequestValidate(t *testing.T) {
 			expected: "username: the length must be between 2 and 100.",
 		},
 		{
-			name: "Invalid password",
+			name: "Password set under an older policy",
+			payload: LoginRequest{
+				Username: faker.Username(),
+				Password: "password",
+			},
+			expected: "",
+		},
+		{
+			name: "Password too long",
 			payload: LoginRequest{
 				Username: faker.Username(),
+				Password: strings.Repeat("a", 101),
+			},
+			expected: "password: the length must be no more than 100.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestForgotPasswordRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  ForgotPasswordRequest
+		expected string
+	}{
+		{
+			name: "Valid",
+			payload: ForgotPasswordRequest{
+				Username: faker.Username(),
+			},
+			expected: "",
+		},
+		{
+			name:     "Missing username",
+			payload:  ForgotPasswordRequest{},
+			expected: "username: cannot be blank.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestConfirmForgotPasswordRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  ConfirmForgotPasswordRequest
+		expected string
+	}{
+		{
+			name: "Valid",
+			payload: ConfirmForgotPasswordRequest{
+				Username: faker.Username(),
+				Code:     "123456",
+				Password: faker.Password() + "1!",
+			},
+			expected: "",
+		},
+		{
+			name: "Missing code",
+			payload: ConfirmForgotPasswordRequest{
+				Username: faker.Username(),
+				Password: faker.Password() + "1!",
+			},
+			expected: "code: cannot be blank.",
+		},
+		{
+			name: "Incorrect code string",
+			payload: ConfirmForgotPasswordRequest{
+				Username: faker.Username(),
+				Code:     "abcdef",
+				Password: faker.Password() + "1!",
+			},
+			expected: "code: must contain digits only.",
+		},
+		{
+			name: "Invalid password",
+			payload: ConfirmForgotPasswordRequest{
+				Username: faker.Username(),
+				Code:     "123456",
 				Password: faker.Password(),
 			},
 			expected: "password: must be in a valid format.",
@@ -313,7 +453,135 @@ func TestLoginRequestValidate(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := tc.payload.Validate(rules.Password)
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestChangePasswordRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  ChangePasswordRequest
+		expected string
+	}{
+		{
+			name: "Valid",
+			payload: ChangePasswordRequest{
+				Jwt:         "header.payload.signature",
+				OldPassword: faker.Password(),
+				NewPassword: faker.Password() + "1!",
+			},
+			expected: "",
+		},
+		{
+			name: "Missing jwt",
+			payload: ChangePasswordRequest{
+				OldPassword: faker.Password(),
+				NewPassword: faker.Password() + "1!",
+			},
+			expected: "jwt: cannot be blank.",
+		},
+		{
+			name: "Missing old password",
+			payload: ChangePasswordRequest{
+				Jwt:         "header.payload.signature",
+				NewPassword: faker.Password() + "1!",
+			},
+			expected: "oldPassword: cannot be blank.",
+		},
+		{
+			name: "Invalid new password",
+			payload: ChangePasswordRequest{
+				Jwt:         "header.payload.signature",
+				OldPassword: faker.Password(),
+				NewPassword: faker.Password(),
+			},
+			expected: "newPassword: must be in a valid format.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestLogoutRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  LogoutRequest
+		expected string
+	}{
+		{
+			name: "Valid",
+			payload: LogoutRequest{
+				Username:     faker.Username(),
+				RefreshToken: "refresh-token",
+			},
+			expected: "",
+		},
+		{
+			name: "Missing refresh token",
+			payload: LogoutRequest{
+				Username: faker.Username(),
+			},
+			expected: "refreshToken: cannot be blank.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestGlobalSignOutRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  GlobalSignOutRequest
+		expected string
+	}{
+		{
+			name:     "Valid",
+			payload:  GlobalSignOutRequest{Jwt: "header.payload.signature"},
+			expected: "",
+		},
+		{
+			name:     "Missing jwt",
+			payload:  GlobalSignOutRequest{},
+			expected: "jwt: cannot be blank.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
 			if err != nil {
 				assert.EqualError(t, err, tc.expected)
 				return
@@ -348,7 +616,7 @@ func TestVerifyJWTRequestValidate(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := tc.payload.Validate()
+			err := tc.payload.Validate(rules)
 			if err != nil {
 				assert.EqualError(t, err, tc.expected)
 				return
@@ -383,7 +651,187 @@ func TestUserDetailsRequest(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := tc.payload.Validate()
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestUpdateProfileRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  UpdateProfileRequest
+		expected string
+	}{
+		{
+			name: "Valid",
+			payload: UpdateProfileRequest{
+				Jwt:         faker.Word(),
+				FirstName:   "Scott",
+				LastName:    "Crott",
+				DisplayName: "scrott",
+				AvatarURL:   "https://cdn.pedpet.com/avatars/scrott.png",
+				Bio:         "Owner of two cats 🐈",
+				Location:    "Leeds",
+				Locale:      "en-GB",
+				Timezone:    "Europe/London",
+				DateOfBirth: "1990-03-14",
+			},
+			expected: "",
+		},
+		{
+			name:     "Empty profile",
+			payload:  UpdateProfileRequest{Jwt: faker.Word()},
+			expected: "",
+		},
+		{
+			name:     "Missing JWT",
+			payload:  UpdateProfileRequest{FirstName: "Scott"},
+			expected: "jwt: cannot be blank.",
+		},
+		{
+			name:     "Avatar not a web address",
+			payload:  UpdateProfileRequest{Jwt: faker.Word(), AvatarURL: "ftp://cdn.pedpet.com/scrott.png"},
+			expected: "avatarUrl: must be in a valid format.",
+		},
+		{
+			name:     "Invalid locale",
+			payload:  UpdateProfileRequest{Jwt: faker.Word(), Locale: "english"},
+			expected: "locale: must be a BCP 47 language tag, like en-GB.",
+		},
+		{
+			name:     "Invalid timezone",
+			payload:  UpdateProfileRequest{Jwt: faker.Word(), Timezone: "Europe/Leeds"},
+			expected: "timezone: must be an IANA time zone, like Europe/London.",
+		},
+		{
+			name:     "Local timezone",
+			payload:  UpdateProfileRequest{Jwt: faker.Word(), Timezone: "Local"},
+			expected: "timezone: must be an IANA time zone, like Europe/London.",
+		},
+		{
+			name:     "Invalid date of birth",
+			payload:  UpdateProfileRequest{Jwt: faker.Word(), DateOfBirth: "14/03/1990"},
+			expected: "dateOfBirth: must be a valid date.",
+		},
+		{
+			name:     "Date of birth in the future",
+			payload:  UpdateProfileRequest{Jwt: faker.Word(), DateOfBirth: "2990-03-14"},
+			expected: "dateOfBirth: must not be in the future or before 1900.",
+		},
+		{
+			name:     "Display name too long",
+			payload:  UpdateProfileRequest{Jwt: faker.Word(), DisplayName: strings.Repeat("🐈", 51)},
+			expected: "displayName: the length must be no more than 50.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestUpdateContactDetailsRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  UpdateContactDetailsRequest
+		expected string
+	}{
+		{
+			name:     "Valid",
+			payload:  UpdateContactDetailsRequest{Jwt: faker.Word(), Email: "scott@example.com", PhoneNumber: "+447700900123"},
+			expected: "",
+		},
+		{
+			name:     "Only the phone number",
+			payload:  UpdateContactDetailsRequest{Jwt: faker.Word(), PhoneNumber: "+447700900123"},
+			expected: "",
+		},
+		{
+			name:     "Missing JWT",
+			payload:  UpdateContactDetailsRequest{Email: "scott@example.com"},
+			expected: "jwt: cannot be blank.",
+		},
+		{
+			name:     "Nothing to change",
+			payload:  UpdateContactDetailsRequest{Jwt: faker.Word()},
+			expected: "email: cannot be blank.",
+		},
+		{
+			name:     "Invalid email",
+			payload:  UpdateContactDetailsRequest{Jwt: faker.Word(), Email: "scott"},
+			expected: "email: must be a valid email address.",
+		},
+		{
+			name:     "Phone number not in E.164",
+			payload:  UpdateContactDetailsRequest{Jwt: faker.Word(), PhoneNumber: "07700 900123"},
+			expected: "phoneNumber: must be in E.164 format, like +447700900123.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
+			if err != nil {
+				assert.EqualError(t, err, tc.expected)
+				return
+			}
+
+			assert.True(t, err == nil && tc.expected == "", tc.expected)
+		})
+	}
+}
+
+func TestVerifyAttributeRequestValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payload  VerifyAttributeRequest
+		expected string
+	}{
+		{
+			name:     "Valid",
+			payload:  VerifyAttributeRequest{Jwt: faker.Word(), Attribute: "phone_number", Code: "123456"},
+			expected: "",
+		},
+		{
+			name:     "Missing JWT",
+			payload:  VerifyAttributeRequest{Attribute: "email", Code: "123456"},
+			expected: "jwt: cannot be blank.",
+		},
+		{
+			name:     "Unknown attribute",
+			payload:  VerifyAttributeRequest{Jwt: faker.Word(), Attribute: "address", Code: "123456"},
+			expected: "attribute: must be a valid value.",
+		},
+		{
+			name:     "Invalid code",
+			payload:  VerifyAttributeRequest{Jwt: faker.Word(), Attribute: "email", Code: "12345"},
+			expected: "code: the length must be exactly 6.",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.payload.Validate(rules)
 			if err != nil {
 				assert.EqualError(t, err, tc.expected)
 				return
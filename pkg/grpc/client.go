@@ -8,63 +8,173 @@ import (
 	"google.golang.org/grpc"
 )
 
-func NewClient(conn *grpc.ClientConn) service.User {
+var _ service.User = endpoint.Endpoints{}
+
+// NewClient creates the endpoints calling the user service over grpc, they satisfy service.User and
+// return the service's errors as the service would
+func NewClient(conn *grpc.ClientConn) endpoint.Endpoints {
 	return endpoint.Endpoints{
-		CreateUserEndpoint: grpctransport.NewClient(
+		CreateUserEndpoint: decodeErrors(grpctransport.NewClient(
 			conn,
 			"User",
 			"CreateUser",
 			endpoint.EncodeCreateUserRequest,
 			endpoint.DecodeConfirmResponse,
 			pb.ConfirmResponse{},
-		).Endpoint(),
-		ConfirmUserEndpoint: grpctransport.NewClient(
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		ConfirmUserEndpoint: decodeErrors(grpctransport.NewClient(
 			conn,
 			"User",
 			"ConfirmUser",
 			endpoint.EncodeConfirmUserRequest,
 			endpoint.DecodeConfirmResponse,
 			pb.ConfirmResponse{},
-		).Endpoint(),
-		ResendConfirmationEndpoint: grpctransport.NewClient(
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		ResendConfirmationEndpoint: decodeErrors(grpctransport.NewClient(
 			conn,
 			"User",
 			"ResendConfirmation",
 			endpoint.EncodeResendConfirmationRequest,
 			endpoint.DecodeConfirmResponse,
 			pb.ConfirmResponse{},
-		).Endpoint(),
-		UsernameTakenEndpoint: grpctransport.NewClient(
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		UsernameTakenEndpoint: decodeErrors(grpctransport.NewClient(
 			conn,
 			"User",
 			"UsernameTaken",
 			endpoint.EncodeUsernameTakenRequest,
 			endpoint.DecodeConfirmResponse,
 			pb.ConfirmResponse{},
-		).Endpoint(),
-		LoginEndpoint: grpctransport.NewClient(
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		LoginEndpoint: decodeErrors(grpctransport.NewClient(
 			conn,
 			"User",
 			"Login",
 			endpoint.EncodeLoginRequest,
 			endpoint.DecodeLoginResponse,
 			pb.LoginResponse{},
-		).Endpoint(),
-		VerifyJWTEndpoint: grpctransport.NewClient(
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		RefreshSessionEndpoint: decodeErrors(grpctransport.NewClient(
 			conn,
 			"User",
-			"VerifyJWT",
-			endpoint.EncodeVerifyJWTRequest,
+			"RefreshSession",
+			endpoint.EncodeRefreshSessionRequest,
+			endpoint.DecodeLoginResponse,
+			pb.LoginResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		ForgotPasswordEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"ForgotPassword",
+			endpoint.EncodeForgotPasswordRequest,
 			endpoint.DecodeConfirmResponse,
 			pb.ConfirmResponse{},
-		).Endpoint(),
-		UserDetailsEndpoint: grpctransport.NewClient(
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		ConfirmForgotPasswordEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"ConfirmForgotPassword",
+			endpoint.EncodeConfirmForgotPasswordRequest,
+			endpoint.DecodeConfirmResponse,
+			pb.ConfirmResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		ChangePasswordEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"ChangePassword",
+			endpoint.EncodeChangePasswordRequest,
+			endpoint.DecodeChangePasswordResponse,
+			pb.ChangePasswordResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		LogoutEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"Logout",
+			endpoint.EncodeLogoutRequest,
+			endpoint.DecodeConfirmResponse,
+			pb.ConfirmResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		GlobalSignOutEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"GlobalSignOut",
+			endpoint.EncodeGlobalSignOutRequest,
+			endpoint.DecodeConfirmResponse,
+			pb.ConfirmResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		VerifyJWTEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"VerifyJWT",
+			endpoint.EncodeVerifyJWTRequest,
+			endpoint.DecodeVerifyJWTResponse,
+			pb.VerifyJWTResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		UserDetailsEndpoint: decodeErrors(grpctransport.NewClient(
 			conn,
 			"User",
 			"UserDetails",
 			endpoint.EncodeUserDetailsRequest,
 			endpoint.DecodeUserDetailsResponse,
 			pb.UserDetailsResponse{},
-		).Endpoint(),
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		GetPasswordPolicyEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"GetPasswordPolicy",
+			endpoint.EncodeGetPasswordPolicyRequest,
+			endpoint.DecodePasswordPolicyResponse,
+			pb.PasswordPolicyResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		GetProfileEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"GetProfile",
+			endpoint.EncodeGetProfileRequest,
+			endpoint.DecodeProfileResponse,
+			pb.ProfileResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		UpdateProfileEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"UpdateProfile",
+			endpoint.EncodeUpdateProfileRequest,
+			endpoint.DecodeProfileResponse,
+			pb.ProfileResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		UpdateContactDetailsEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"UpdateContactDetails",
+			endpoint.EncodeUpdateContactDetailsRequest,
+			endpoint.DecodeUpdateContactDetailsResponse,
+			pb.UpdateContactDetailsResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
+		VerifyAttributeEndpoint: decodeErrors(grpctransport.NewClient(
+			conn,
+			"User",
+			"VerifyAttribute",
+			endpoint.EncodeVerifyAttributeRequest,
+			endpoint.DecodeConfirmResponse,
+			pb.ConfirmResponse{},
+			grpctransport.ClientBefore(requestIDToOutgoingMetadata, traceContextToOutgoingMetadata),
+		).Endpoint()),
 	}
 }
@@ -0,0 +1,103 @@
+package grpc
+
+import (
+	"context"
+	"sort"
+
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/service"
+	"github.com/go-kit/kit/endpoint"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorCodes is the grpc code each of the service's errors is sent with, the client uses the code and
+// message to turn the status back into the error
+var errorCodes = map[error]codes.Code{
+	service.ErrUserNotFound:     codes.NotFound,
+	service.ErrUsernameExists:   codes.AlreadyExists,
+	service.ErrInvalidCode:      codes.InvalidArgument,
+	service.ErrCodeExpired:      codes.FailedPrecondition,
+	service.ErrUserNotConfirmed: codes.FailedPrecondition,
+	service.ErrWrongPassword:    codes.Unauthenticated,
+	service.ErrNotAuthorized:    codes.Unauthenticated,
+	service.ErrTooManyAttempts:  codes.ResourceExhausted,
+	service.ErrInvalidPassword:  codes.InvalidArgument,
+	service.ErrPhoneUnsupported: codes.FailedPrecondition,
+	auth.ErrTokenMissing:        codes.Unauthenticated,
+	auth.ErrTokenMalformed:      codes.Unauthenticated,
+	auth.ErrTokenAlgorithm:      codes.Unauthenticated,
+	auth.ErrTokenKeyID:          codes.Unauthenticated,
+	auth.ErrTokenSignature:      codes.Unauthenticated,
+	auth.ErrTokenExpired:        codes.Unauthenticated,
+	auth.ErrTokenNotYetValid:    codes.Unauthenticated,
+	auth.ErrTokenIssuer:         codes.Unauthenticated,
+	auth.ErrTokenAudience:       codes.Unauthenticated,
+	auth.ErrTokenUse:            codes.Unauthenticated,
+	auth.ErrTokenRevoked:        codes.Unauthenticated,
+}
+
+// encodeError translates errors from the endpoints into grpc statuses. Errors the service doesn't know
+// are internal and their message isn't sent, it may hold details of our dependencies.
+func encodeError(err error) error {
+	if errs, ok := err.(validation.Errors); ok {
+		return invalidArgument(errs)
+	}
+	if code, ok := errorCodes[err]; ok {
+		return status.Error(code, err.Error())
+	}
+
+	return status.Error(codes.Internal, "Internal error")
+}
+
+// decodeError turns a status sent by encodeError back into the service's error, other statuses are
+// returned as they are
+func decodeError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	for serviceErr, code := range errorCodes {
+		if st.Code() == code && st.Message() == serviceErr.Error() {
+			return serviceErr
+		}
+	}
+	return err
+}
+
+// decodeErrors is a client middleware applying decodeError to every failed call
+func decodeErrors(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		if err != nil {
+			return nil, decodeError(err)
+		}
+		return response, nil
+	}
+}
+
+// invalidArgument describes each invalid field in a BadRequest detail
+func invalidArgument(errs validation.Errors) error {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	badRequest := &errdetails.BadRequest{}
+	for _, field := range fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: errs[field].Error(),
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, errs.Error()).WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, errs.Error())
+	}
+	return st.Err()
+}
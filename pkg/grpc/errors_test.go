@@ -0,0 +1,52 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/PedPet/user/pkg/service"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEncodeError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+	}{
+		{
+			name:    "Service error",
+			err:     service.ErrUserNotFound,
+			code:    codes.NotFound,
+			message: service.ErrUserNotFound.Error(),
+		},
+		{
+			name:    "Invalid request",
+			err:     validation.Errors{"username": errors.New("cannot be blank")},
+			code:    codes.InvalidArgument,
+			message: "username: cannot be blank.",
+		},
+		{
+			name:    "Internal errors are hidden",
+			err:     errors.New("Failed to get user from database: dial tcp 10.0.0.1:3306: connection refused"),
+			code:    codes.Internal,
+			message: "Internal error",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			st, ok := status.FromError(encodeError(tc.err))
+			if assert.True(t, ok, "Expected a grpc status") {
+				assert.Equal(t, tc.code, st.Code())
+				assert.Equal(t, tc.message, st.Message())
+			}
+		})
+	}
+}
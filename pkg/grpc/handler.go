@@ -9,52 +9,141 @@ import (
 )
 
 type grpcServer struct {
-	createUser         grpctransport.Handler
-	confirmUser        grpctransport.Handler
-	resendConfirmation grpctransport.Handler
-	usernameTaken      grpctransport.Handler
-	login              grpctransport.Handler
-	verifyJWT          grpctransport.Handler
-	userDetails        grpctransport.Handler
+	createUser            grpctransport.Handler
+	confirmUser           grpctransport.Handler
+	resendConfirmation    grpctransport.Handler
+	usernameTaken         grpctransport.Handler
+	login                 grpctransport.Handler
+	refreshSession        grpctransport.Handler
+	forgotPassword        grpctransport.Handler
+	confirmForgotPassword grpctransport.Handler
+	changePassword        grpctransport.Handler
+	logout                grpctransport.Handler
+	globalSignOut         grpctransport.Handler
+	verifyJWT             grpctransport.Handler
+	userDetails           grpctransport.Handler
+	getPasswordPolicy     grpctransport.Handler
+	getProfile            grpctransport.Handler
+	updateProfile         grpctransport.Handler
+	updateContactDetails  grpctransport.Handler
+	verifyAttribute       grpctransport.Handler
 }
 
 // NewGRPCServer creates new user service
 func NewGRPCServer(ctx context.Context, e endpoint.Endpoints) pb.UserServer {
+	options := []grpctransport.ServerOption{
+		grpctransport.ServerBefore(requestIDFromMetadata),
+		grpctransport.ServerAfter(requestIDToMetadata),
+	}
+
 	return &grpcServer{
 		createUser: grpctransport.NewServer(
 			e.CreateUserEndpoint,
 			endpoint.DecodeCreateUserRequest,
 			endpoint.EncodeConfirmResponse,
+			options...,
 		),
 		confirmUser: grpctransport.NewServer(
 			e.ConfirmUserEndpoint,
 			endpoint.DecodeConfirmUserRequest,
 			endpoint.EncodeConfirmResponse,
+			options...,
 		),
 		resendConfirmation: grpctransport.NewServer(
 			e.ResendConfirmationEndpoint,
 			endpoint.DecodeResendConfirmationRequest,
 			endpoint.EncodeConfirmResponse,
+			options...,
 		),
 		usernameTaken: grpctransport.NewServer(
 			e.UsernameTakenEndpoint,
 			endpoint.DecodeUsernameTakenRequest,
 			endpoint.EncodeConfirmResponse,
+			options...,
 		),
 		login: grpctransport.NewServer(
 			e.LoginEndpoint,
 			endpoint.DecodeLoginRequest,
 			endpoint.EncodeLoginResponse,
+			options...,
+		),
+		refreshSession: grpctransport.NewServer(
+			e.RefreshSessionEndpoint,
+			endpoint.DecodeRefreshSessionRequest,
+			endpoint.EncodeLoginResponse,
+			options...,
+		),
+		forgotPassword: grpctransport.NewServer(
+			e.ForgotPasswordEndpoint,
+			endpoint.DecodeForgotPasswordRequest,
+			endpoint.EncodeConfirmResponse,
+			options...,
+		),
+		confirmForgotPassword: grpctransport.NewServer(
+			e.ConfirmForgotPasswordEndpoint,
+			endpoint.DecodeConfirmForgotPasswordRequest,
+			endpoint.EncodeConfirmResponse,
+			options...,
+		),
+		changePassword: grpctransport.NewServer(
+			e.ChangePasswordEndpoint,
+			endpoint.DecodeChangePasswordRequest,
+			endpoint.EncodeChangePasswordResponse,
+			options...,
+		),
+		logout: grpctransport.NewServer(
+			e.LogoutEndpoint,
+			endpoint.DecodeLogoutRequest,
+			endpoint.EncodeConfirmResponse,
+			options...,
+		),
+		globalSignOut: grpctransport.NewServer(
+			e.GlobalSignOutEndpoint,
+			endpoint.DecodeGlobalSignOutRequest,
+			endpoint.EncodeConfirmResponse,
+			options...,
 		),
 		verifyJWT: grpctransport.NewServer(
 			e.VerifyJWTEndpoint,
 			endpoint.DecodeVerifyJWTRequest,
-			endpoint.EncodeConfirmResponse,
+			endpoint.EncodeVerifyJWTResponse,
+			options...,
 		),
 		userDetails: grpctransport.NewServer(
 			e.UserDetailsEndpoint,
 			endpoint.DecodeUserDetailsRequest,
 			endpoint.EncodeUserDetailsResponse,
+			options...,
+		),
+		getPasswordPolicy: grpctransport.NewServer(
+			e.GetPasswordPolicyEndpoint,
+			endpoint.DecodeGetPasswordPolicyRequest,
+			endpoint.EncodePasswordPolicyResponse,
+			options...,
+		),
+		getProfile: grpctransport.NewServer(
+			e.GetProfileEndpoint,
+			endpoint.DecodeGetProfileRequest,
+			endpoint.EncodeProfileResponse,
+			options...,
+		),
+		updateProfile: grpctransport.NewServer(
+			e.UpdateProfileEndpoint,
+			endpoint.DecodeUpdateProfileRequest,
+			endpoint.EncodeProfileResponse,
+			options...,
+		),
+		updateContactDetails: grpctransport.NewServer(
+			e.UpdateContactDetailsEndpoint,
+			endpoint.DecodeUpdateContactDetailsRequest,
+			endpoint.EncodeUpdateContactDetailsResponse,
+			options...,
+		),
+		verifyAttribute: grpctransport.NewServer(
+			e.VerifyAttributeEndpoint,
+			endpoint.DecodeVerifyAttributeRequest,
+			endpoint.EncodeConfirmResponse,
+			options...,
 		),
 	}
 }
@@ -62,7 +151,7 @@ func NewGRPCServer(ctx context.Context, e endpoint.Endpoints) pb.UserServer {
 func (s *grpcServer) CreateUser(ctx context.Context, r *pb.CreateUserRequest) (*pb.ConfirmResponse, error) {
 	_, resp, err := s.createUser.ServeGRPC(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 
 	return resp.(*pb.ConfirmResponse), nil
@@ -71,7 +160,7 @@ func (s *grpcServer) CreateUser(ctx context.Context, r *pb.CreateUserRequest) (*
 func (s *grpcServer) ConfirmUser(ctx context.Context, r *pb.ConfirmUserRequest) (*pb.ConfirmResponse, error) {
 	_, resp, err := s.confirmUser.ServeGRPC(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 
 	return resp.(*pb.ConfirmResponse), nil
@@ -80,7 +169,7 @@ func (s *grpcServer) ConfirmUser(ctx context.Context, r *pb.ConfirmUserRequest)
 func (s *grpcServer) ResendConfirmation(ctx context.Context, r *pb.ResendConfirmationRequest) (*pb.ConfirmResponse, error) {
 	_, resp, err := s.resendConfirmation.ServeGRPC(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 
 	return resp.(*pb.ConfirmResponse), nil
@@ -89,7 +178,7 @@ func (s *grpcServer) ResendConfirmation(ctx context.Context, r *pb.ResendConfirm
 func (s grpcServer) UsernameTaken(ctx context.Context, r *pb.UsernameTakenRequest) (*pb.ConfirmResponse, error) {
 	_, resp, err := s.usernameTaken.ServeGRPC(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 
 	return resp.(*pb.ConfirmResponse), nil
@@ -98,26 +187,128 @@ func (s grpcServer) UsernameTaken(ctx context.Context, r *pb.UsernameTakenReques
 func (s *grpcServer) Login(ctx context.Context, r *pb.LoginRequest) (*pb.LoginResponse, error) {
 	_, resp, err := s.login.ServeGRPC(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 
 	return resp.(*pb.LoginResponse), nil
 }
 
-func (s grpcServer) VerifyJWT(ctx context.Context, r *pb.VerifyJWTRequest) (*pb.ConfirmResponse, error) {
-	_, resp, err := s.verifyJWT.ServeGRPC(ctx, r)
+func (s *grpcServer) RefreshSession(ctx context.Context, r *pb.RefreshSessionRequest) (*pb.LoginResponse, error) {
+	_, resp, err := s.refreshSession.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.LoginResponse), nil
+}
+
+func (s *grpcServer) ForgotPassword(ctx context.Context, r *pb.ForgotPasswordRequest) (*pb.ConfirmResponse, error) {
+	_, resp, err := s.forgotPassword.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.ConfirmResponse), nil
+}
+
+func (s *grpcServer) ConfirmForgotPassword(ctx context.Context, r *pb.ConfirmForgotPasswordRequest) (*pb.ConfirmResponse, error) {
+	_, resp, err := s.confirmForgotPassword.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.ConfirmResponse), nil
+}
+
+func (s *grpcServer) ChangePassword(ctx context.Context, r *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	_, resp, err := s.changePassword.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.ChangePasswordResponse), nil
+}
+
+func (s *grpcServer) Logout(ctx context.Context, r *pb.LogoutRequest) (*pb.ConfirmResponse, error) {
+	_, resp, err := s.logout.ServeGRPC(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 
 	return resp.(*pb.ConfirmResponse), nil
 }
 
+func (s *grpcServer) GlobalSignOut(ctx context.Context, r *pb.GlobalSignOutRequest) (*pb.ConfirmResponse, error) {
+	_, resp, err := s.globalSignOut.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.ConfirmResponse), nil
+}
+
+func (s grpcServer) VerifyJWT(ctx context.Context, r *pb.VerifyJWTRequest) (*pb.VerifyJWTResponse, error) {
+	_, resp, err := s.verifyJWT.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.VerifyJWTResponse), nil
+}
+
 func (s grpcServer) UserDetails(ctx context.Context, r *pb.UserDetailsRequest) (*pb.UserDetailsResponse, error) {
 	_, resp, err := s.userDetails.ServeGRPC(ctx, r)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 
 	return resp.(*pb.UserDetailsResponse), nil
 }
+
+func (s grpcServer) GetPasswordPolicy(ctx context.Context, r *pb.GetPasswordPolicyRequest) (*pb.PasswordPolicyResponse, error) {
+	_, resp, err := s.getPasswordPolicy.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.PasswordPolicyResponse), nil
+}
+
+func (s grpcServer) GetProfile(ctx context.Context, r *pb.GetProfileRequest) (*pb.ProfileResponse, error) {
+	_, resp, err := s.getProfile.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.ProfileResponse), nil
+}
+
+func (s grpcServer) UpdateProfile(ctx context.Context, r *pb.UpdateProfileRequest) (*pb.ProfileResponse, error) {
+	_, resp, err := s.updateProfile.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.ProfileResponse), nil
+}
+
+func (s grpcServer) UpdateContactDetails(
+	ctx context.Context,
+	r *pb.UpdateContactDetailsRequest,
+) (*pb.UpdateContactDetailsResponse, error) {
+	_, resp, err := s.updateContactDetails.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.UpdateContactDetailsResponse), nil
+}
+
+func (s grpcServer) VerifyAttribute(ctx context.Context, r *pb.VerifyAttributeRequest) (*pb.ConfirmResponse, error) {
+	_, resp, err := s.verifyAttribute.ServeGRPC(ctx, r)
+	if err != nil {
+		return nil, encodeError(err)
+	}
+
+	return resp.(*pb.ConfirmResponse), nil
+}
@@ -0,0 +1,40 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/PedPet/user/pkg/logging"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDFromMetadata uses the caller's request id, or generates one, so every log line of the
+// request can be tied together
+func requestIDFromMetadata(ctx context.Context, md metadata.MD) context.Context {
+	id := logging.NewRequestID()
+	if ids := md.Get(logging.RequestIDHeader); len(ids) > 0 && ids[0] != "" {
+		id = ids[0]
+	}
+
+	return logging.NewRequestIDContext(ctx, id)
+}
+
+// requestIDToMetadata returns the request id to the caller in the response header
+func requestIDToMetadata(ctx context.Context, header *metadata.MD, _ *metadata.MD) context.Context {
+	if id := logging.RequestID(ctx); id != "" {
+		if *header == nil {
+			*header = metadata.MD{}
+		}
+		header.Set(logging.RequestIDHeader, id)
+	}
+
+	return ctx
+}
+
+// requestIDToOutgoingMetadata passes the request id in the context on to the service
+func requestIDToOutgoingMetadata(ctx context.Context, md *metadata.MD) context.Context {
+	if id := logging.RequestID(ctx); id != "" {
+		md.Set(logging.RequestIDHeader, id)
+	}
+
+	return ctx
+}
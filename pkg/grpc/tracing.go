@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/PedPet/user/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// traceContext carries the W3C traceparent and tracestate headers between client and server
+var traceContext = propagation.TraceContext{}
+
+// metadataCarrier lets the trace context be read from and written to grpc metadata
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// TracingInterceptor wraps every call in a server span named after the grpc method, continuing
+// the trace in the caller's metadata if there is one
+func TracingInterceptor(tracer trace.Tracer) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = traceContext.Extract(ctx, metadataCarrier(md))
+
+		ctx, span := tracer.Start(ctx, info.FullMethod,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "grpc"),
+				attribute.String("rpc.method", info.FullMethod),
+			),
+		)
+		defer func() {
+			span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(status.Code(err))))
+			tracing.End(span, err)
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// traceContextToOutgoingMetadata passes the trace in the context on to the service
+func traceContextToOutgoingMetadata(ctx context.Context, md *metadata.MD) context.Context {
+	traceContext.Inject(ctx, metadataCarrier(*md))
+	return ctx
+}
@@ -0,0 +1,136 @@
+// Package health reports whether the service and each of its dependencies are healthy over the
+// standard grpc.health.v1 service, so orchestrators know when to send it traffic.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+)
+
+// Check reports whether a dependency is healthy
+type Check func(ctx context.Context) error
+
+// Checker runs the checks of the service's dependencies periodically. Each dependency is reported
+// under its own name, and the service as a whole, under "" and each of the services, is only serving
+// while every check passes.
+type Checker struct {
+	server   *health.Server
+	services []string
+	interval time.Duration
+	timeout  time.Duration
+	logger   log.Logger
+
+	mu     sync.Mutex
+	names  []string
+	checks map[string]Check
+}
+
+// NewChecker creates a Checker reporting to server, services are the grpc services whose status
+// follows the checks. Nothing is serving until the checks first pass.
+func NewChecker(server *health.Server, services []string, interval, timeout time.Duration, logger log.Logger) *Checker {
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	c := &Checker{
+		server:   server,
+		services: append([]string{""}, services...),
+		interval: interval,
+		timeout:  timeout,
+		logger:   log.With(logger, "component", "health"),
+		checks:   map[string]Check{},
+	}
+
+	for _, service := range c.services {
+		server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return c
+}
+
+// Add checks the dependency name on every run
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+	c.server.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Run checks the dependencies straight away and then every interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll runs every check once and updates the statuses, it returns whether they all passed
+func (c *Checker) CheckAll(ctx context.Context) bool {
+	c.mu.Lock()
+	names := append([]string{}, c.names...)
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	healthy := true
+	for _, name := range names {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err := c.check(ctx, checks[name]); err != nil {
+			level.Warn(c.logger).Log("dependency", name, "err", err)
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			healthy = false
+		}
+		c.server.SetServingStatus(name, status)
+	}
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if !healthy {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+
+	return healthy
+}
+
+func (c *Checker) check(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	return check(ctx)
+}
+
+// Shutdown reports everything as not serving, for good, so traffic is drained before the service
+// stops
+func (c *Checker) Shutdown() {
+	c.server.Shutdown()
+}
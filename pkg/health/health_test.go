@@ -0,0 +1,52 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func status(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Failed to check %q: %s", service, err)
+	}
+
+	return resp.Status
+}
+
+func TestChecker(t *testing.T) {
+	server := health.NewServer()
+	checker := NewChecker(server, []string{"User"}, 0, 0, log.NewNopLogger())
+
+	var dbErr error
+	checker.Add("mysql", func(ctx context.Context) error { return dbErr })
+	checker.Add("jwks", func(ctx context.Context) error { return nil })
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, ""), "Serving before the first check")
+
+	assert.True(t, checker.CheckAll(context.Background()))
+	for _, service := range []string{"", "User", "mysql", "jwks"} {
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(t, server, service), service)
+	}
+
+	dbErr = errors.New("connection refused")
+	assert.False(t, checker.CheckAll(context.Background()))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, "mysql"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(t, server, "jwks"))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, "User"))
+
+	dbErr = nil
+	checker.CheckAll(context.Background())
+	checker.Shutdown()
+	checker.CheckAll(context.Background())
+	for _, service := range []string{"", "User", "mysql", "jwks"} {
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, service), service)
+	}
+}
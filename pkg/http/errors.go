@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/logging"
+	"github.com/PedPet/user/pkg/service"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// errorStatuses is the http status each of the service's errors is sent with, matching the grpc
+// transport's codes
+var errorStatuses = map[error]int{
+	service.ErrUserNotFound:     http.StatusNotFound,
+	service.ErrUsernameExists:   http.StatusConflict,
+	service.ErrInvalidCode:      http.StatusBadRequest,
+	service.ErrCodeExpired:      http.StatusPreconditionFailed,
+	service.ErrUserNotConfirmed: http.StatusPreconditionFailed,
+	service.ErrWrongPassword:    http.StatusUnauthorized,
+	service.ErrNotAuthorized:    http.StatusUnauthorized,
+	service.ErrTooManyAttempts:  http.StatusTooManyRequests,
+	service.ErrInvalidPassword:  http.StatusBadRequest,
+	service.ErrPhoneUnsupported: http.StatusPreconditionFailed,
+}
+
+// errorResponse is the body of every failed request. Code names the kind of error, the same names
+// as the service's metrics, and Fields describes each invalid field of an invalid request.
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// malformedRequest is returned when the request body isn't the JSON expected
+type malformedRequest struct {
+	err error
+}
+
+func (e malformedRequest) Error() string {
+	return "Malformed request body: " + e.err.Error()
+}
+
+// encodeError writes the error as JSON with the status matching it. Errors the service doesn't know
+// are internal and their message isn't sent, it may hold details of our dependencies.
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	var status int
+	var body errorBody
+
+	switch e := err.(type) {
+	case validation.Errors:
+		status = http.StatusBadRequest
+		body = errorBody{Code: "invalid_request", Message: e.Error(), Fields: map[string]string{}}
+		for field, fieldErr := range e {
+			body.Fields[field] = fieldErr.Error()
+		}
+
+	case malformedRequest:
+		status = http.StatusBadRequest
+		body = errorBody{Code: "malformed_request", Message: e.Error()}
+
+	case *auth.TokenError:
+		status = http.StatusUnauthorized
+		body = errorBody{Code: service.ErrorType(err), Message: e.Error()}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+
+	default:
+		status = http.StatusInternalServerError
+		body = errorBody{Code: service.ErrorType(err), Message: "Internal error"}
+		if code, ok := errorStatuses[err]; ok {
+			status = code
+			body.Message = err.Error()
+		}
+	}
+
+	if id := logging.RequestID(ctx); id != "" {
+		w.Header().Set(logging.RequestIDHeader, id)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: body})
+}
@@ -0,0 +1,294 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/endpoint"
+	kitendpoint "github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// route is a REST route onto one of the endpoints. request and response are the endpoint's types,
+// the OpenAPI document describes the route from them.
+type route struct {
+	method   string
+	path     string
+	summary  string
+	bearer   bool
+	endpoint kitendpoint.Endpoint
+	decode   kithttp.DecodeRequestFunc
+	request  interface{}
+	response interface{}
+}
+
+func routes(e endpoint.Endpoints) []route {
+	return []route{
+		{
+			method: http.MethodPost, path: "/users", summary: "Sign up a user, a verification code is sent to their email",
+			endpoint: e.CreateUserEndpoint, decode: decodeCreateUserRequest,
+			request: endpoint.CreateUserRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodPost, path: "/users/{username}/confirm", summary: "Confirm a user with their verification code",
+			endpoint: e.ConfirmUserEndpoint, decode: decodeConfirmUserRequest,
+			request: endpoint.ConfirmUserRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodPost, path: "/users/{username}/confirm/resend", summary: "Resend a user's verification code",
+			endpoint: e.ResendConfirmationEndpoint, decode: decodeResendConfirmationRequest,
+			request: endpoint.ResendConfirmationRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodGet, path: "/users/{username}/taken", summary: "Check whether a username is taken, ok is true when it is",
+			endpoint: e.UsernameTakenEndpoint, decode: decodeUsernameTakenRequest,
+			request: endpoint.UsernameTakenRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodPost, path: "/users/{username}/password/forgot", summary: "Send a user a code to reset their password",
+			endpoint: e.ForgotPasswordEndpoint, decode: decodeForgotPasswordRequest,
+			request: endpoint.ForgotPasswordRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodPost, path: "/users/{username}/password/reset", summary: "Reset a user's password with the code they were sent",
+			endpoint: e.ConfirmForgotPasswordEndpoint, decode: decodeConfirmForgotPasswordRequest,
+			request: endpoint.ConfirmForgotPasswordRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodPost, path: "/sessions", summary: "Log in",
+			endpoint: e.LoginEndpoint, decode: decodeLoginRequest,
+			request: endpoint.LoginRequest{}, response: endpoint.LoginResponse{},
+		},
+		{
+			method: http.MethodPost, path: "/sessions/refresh", summary: "Get new tokens with a refresh token",
+			endpoint: e.RefreshSessionEndpoint, decode: decodeRefreshSessionRequest,
+			request: endpoint.RefreshSessionRequest{}, response: endpoint.LoginResponse{},
+		},
+		{
+			method: http.MethodDelete, path: "/sessions", summary: "Log out, revoking the refresh token",
+			endpoint: e.LogoutEndpoint, decode: decodeLogoutRequest,
+			request: endpoint.LogoutRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodGet, path: "/me", summary: "Get the details of the user the token belongs to", bearer: true,
+			endpoint: e.UserDetailsEndpoint, decode: decodeUserDetailsRequest,
+			request: endpoint.UserDetailsRequest{}, response: endpoint.UserDetailsResponse{},
+		},
+		{
+			method: http.MethodGet, path: "/me/token", summary: "Verify the token and get its claims", bearer: true,
+			endpoint: e.VerifyJWTEndpoint, decode: decodeVerifyJWTRequest,
+			request: endpoint.VerifyJWTRequest{}, response: endpoint.VerifyJWTResponse{},
+		},
+		{
+			method: http.MethodPut, path: "/me/password", summary: "Change the password, optionally signing out other sessions", bearer: true,
+			endpoint: e.ChangePasswordEndpoint, decode: decodeChangePasswordRequest,
+			request: endpoint.ChangePasswordRequest{}, response: endpoint.ChangePasswordResponse{},
+		},
+		{
+			method: http.MethodDelete, path: "/me/sessions", summary: "Sign out of every session", bearer: true,
+			endpoint: e.GlobalSignOutEndpoint, decode: decodeGlobalSignOutRequest,
+			request: endpoint.GlobalSignOutRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodGet, path: "/me/profile", summary: "Get the profile of the user the token belongs to", bearer: true,
+			endpoint: e.GetProfileEndpoint, decode: decodeGetProfileRequest,
+			request: endpoint.GetProfileRequest{}, response: endpoint.ProfileResponse{},
+		},
+		{
+			method: http.MethodPut, path: "/me/profile", summary: "Replace the profile, fields left out are cleared", bearer: true,
+			endpoint: e.UpdateProfileEndpoint, decode: decodeUpdateProfileRequest,
+			request: endpoint.UpdateProfileRequest{}, response: endpoint.ProfileResponse{},
+		},
+		{
+			method: http.MethodPut, path: "/me/contact-details", summary: "Change the email or phone number, a code is sent to verify the new value", bearer: true,
+			endpoint: e.UpdateContactDetailsEndpoint, decode: decodeUpdateContactDetailsRequest,
+			request: endpoint.UpdateContactDetailsRequest{}, response: endpoint.UpdateContactDetailsResponse{},
+		},
+		{
+			method: http.MethodPost, path: "/me/attributes/{attribute}/verify", summary: "Verify the email or phone number with the code sent to it", bearer: true,
+			endpoint: e.VerifyAttributeEndpoint, decode: decodeVerifyAttributeRequest,
+			request: endpoint.VerifyAttributeRequest{}, response: endpoint.ConfirmResponse{},
+		},
+		{
+			method: http.MethodGet, path: "/password-policy", summary: "Get the rules passwords must follow",
+			endpoint: e.GetPasswordPolicyEndpoint, decode: decodeGetPasswordPolicyRequest,
+			request: endpoint.GetPasswordPolicyRequest{}, response: endpoint.PasswordPolicyResponse{},
+		},
+	}
+}
+
+// NewHTTPHandler exposes the endpoints as JSON REST routes, the OpenAPI document describing them is
+// served from /openapi.json
+func NewHTTPHandler(e endpoint.Endpoints, tracer trace.Tracer) http.Handler {
+	options := []kithttp.ServerOption{
+		kithttp.ServerBefore(requestIDFromHeader),
+		kithttp.ServerAfter(requestIDToHeader),
+		kithttp.ServerErrorEncoder(encodeError),
+	}
+
+	mux := http.NewServeMux()
+	rs := routes(e)
+	for _, r := range rs {
+		pattern := r.method + " " + r.path
+		handler := kithttp.NewServer(r.endpoint, r.decode, kithttp.EncodeJSONResponse, options...)
+		mux.Handle(pattern, traced(tracer, pattern, handler))
+	}
+
+	document := openAPI(rs)
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(document)
+	})
+
+	return mux
+}
+
+// decodeJSON decodes the request body into v, an empty body leaves v as it is
+func decodeJSON(r *http.Request, v interface{}) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		return malformedRequest{err}
+	}
+	return nil
+}
+
+// bearerToken is the token in the Authorization header, failing when there isn't one
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", auth.ErrTokenMissing
+	}
+
+	return strings.TrimSpace(header[len("Bearer "):]), nil
+}
+
+func decodeCreateUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.CreateUserRequest
+	err := decodeJSON(r, &req)
+	return req, err
+}
+
+func decodeConfirmUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.ConfirmUserRequest
+	err := decodeJSON(r, &req)
+	req.Username = r.PathValue("username")
+	return req, err
+}
+
+func decodeResendConfirmationRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.ResendConfirmationRequest{Username: r.PathValue("username")}, nil
+}
+
+func decodeUsernameTakenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.UsernameTakenRequest{Username: r.PathValue("username")}, nil
+}
+
+func decodeForgotPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return endpoint.ForgotPasswordRequest{Username: r.PathValue("username")}, nil
+}
+
+func decodeConfirmForgotPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.ConfirmForgotPasswordRequest
+	err := decodeJSON(r, &req)
+	req.Username = r.PathValue("username")
+	return req, err
+}
+
+func decodeLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.LoginRequest
+	err := decodeJSON(r, &req)
+	return req, err
+}
+
+func decodeRefreshSessionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.RefreshSessionRequest
+	err := decodeJSON(r, &req)
+	return req, err
+}
+
+func decodeLogoutRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req endpoint.LogoutRequest
+	err := decodeJSON(r, &req)
+	return req, err
+}
+
+func decodeUserDetailsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	return &endpoint.UserDetailsRequest{Jwt: token}, err
+}
+
+func decodeVerifyJWTRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	return endpoint.VerifyJWTRequest{Jwt: token}, err
+}
+
+func decodeChangePasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req endpoint.ChangePasswordRequest
+	err = decodeJSON(r, &req)
+	req.Jwt = token
+	return req, err
+}
+
+func decodeGlobalSignOutRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	return endpoint.GlobalSignOutRequest{Jwt: token}, err
+}
+
+func decodeGetProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	return endpoint.GetProfileRequest{Jwt: token}, err
+}
+
+func decodeUpdateProfileRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req endpoint.UpdateProfileRequest
+	err = decodeJSON(r, &req)
+	req.Jwt = token
+	return req, err
+}
+
+func decodeUpdateContactDetailsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req endpoint.UpdateContactDetailsRequest
+	err = decodeJSON(r, &req)
+	req.Jwt = token
+	return req, err
+}
+
+func decodeVerifyAttributeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req endpoint.VerifyAttributeRequest
+	err = decodeJSON(r, &req)
+	req.Jwt = token
+	req.Attribute = r.PathValue("attribute")
+	return req, err
+}
+
+func decodeGetPasswordPolicyRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return endpoint.GetPasswordPolicyRequest{}, nil
+}
@@ -0,0 +1,208 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/endpoint"
+	"github.com/PedPet/user/pkg/logging"
+	"github.com/PedPet/user/pkg/service"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// serviceStub is a service answering the calls the tests make
+type serviceStub struct {
+	service.User
+}
+
+func (s serviceStub) ConfirmUser(ctx context.Context, username, otp string) error {
+	if username != "SC7639" {
+		return service.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s serviceStub) Login(ctx context.Context, username, password string) (*model.Session, error) {
+	if password != "Swarleyfin1!" {
+		return nil, service.ErrWrongPassword
+	}
+	return &model.Session{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600, TokenType: "Bearer"}, nil
+}
+
+func (s serviceStub) UserDetails(ctx context.Context, token string) (*model.User, error) {
+	if token != "access" {
+		return nil, auth.ErrTokenSignature
+	}
+	return &model.User{ID: 1, Username: "SC7639", Email: "scott@example.com", Confirmed: true, EmailVerified: true}, nil
+}
+
+func (s serviceStub) VerifyAttribute(ctx context.Context, token, attribute, code string) error {
+	if attribute != model.AttributePhoneNumber {
+		return service.ErrInvalidCode
+	}
+	return nil
+}
+
+func (s serviceStub) ResendConfirmation(ctx context.Context, username string) error {
+	return errors.New("dial tcp 10.0.0.1:3306: connection refused")
+}
+
+func newTestHandler() http.Handler {
+	e := endpoint.MakeEndpoints(serviceStub{}, config.DefaultValidation(), noop.NewTracerProvider().Tracer(""))
+	return NewHTTPHandler(e, noop.NewTracerProvider().Tracer(""))
+}
+
+func serve(handler http.Handler, method, path, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	handler := newTestHandler()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		status int
+		json   string
+	}{
+		{
+			name:   "Login",
+			method: http.MethodPost, path: "/sessions", body: `{"username":"SC7639","password":"Swarleyfin1!"}`,
+			status: http.StatusOK,
+			json:   `{"jwt":"access","idToken":"","refreshToken":"refresh","expiresIn":3600,"tokenType":"Bearer"}`,
+		},
+		{
+			name:   "Wrong password",
+			method: http.MethodPost, path: "/sessions", body: `{"username":"SC7639","password":"Swarleyfin2!"}`,
+			status: http.StatusUnauthorized,
+			json:   `{"error":{"code":"wrong_password","message":"Incorrect username or password"}}`,
+		},
+		{
+			name:   "Invalid request",
+			method: http.MethodPost, path: "/sessions", body: `{"username":"SC7639"}`,
+			status: http.StatusBadRequest,
+			json:   `{"error":{"code":"invalid_request","message":"password: cannot be blank.","fields":{"password":"cannot be blank"}}}`,
+		},
+		{
+			name:   "Malformed body",
+			method: http.MethodPost, path: "/sessions", body: `{"username":`,
+			status: http.StatusBadRequest,
+			json:   `{"error":{"code":"malformed_request","message":"Malformed request body: unexpected EOF"}}`,
+		},
+		{
+			name:   "Username from the path",
+			method: http.MethodPost, path: "/users/SC7639/confirm", body: `{"username":"someone","code":"123456"}`,
+			status: http.StatusOK,
+			json:   `{"ok":true}`,
+		},
+		{
+			name:   "Not found",
+			method: http.MethodPost, path: "/users/SC7640/confirm", body: `{"code":"123456"}`,
+			status: http.StatusNotFound,
+			json:   `{"error":{"code":"user_not_found","message":"User does not exist"}}`,
+		},
+		{
+			name:   "Details",
+			method: http.MethodGet, path: "/me", token: "access",
+			status: http.StatusOK,
+			json:   `{"id":1,"username":"SC7639","email":"scott@example.com","phoneNumber":"","confirmed":true,"emailVerified":true,"phoneNumberVerified":false}`,
+		},
+		{
+			name:   "Attribute from the path",
+			method: http.MethodPost, path: "/me/attributes/phone_number/verify", body: `{"attribute":"email","code":"123456"}`,
+			token:  "access",
+			status: http.StatusOK,
+			json:   `{"ok":true}`,
+		},
+		{
+			name:   "Unknown attribute",
+			method: http.MethodPost, path: "/me/attributes/address/verify", body: `{"code":"123456"}`, token: "access",
+			status: http.StatusBadRequest,
+			json:   `{"error":{"code":"invalid_request","message":"attribute: must be a valid value.","fields":{"attribute":"must be a valid value"}}}`,
+		},
+		{
+			name:   "Missing token",
+			method: http.MethodGet, path: "/me",
+			status: http.StatusUnauthorized,
+			json:   `{"error":{"code":"invalid_token","message":"Token is missing"}}`,
+		},
+		{
+			name:   "Invalid token",
+			method: http.MethodGet, path: "/me", token: "forged",
+			status: http.StatusUnauthorized,
+			json:   `{"error":{"code":"invalid_token","message":"Token signature is invalid"}}`,
+		},
+		{
+			name:   "Internal errors are hidden",
+			method: http.MethodPost, path: "/users/SC7639/confirm/resend",
+			status: http.StatusInternalServerError,
+			json:   `{"error":{"code":"internal","message":"Internal error"}}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := serve(handler, c.method, c.path, c.body, c.token)
+			assert.Equal(t, c.status, w.Code)
+			assert.JSONEq(t, c.json, w.Body.String())
+			assert.NotEmpty(t, w.Header().Get(logging.RequestIDHeader), "Expected a request id")
+		})
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	w := serve(newTestHandler(), http.MethodGet, "/openapi.json", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var document struct {
+		Paths map[string]map[string]struct {
+			OperationID string                   `json:"operationId"`
+			Parameters  []map[string]interface{} `json:"parameters"`
+			Security    []map[string][]string    `json:"security"`
+			RequestBody struct {
+				Content map[string]struct {
+					Schema struct {
+						Properties map[string]interface{} `json:"properties"`
+					} `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+
+	operations := 0
+	for _, methods := range document.Paths {
+		operations += len(methods)
+	}
+	assert.Equal(t, len(routes(endpoint.Endpoints{})), operations)
+
+	confirm := document.Paths["/users/{username}/confirm"]["post"]
+	assert.Equal(t, "ConfirmUser", confirm.OperationID)
+	assert.Len(t, confirm.Parameters, 1)
+	assert.Contains(t, confirm.RequestBody.Content["application/json"].Schema.Properties, "code")
+	assert.NotContains(t, confirm.RequestBody.Content["application/json"].Schema.Properties, "username")
+
+	me := document.Paths["/me"]["get"]
+	assert.Equal(t, "UserDetails", me.OperationID)
+	assert.NotEmpty(t, me.Security)
+	assert.Empty(t, me.RequestBody.Content, "The token is sent in the header")
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// pathParam matches the parameters in a route's path, like {username}
+var pathParam = regexp.MustCompile(`{(\w+)}`)
+
+// openAPI generates the OpenAPI 3 document describing the routes from their request and response
+// types. Fields taken from the path or the bearer token are left out of the request bodies.
+func openAPI(rs []route) []byte {
+	schemas := map[string]interface{}{
+		"Error": schemaOf(reflect.TypeOf(errorResponse{}), nil),
+	}
+	paths := map[string]map[string]interface{}{}
+
+	for _, r := range rs {
+		skip := map[string]bool{}
+		parameters := []interface{}{}
+		for _, match := range pathParam.FindAllStringSubmatch(r.path, -1) {
+			skip[match[1]] = true
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		if r.bearer {
+			skip["jwt"] = true
+		}
+
+		requestType := reflect.TypeOf(r.request)
+		responseType := reflect.TypeOf(r.response)
+		schemas[responseType.Name()] = schemaOf(responseType, nil)
+
+		operation := map[string]interface{}{
+			"operationId": strings.TrimSuffix(requestType.Name(), "Request"),
+			"summary":     r.summary,
+			"responses": map[string]interface{}{
+				"200":     jsonContent("Success", ref(responseType.Name())),
+				"default": jsonContent("The request failed, the code names the kind of error", ref("Error")),
+			},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if body := schemaOf(requestType, skip); len(body["properties"].(map[string]interface{})) > 0 {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": body}},
+			}
+		}
+		if r.bearer {
+			operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+		}
+
+		if paths[r.path] == nil {
+			paths[r.path] = map[string]interface{}{}
+		}
+		paths[r.path][strings.ToLower(r.method)] = operation
+	}
+
+	document, err := json.MarshalIndent(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "PedPet user service",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		panic("http: failed to encode openapi document: " + err.Error())
+	}
+
+	return document
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+	}
+}
+
+// schemaOf describes the JSON encoding of t, struct fields are named by their json tags and those
+// named in skip are left out
+func schemaOf(t reflect.Type, skip map[string]bool) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), nil)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), nil)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), nil)}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			if name == "-" || field.PkgPath != "" || skip[name] {
+				continue
+			}
+			properties[name] = schemaOf(field.Type, nil)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+
+	return map[string]interface{}{}
+}
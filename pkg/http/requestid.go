@@ -0,0 +1,28 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/PedPet/user/pkg/logging"
+)
+
+// requestIDFromHeader uses the caller's request id, or generates one, so every log line of the
+// request can be tied together
+func requestIDFromHeader(ctx context.Context, r *http.Request) context.Context {
+	id := r.Header.Get(logging.RequestIDHeader)
+	if id == "" {
+		id = logging.NewRequestID()
+	}
+
+	return logging.NewRequestIDContext(ctx, id)
+}
+
+// requestIDToHeader returns the request id to the caller
+func requestIDToHeader(ctx context.Context, w http.ResponseWriter) context.Context {
+	if id := logging.RequestID(ctx); id != "" {
+		w.Header().Set(logging.RequestIDHeader, id)
+	}
+
+	return ctx
+}
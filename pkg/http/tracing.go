@@ -0,0 +1,53 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/PedPet/user/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// statusRecorder remembers the status written so it can be put on the span
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// traced wraps every request in a server span named after the route, continuing the W3C trace in
+// the caller's headers if there is one
+func traced(tracer trace.Tracer, name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", name),
+			),
+		)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		var err error
+		if recorder.status >= http.StatusInternalServerError {
+			err = errorStatus(recorder.status)
+		}
+		tracing.End(span, err)
+	})
+}
+
+// errorStatus marks a span failed with the status the request ended with
+type errorStatus int
+
+func (s errorStatus) Error() string {
+	return http.StatusText(int(s))
+}
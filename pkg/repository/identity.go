@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/PedPet/user/model"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// InsertIdentity is a sql statement to insert a local identity into the identities table
+	InsertIdentity string = `INSERT INTO identities
+		(sub, username, email, phone_number, password_hash, confirmed, email_verified, phone_number_verified,
+		code_hash, code_attribute, code_expires_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	// GetIdentity is a sql statement to get a local identity by username
+	GetIdentity string = `SELECT id, sub, username, email, phone_number, password_hash, confirmed,
+		email_verified, phone_number_verified, code_hash, code_attribute, code_expires_at, created_at
+		FROM identities WHERE username = ?`
+	// UpdateIdentity is a sql statement to update a local identity's mutable fields. The attempts at the code
+	// are only reset when the code changes, they're counted by RecordCodeAttempt alone. MySQL assigns left to
+	// right, so code_attempts is compared against the old code_hash.
+	UpdateIdentity string = `UPDATE identities SET email = ?, phone_number = ?, password_hash = ?,
+		confirmed = ?, email_verified = ?, phone_number_verified = ?,
+		code_attempts = IF(code_hash = ?, code_attempts, 0), code_hash = ?, code_attribute = ?,
+		code_expires_at = ? WHERE username = ?`
+	// RecordCodeAttempt is a sql statement to count an attempt at a code, unless it has had too many already
+	RecordCodeAttempt string = `UPDATE identities SET code_attempts = code_attempts + 1
+		WHERE username = ? AND code_hash = ? AND code_attempts < ?`
+	// InsertRefreshToken is a sql statement to store a refresh token's hash
+	InsertRefreshToken string = `INSERT INTO refresh_tokens (token_hash, username, expires_at) VALUES(?, ?, ?)`
+	// GetRefreshToken is a sql statement to get a refresh token by its hash
+	GetRefreshToken string = `SELECT id, token_hash, username, expires_at, revoked, created_at
+		FROM refresh_tokens WHERE token_hash = ?`
+	// RevokeRefreshToken is a sql statement to revoke a refresh token by its hash
+	RevokeRefreshToken string = "UPDATE refresh_tokens SET revoked = 1 WHERE token_hash = ?"
+	// RevokeRefreshTokens is a sql statement to revoke every refresh token issued to a user
+	RevokeRefreshTokens string = "UPDATE refresh_tokens SET revoked = 1 WHERE username = ?"
+	// ListIdentities is a sql statement to get a page of local identities' subs and usernames in username order
+	ListIdentities string = "SELECT sub, username FROM identities WHERE username > ? ORDER BY username LIMIT ?"
+	// DeleteIdentity is a sql statement to remove a local identity and the refresh tokens issued to it
+	DeleteIdentity string = `DELETE identities, refresh_tokens FROM identities
+		LEFT JOIN refresh_tokens ON refresh_tokens.username = identities.username
+		WHERE identities.username = ?`
+)
+
+var (
+	// ErrIdentityNotFound is returned when no identity matches the given username
+	ErrIdentityNotFound = errors.New("Identity not found")
+	// ErrRefreshTokenNotFound is returned when no refresh token matches the given hash
+	ErrRefreshTokenNotFound = errors.New("Refresh token not found")
+)
+
+// Identity interface to define the self-hosted identity provider's credential store
+type Identity interface {
+	CreateIdentity(ctx context.Context, identity *model.Identity) error
+	GetIdentity(ctx context.Context, username string) (*model.Identity, error)
+	UpdateIdentity(ctx context.Context, identity *model.Identity) error
+	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	RecordCodeAttempt(ctx context.Context, username, codeHash string, limit int) (bool, error)
+	RevokeRefreshToken(ctx context.Context, tokenHash string) error
+	RevokeRefreshTokens(ctx context.Context, username string) error
+	ListIdentities(ctx context.Context, after string, limit int) ([]model.Identity, error)
+	DeleteIdentity(ctx context.Context, username string) error
+}
+
+type identityRepo struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+// NewIdentityRepo creates a new identity repo instance
+func NewIdentityRepo(db *sql.DB, logger log.Logger) Identity {
+	return &identityRepo{
+		db:     db,
+		logger: log.With(logger, "repo", "identity"),
+	}
+}
+
+func (r identityRepo) CreateIdentity(ctx context.Context, identity *model.Identity) error {
+	logger := log.With(r.logger, "method", "CreateIdentity")
+
+	if identity.Username == "" {
+		return errRepo
+	}
+
+	stmt, err := r.db.PrepareContext(ctx, InsertIdentity)
+	if err != nil {
+		return errors.Wrap(err, "Failed to prepare insert identity statement")
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx,
+		identity.Sub,
+		identity.Username,
+		identity.Email,
+		identity.PhoneNumber,
+		identity.PasswordHash,
+		identity.Confirmed,
+		identity.EmailVerified,
+		identity.PhoneNumberVerified,
+		identity.CodeHash,
+		identity.CodeAttribute,
+		identity.CodeExpiresAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "Failed to execute prepared insert identity statement")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get last insert id")
+	}
+
+	identity.ID = int(id)
+	logger.Log("Create identity", identity.ID)
+
+	return nil
+}
+
+func (r identityRepo) GetIdentity(ctx context.Context, username string) (*model.Identity, error) {
+	identity := &model.Identity{}
+	err := r.db.QueryRowContext(ctx, GetIdentity, username).Scan(
+		&identity.ID,
+		&identity.Sub,
+		&identity.Username,
+		&identity.Email,
+		&identity.PhoneNumber,
+		&identity.PasswordHash,
+		&identity.Confirmed,
+		&identity.EmailVerified,
+		&identity.PhoneNumberVerified,
+		&identity.CodeHash,
+		&identity.CodeAttribute,
+		&identity.CodeExpiresAt,
+		&identity.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get identity from database")
+	}
+
+	return identity, nil
+}
+
+func (r identityRepo) UpdateIdentity(ctx context.Context, identity *model.Identity) error {
+	_, err := r.db.ExecContext(ctx, UpdateIdentity,
+		identity.Email,
+		identity.PhoneNumber,
+		identity.PasswordHash,
+		identity.Confirmed,
+		identity.EmailVerified,
+		identity.PhoneNumberVerified,
+		identity.CodeHash,
+		identity.CodeHash,
+		identity.CodeAttribute,
+		identity.CodeExpiresAt,
+		identity.Username,
+	)
+	if err != nil {
+		return errors.Wrap(err, "Failed to update identity")
+	}
+
+	return nil
+}
+
+// RecordCodeAttempt counts an attempt at the identity's code in one statement, so attempts made at the same
+// time are all counted. It's false once the code has had limit attempts, or has been replaced.
+func (r identityRepo) RecordCodeAttempt(ctx context.Context, username, codeHash string, limit int) (bool, error) {
+	result, err := r.db.ExecContext(ctx, RecordCodeAttempt, username, codeHash, limit)
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to record code attempt")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to get rows affected")
+	}
+
+	return affected == 1, nil
+}
+
+func (r identityRepo) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	result, err := r.db.ExecContext(ctx, InsertRefreshToken, token.TokenHash, token.Username, token.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "Failed to insert refresh token")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get last insert id")
+	}
+
+	token.ID = int(id)
+	return nil
+}
+
+func (r identityRepo) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	token := &model.RefreshToken{}
+	err := r.db.QueryRowContext(ctx, GetRefreshToken, tokenHash).Scan(
+		&token.ID,
+		&token.TokenHash,
+		&token.Username,
+		&token.ExpiresAt,
+		&token.Revoked,
+		&token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get refresh token from database")
+	}
+
+	return token, nil
+}
+
+func (r identityRepo) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := r.db.ExecContext(ctx, RevokeRefreshToken, tokenHash)
+	if err != nil {
+		return errors.Wrap(err, "Failed to revoke refresh token")
+	}
+
+	return nil
+}
+
+func (r identityRepo) RevokeRefreshTokens(ctx context.Context, username string) error {
+	_, err := r.db.ExecContext(ctx, RevokeRefreshTokens, username)
+	if err != nil {
+		return errors.Wrap(err, "Failed to revoke refresh tokens")
+	}
+
+	return nil
+}
+
+// ListIdentities returns the sub and username of at most limit identities ordered after the given username
+func (r identityRepo) ListIdentities(ctx context.Context, after string, limit int) ([]model.Identity, error) {
+	rows, err := r.db.QueryContext(ctx, ListIdentities, after, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list identities from database")
+	}
+	defer rows.Close()
+
+	identities := []model.Identity{}
+	for rows.Next() {
+		var identity model.Identity
+		err = rows.Scan(&identity.Sub, &identity.Username)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to read identity")
+		}
+		identities = append(identities, identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to read identities")
+	}
+
+	return identities, nil
+}
+
+func (r identityRepo) DeleteIdentity(ctx context.Context, username string) error {
+	logger := log.With(r.logger, "method", "DeleteIdentity")
+
+	_, err := r.db.ExecContext(ctx, DeleteIdentity, username)
+	if err != nil {
+		return errors.Wrap(err, "Failed to delete identity")
+	}
+
+	logger.Log("Delete identity", username)
+	return nil
+}
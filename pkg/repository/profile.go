@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/PedPet/user/model"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// GetProfile is a sql statement to get a user's profile
+	GetProfile string = `SELECT user_id, first_name, last_name, display_name, avatar_url, bio, location, locale,
+		timezone, date_of_birth, updated_at FROM profiles WHERE user_id = ?`
+	// UpsertProfile is a sql statement to store a user's profile, replacing the one they had
+	UpsertProfile string = `INSERT INTO profiles (user_id, first_name, last_name, display_name, avatar_url, bio,
+		location, locale, timezone, date_of_birth, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE first_name = VALUES(first_name), last_name = VALUES(last_name),
+		display_name = VALUES(display_name), avatar_url = VALUES(avatar_url), bio = VALUES(bio),
+		location = VALUES(location), locale = VALUES(locale), timezone = VALUES(timezone),
+		date_of_birth = VALUES(date_of_birth), updated_at = VALUES(updated_at)`
+)
+
+// ErrProfileNotFound is returned when the user hasn't stored a profile
+var ErrProfileNotFound = errors.New("Profile not found")
+
+// Profile interface to define the store of users' profiles, keyed by the id of their row in users
+type Profile interface {
+	GetProfile(ctx context.Context, userID int) (*model.Profile, error)
+	UpdateProfile(ctx context.Context, profile *model.Profile) error
+}
+
+type profileRepo struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+// NewProfileRepo creates a new profile repo instance
+func NewProfileRepo(db *sql.DB, logger log.Logger) Profile {
+	return &profileRepo{
+		db:     db,
+		logger: log.With(logger, "repo", "profile"),
+	}
+}
+
+func (r profileRepo) GetProfile(ctx context.Context, userID int) (*model.Profile, error) {
+	var p model.Profile
+	var dateOfBirth sql.NullTime
+	err := r.db.QueryRowContext(ctx, GetProfile, userID).Scan(
+		&p.UserID, &p.FirstName, &p.LastName, &p.DisplayName, &p.AvatarURL, &p.Bio, &p.Location, &p.Locale,
+		&p.Timezone, &dateOfBirth, &p.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrProfileNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get profile from database")
+	}
+	p.DateOfBirth = dateOfBirth.Time
+
+	return &p, nil
+}
+
+// UpdateProfile stores the profile in place of the one the user had, every field is replaced
+func (r profileRepo) UpdateProfile(ctx context.Context, p *model.Profile) error {
+	logger := log.With(r.logger, "method", "UpdateProfile")
+
+	dateOfBirth := sql.NullTime{Time: p.DateOfBirth, Valid: !p.DateOfBirth.IsZero()}
+	_, err := r.db.ExecContext(ctx, UpsertProfile,
+		p.UserID, p.FirstName, p.LastName, p.DisplayName, p.AvatarURL, p.Bio, p.Location, p.Locale,
+		p.Timezone, dateOfBirth, p.UpdatedAt.UTC(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "Failed to store profile")
+	}
+
+	logger.Log("Update profile", p.UserID)
+	return nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// InsertRevokedToken is a sql statement to record a refresh token's hash as logged out
+	InsertRevokedToken string = "INSERT IGNORE INTO revoked_tokens (token_hash, username) VALUES(?, ?)"
+	// GetRevokedToken is a sql statement to check whether a refresh token's hash has been logged out
+	GetRevokedToken string = "SELECT id FROM revoked_tokens WHERE token_hash = ?"
+	// UpsertSignOut is a sql statement to record the time a user last signed out of every session
+	UpsertSignOut string = `INSERT INTO sign_outs (username, signed_out_at) VALUES(?, ?)
+		ON DUPLICATE KEY UPDATE signed_out_at = VALUES(signed_out_at)`
+	// GetSignOut is a sql statement to get the time a user last signed out of every session
+	GetSignOut string = "SELECT signed_out_at FROM sign_outs WHERE username = ?"
+)
+
+// Session interface to define the store of revoked sessions, shared by every identity provider
+type Session interface {
+	RevokeToken(ctx context.Context, tokenHash, username string) error
+	TokenRevoked(ctx context.Context, tokenHash string) (bool, error)
+	SignOut(ctx context.Context, username string, at time.Time) error
+	SignedOutAt(ctx context.Context, username string) (time.Time, error)
+}
+
+type sessionRepo struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+// NewSessionRepo creates a new session repo instance
+func NewSessionRepo(db *sql.DB, logger log.Logger) Session {
+	return &sessionRepo{
+		db:     db,
+		logger: log.With(logger, "repo", "session"),
+	}
+}
+
+func (r sessionRepo) RevokeToken(ctx context.Context, tokenHash, username string) error {
+	logger := log.With(r.logger, "method", "RevokeToken")
+
+	_, err := r.db.ExecContext(ctx, InsertRevokedToken, tokenHash, username)
+	if err != nil {
+		return errors.Wrap(err, "Failed to revoke token")
+	}
+
+	logger.Log("Revoke token", username)
+	return nil
+}
+
+func (r sessionRepo) TokenRevoked(ctx context.Context, tokenHash string) (bool, error) {
+	var id int
+	err := r.db.QueryRowContext(ctx, GetRevokedToken, tokenHash).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to get revoked token from database")
+	}
+
+	return true, nil
+}
+
+func (r sessionRepo) SignOut(ctx context.Context, username string, at time.Time) error {
+	logger := log.With(r.logger, "method", "SignOut")
+
+	_, err := r.db.ExecContext(ctx, UpsertSignOut, username, at.UTC())
+	if err != nil {
+		return errors.Wrap(err, "Failed to record sign out")
+	}
+
+	logger.Log("Sign out", username)
+	return nil
+}
+
+// SignedOutAt returns the zero time when the user has never signed out of every session
+func (r sessionRepo) SignedOutAt(ctx context.Context, username string) (time.Time, error) {
+	var at time.Time
+	err := r.db.QueryRowContext(ctx, GetSignOut, username).Scan(&at)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Failed to get sign out from database")
+	}
+
+	return at, nil
+}
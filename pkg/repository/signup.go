@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/PedPet/user/model"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// InsertSignUp is a sql statement to record a sign up as pending, ignored when the username
+	// already has an unfinished sign up
+	InsertSignUp string = `INSERT IGNORE INTO sign_ups (username, state, created_at, updated_at)
+		VALUES(?, 'pending', ?, ?)`
+	// FailSignUp is a sql statement to record that a sign up couldn't be rolled back
+	FailSignUp string = `UPDATE sign_ups SET state = 'failed', attempts = attempts + 1, last_error = ?,
+		updated_at = ? WHERE username = ?`
+	// DeleteSignUp is a sql statement to remove a finished sign up
+	DeleteSignUp string = "DELETE FROM sign_ups WHERE username = ?"
+	// GetSignUp is a sql statement to get the unfinished sign up of a username
+	GetSignUp string = `SELECT id, username, state, attempts, last_error, created_at, updated_at
+		FROM sign_ups WHERE username = ?`
+	// GetStaleSignUps is a sql statement to get the sign ups that haven't changed since the given time
+	GetStaleSignUps string = `SELECT id, username, state, attempts, last_error, created_at, updated_at
+		FROM sign_ups WHERE updated_at < ? ORDER BY updated_at LIMIT ?`
+)
+
+var (
+	// ErrSignUpInProgress is returned when the username already has an unfinished sign up
+	ErrSignUpInProgress = errors.New("Sign up already in progress")
+	// ErrSignUpNotFound is returned when the username has no unfinished sign up
+	ErrSignUpNotFound = errors.New("Sign up not found")
+)
+
+// SignUp interface to define the store of unfinished sign ups, so a sign up interrupted between the
+// identity provider and the users table can be finished or rolled back
+type SignUp interface {
+	StartSignUp(ctx context.Context, username string, at time.Time) error
+	FailSignUp(ctx context.Context, username, cause string, at time.Time) error
+	FinishSignUp(ctx context.Context, username string) error
+	GetSignUp(ctx context.Context, username string) (*model.SignUp, error)
+	StaleSignUps(ctx context.Context, before time.Time, limit int) ([]model.SignUp, error)
+}
+
+type signUpRepo struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+// NewSignUpRepo creates a new sign up repo instance
+func NewSignUpRepo(db *sql.DB, logger log.Logger) SignUp {
+	return &signUpRepo{
+		db:     db,
+		logger: log.With(logger, "repo", "signup"),
+	}
+}
+
+func (r signUpRepo) StartSignUp(ctx context.Context, username string, at time.Time) error {
+	result, err := r.db.ExecContext(ctx, InsertSignUp, username, at.UTC(), at.UTC())
+	if err != nil {
+		return errors.Wrap(err, "Failed to record sign up")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "Failed to record sign up")
+	}
+	if rows == 0 {
+		return ErrSignUpInProgress
+	}
+
+	return nil
+}
+
+func (r signUpRepo) FailSignUp(ctx context.Context, username, cause string, at time.Time) error {
+	logger := log.With(r.logger, "method", "FailSignUp")
+
+	if len(cause) > 1000 {
+		cause = cause[:1000]
+	}
+	_, err := r.db.ExecContext(ctx, FailSignUp, cause, at.UTC(), username)
+	if err != nil {
+		return errors.Wrap(err, "Failed to record failed sign up")
+	}
+
+	logger.Log("Fail sign up", username)
+	return nil
+}
+
+func (r signUpRepo) FinishSignUp(ctx context.Context, username string) error {
+	_, err := r.db.ExecContext(ctx, DeleteSignUp, username)
+	if err != nil {
+		return errors.Wrap(err, "Failed to remove sign up")
+	}
+
+	return nil
+}
+
+func (r signUpRepo) GetSignUp(ctx context.Context, username string) (*model.SignUp, error) {
+	var s model.SignUp
+	err := r.db.QueryRowContext(ctx, GetSignUp, username).
+		Scan(&s.ID, &s.Username, &s.State, &s.Attempts, &s.LastError, &s.CreatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrSignUpNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get sign up from database")
+	}
+
+	return &s, nil
+}
+
+// StaleSignUps returns at most limit sign ups last changed before the given time, oldest first
+func (r signUpRepo) StaleSignUps(ctx context.Context, before time.Time, limit int) ([]model.SignUp, error) {
+	rows, err := r.db.QueryContext(ctx, GetStaleSignUps, before.UTC(), limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get sign ups from database")
+	}
+	defer rows.Close()
+
+	signUps := []model.SignUp{}
+	for rows.Next() {
+		var s model.SignUp
+		err = rows.Scan(&s.ID, &s.Username, &s.State, &s.Attempts, &s.LastError, &s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to read sign up")
+		}
+		signUps = append(signUps, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to read sign ups")
+	}
+
+	return signUps, nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracingRepo struct {
+	tracer trace.Tracer
+	next   User
+}
+
+// NewTracingRepo wraps every query of the user repo in a client span named "repository.<method>"
+// carrying the sql statement
+func NewTracingRepo(next User, tracer trace.Tracer) User {
+	return &tracingRepo{
+		tracer: tracer,
+		next:   next,
+	}
+}
+
+func (r tracingRepo) start(ctx context.Context, method, statement string) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, "repository."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.statement", statement),
+		),
+	)
+}
+
+func (r tracingRepo) CreateUser(ctx context.Context, user *model.User) (err error) {
+	ctx, span := r.start(ctx, "CreateUser", InsertUser)
+	defer func() { tracing.End(span, err) }()
+	return r.next.CreateUser(ctx, user)
+}
+
+func (r tracingRepo) GetUser(ctx context.Context, user *model.User) (err error) {
+	ctx, span := r.start(ctx, "GetUser", GetUser)
+	defer func() { tracing.End(span, err) }()
+	return r.next.GetUser(ctx, user)
+}
+
+func (r tracingRepo) GetUserBySub(ctx context.Context, user *model.User) (err error) {
+	ctx, span := r.start(ctx, "GetUserBySub", GetUserBySub)
+	defer func() { tracing.End(span, err) }()
+	return r.next.GetUserBySub(ctx, user)
+}
+
+func (r tracingRepo) ListUsers(ctx context.Context, afterID, limit int) (users []model.User, err error) {
+	ctx, span := r.start(ctx, "ListUsers", ListUsers)
+	defer func() { tracing.End(span, err) }()
+	return r.next.ListUsers(ctx, afterID, limit)
+}
+
+func (r tracingRepo) UpdateUsername(ctx context.Context, id int, username string) (err error) {
+	ctx, span := r.start(ctx, "UpdateUsername", UpdateUsername)
+	defer func() { tracing.End(span, err) }()
+	return r.next.UpdateUsername(ctx, id, username)
+}
+
+func (r tracingRepo) UpdateSub(ctx context.Context, id int, sub string) (err error) {
+	ctx, span := r.start(ctx, "UpdateSub", UpdateSub)
+	defer func() { tracing.End(span, err) }()
+	return r.next.UpdateSub(ctx, id, sub)
+}
+
+func (r tracingRepo) DeleteUser(ctx context.Context, id int) (err error) {
+	ctx, span := r.start(ctx, "DeleteUser", DeleteUser)
+	defer func() { tracing.End(span, err) }()
+	return r.next.DeleteUser(ctx, id)
+}
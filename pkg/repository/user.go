@@ -11,17 +11,37 @@ import (
 
 const (
 	// InsertUser is a sql statement to insert a user into the users database
-	InsertUser string = "INSERT INTO users (username) VALUES(?)"
+	InsertUser string = "INSERT INTO users (username, cognito_sub) VALUES(?, ?)"
 	// GetUser is a sql statement to get a user from the users database
-	GetUser string = "SELECT id FROM users WHERE username = ?"
+	GetUser string = "SELECT id, cognito_sub FROM users WHERE username = ?"
+	// GetUserBySub is a sql statement to get a user from the users database by their identity provider sub
+	GetUserBySub string = "SELECT id FROM users WHERE cognito_sub = ?"
+	// ListUsers is a sql statement to get a page of users ordered by id
+	ListUsers string = "SELECT id, username, cognito_sub FROM users WHERE id > ? ORDER BY id LIMIT ?"
+	// UpdateUsername is a sql statement to change a user's username
+	UpdateUsername string = "UPDATE users SET username = ? WHERE id = ?"
+	// UpdateSub is a sql statement to link a user to their identity provider sub
+	UpdateSub string = "UPDATE users SET cognito_sub = ? WHERE id = ?"
+	// DeleteUser is a sql statement to remove a user and their profile from the users database
+	DeleteUser string = `DELETE users, profiles FROM users
+		LEFT JOIN profiles ON profiles.user_id = users.id
+		WHERE users.id = ?`
 )
 
 var errRepo = errors.New("Unable to handle Repo Request")
 
+// ErrUserNotFound is returned when no user matches the given username or sub
+var ErrUserNotFound = errors.New("No user found")
+
 // User interface to define user repo
 type User interface {
 	CreateUser(ctx context.Context, user *model.User) error
 	GetUser(ctx context.Context, user *model.User) error
+	GetUserBySub(ctx context.Context, user *model.User) error
+	ListUsers(ctx context.Context, afterID, limit int) ([]model.User, error)
+	UpdateUsername(ctx context.Context, id int, username string) error
+	UpdateSub(ctx context.Context, id int, sub string) error
+	DeleteUser(ctx context.Context, id int) error
 }
 
 type repo struct {
@@ -50,7 +70,7 @@ func (r repo) CreateUser(ctx context.Context, user *model.User) error {
 		return errRepo
 	}
 
-	result, err := stmt.Exec(user.Username)
+	result, err := stmt.Exec(user.Username, nullString(user.Sub))
 	if err != nil {
 		return errors.Wrap(err, "Failed to execute prepared insert statement")
 	}
@@ -67,24 +87,96 @@ func (r repo) CreateUser(ctx context.Context, user *model.User) error {
 }
 
 func (r repo) GetUser(ctx context.Context, user *model.User) error {
-	row, err := r.db.Query(GetUser, user.Username)
+	var sub sql.NullString
+	err := r.db.QueryRowContext(ctx, GetUser, user.Username).Scan(&user.ID, &sub)
+	if err == sql.ErrNoRows {
+		return errors.Wrap(ErrUserNotFound, "")
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to get user from database")
+	}
+
+	user.Sub = sub.String
+	return nil
+}
+
+// GetUserBySub sets the id of the user linked to user.Sub, which unlike the username never changes
+func (r repo) GetUserBySub(ctx context.Context, user *model.User) error {
+	err := r.db.QueryRowContext(ctx, GetUserBySub, user.Sub).Scan(&user.ID)
+	if err == sql.ErrNoRows {
+		return errors.Wrap(ErrUserNotFound, "")
+	}
 	if err != nil {
 		return errors.Wrap(err, "Failed to get user from database")
 	}
 
-	err = rowToUser(row, user)
+	return nil
+}
+
+// ListUsers returns at most limit users with an id after afterID, ordered by id
+func (r repo) ListUsers(ctx context.Context, afterID, limit int) ([]model.User, error) {
+	rows, err := r.db.QueryContext(ctx, ListUsers, afterID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list users from database")
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		var user model.User
+		var sub sql.NullString
+		err = rows.Scan(&user.ID, &user.Username, &sub)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to read user")
+		}
+		user.Sub = sub.String
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to read users")
+	}
+
+	return users, nil
+}
+
+func (r repo) UpdateUsername(ctx context.Context, id int, username string) error {
+	logger := log.With(r.logger, "method", "UpdateUsername")
+
+	_, err := r.db.ExecContext(ctx, UpdateUsername, username, id)
+	if err != nil {
+		return errors.Wrap(err, "Failed to update username")
+	}
+
+	logger.Log("Update username", id)
+	return nil
+}
+
+// UpdateSub links a user to their identity provider sub, rows stored before subs were kept have none
+func (r repo) UpdateSub(ctx context.Context, id int, sub string) error {
+	logger := log.With(r.logger, "method", "UpdateSub")
+
+	_, err := r.db.ExecContext(ctx, UpdateSub, nullString(sub), id)
 	if err != nil {
-		return errors.Wrap(err, "")
+		return errors.Wrap(err, "Failed to update user sub")
 	}
 
+	logger.Log("Update user sub", id)
 	return nil
 }
 
-func rowToUser(row *sql.Rows, user *model.User) error {
-	if !row.Next() {
-		return errors.New("No user found")
+func (r repo) DeleteUser(ctx context.Context, id int) error {
+	logger := log.With(r.logger, "method", "DeleteUser")
+
+	_, err := r.db.ExecContext(ctx, DeleteUser, id)
+	if err != nil {
+		return errors.Wrap(err, "Failed to delete user")
 	}
 
-	row.Scan(&user.ID)
+	logger.Log("Delete user", id)
 	return nil
 }
+
+// nullString stores an empty string as NULL, so the unique key allows any number of unset values
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -3,52 +3,68 @@ package service
 import (
 	"context"
 	"crypto/hmac"
-	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
 	"strconv"
-	"strings"
 
 	"github.com/PedPet/user/config"
 	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/auth"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/log"
-	"github.com/lestrrat/go-jwx/jwk"
 	"github.com/pkg/errors"
 )
 
 // CognitoClient derscribes the cognito client methods / functionality
 type CognitoClient interface {
 	Register(ctx context.Context, user *model.User) error
+	DeleteUser(ctx context.Context, username string) error
 	OTP(ctx context.Context, user *model.User, otp string) error
 	ResendConfirmation(ctx context.Context, username string) error
 	CheckUsernameTaken(ctx context.Context, username string) (bool, error)
+	ListUsers(ctx context.Context, paginationToken string) ([]model.User, string, error)
 	Login(ctx context.Context, username, password string) (*cognito.AuthenticationResultType, error)
-	getWellKnownJWTKs() error
+	RefreshSession(ctx context.Context, username, refreshToken string) (*cognito.AuthenticationResultType, error)
+	ForgotPassword(ctx context.Context, username string) error
+	ConfirmForgotPassword(ctx context.Context, username, code, password string) error
+	ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) error
+	GlobalSignOut(ctx context.Context, accessToken string) error
+	RevokeToken(ctx context.Context, username, refreshToken string) error
+	getWellKnownJWTKs(ctx context.Context) error
 	ParseAndVerifyJWT(ctx context.Context, token string) (*jwt.Token, error)
 	GetUserDetails(ctx context.Context, accessToken string) (*model.User, error)
+	UpdateContactDetails(ctx context.Context, accessToken, email, phoneNumber string) ([]model.CodeDelivery, error)
+	VerifyAttribute(ctx context.Context, accessToken, attribute, code string) error
 }
 
 const flowUsernamePassword = "USER_PASSWORD_AUTH"
 const flowRefreshToken = "REFRESH_TOKEN_AUTH"
 
+// listUsersLimit is the most users cognito returns in a page
+const listUsersLimit = 60
+
 type cognitoClient struct {
 	cognitoClient *cognito.CognitoIdentityProvider
 	userPoolID    string
 	appClientID   string
 	clientSecret  string
 	logger        log.Logger
-	wellKnownJWKs *jwk.Set
-	region        string
+	settings      config.AWSSettings
+	jwksMetrics   auth.JWKSMetrics
+	verifier      *auth.Verifier
 }
 
-// NewCognitoClient creates a cognito type with the required dependencies
+// NewCognitoClient creates a cognito type with the required dependencies. jwksMetrics instruments the
+// user pool's key set, which is refreshed until ctx is done.
 func NewCognitoClient(
+	ctx context.Context,
 	identity *cognito.CognitoIdentityProvider,
 	cfg config.AWSSettings,
+	jwksMetrics auth.JWKSMetrics,
 	logger log.Logger,
 ) (CognitoClient, error) {
 	c := &cognitoClient{
@@ -57,10 +73,11 @@ func NewCognitoClient(
 		appClientID:   cfg.CognitoAppClientID,
 		clientSecret:  cfg.CognitoClientSecret,
 		logger:        logger,
-		region:        cfg.Region,
+		settings:      cfg,
+		jwksMetrics:   jwksMetrics,
 	}
 
-	err := c.getWellKnownJWTKs()
+	err := c.getWellKnownJWTKs(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create cognito client")
 	}
@@ -68,21 +85,15 @@ func NewCognitoClient(
 	return c, nil
 }
 
-func (c *cognitoClient) getWellKnownJWTKs() error {
-	// https://cognito-idp.<region>.amazonaws.com/<pool_id>/.well-known/jwks.json
-	var url strings.Builder
-	url.WriteString("https://cognito-idp.")
-	url.WriteString(c.region)
-	url.WriteString(".amazonaws.com/")
-	url.WriteString(c.userPoolID)
-	url.WriteString("/.well-known/jwks.json")
-
-	set, err := jwk.Fetch(url.String())
+// getWellKnownJWTKs sets up verification against the user pool's key set, which is kept fresh in
+// the background until ctx is done
+func (c *cognitoClient) getWellKnownJWTKs(ctx context.Context) error {
+	verifier, err := auth.NewCognitoVerifier(ctx, c.settings, c.jwksMetrics, c.logger)
 	if err != nil {
-		return errors.Wrap(err, "Failed to get well known JSON web token key set")
+		return err
 	}
 
-	c.wellKnownJWKs = set
+	c.verifier = verifier
 	return nil
 }
 
@@ -108,12 +119,34 @@ func (c cognitoClient) Register(ctx context.Context, user *model.User) error {
 		},
 	}
 
-	output, err := c.cognitoClient.SignUp(cognitoUser)
+	output, err := c.cognitoClient.SignUpWithContext(ctx, cognitoUser)
 	if err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	logger.Log("Register User", output, user)
+	user.Sub = aws.StringValue(output.UserSub)
+	logger.Log("Register User", user.Sub)
+	return nil
+}
+
+// DeleteUser removes a user from the user pool to roll back their registration, a user that doesn't
+// exist is already removed
+func (c cognitoClient) DeleteUser(ctx context.Context, username string) error {
+	logger := log.With(c.logger, "method", "DeleteUser")
+
+	du := &cognito.AdminDeleteUserInput{
+		UserPoolId: aws.String(c.userPoolID),
+		Username:   aws.String(username),
+	}
+	_, err := c.cognitoClient.AdminDeleteUserWithContext(ctx, du)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == cognito.ErrCodeUserNotFoundException {
+			return nil
+		}
+		return errors.Wrap(err, "Failed to delete user")
+	}
+
+	logger.Log("Delete user")
 	return nil
 }
 
@@ -135,7 +168,7 @@ func (c cognitoClient) OTP(ctx context.Context, user *model.User, otp string) er
 		ClientId:         aws.String(c.appClientID),
 		SecretHash:       aws.String(s),
 	}
-	output, err := c.cognitoClient.ConfirmSignUp(cu)
+	output, err := c.cognitoClient.ConfirmSignUpWithContext(ctx, cu)
 	if err != nil {
 		return err
 	}
@@ -154,7 +187,7 @@ func (c cognitoClient) ResendConfirmation(ctx context.Context, username string)
 		ClientId:   aws.String(c.appClientID),
 		SecretHash: aws.String(s),
 	}
-	output, err := c.cognitoClient.ResendConfirmationCode(rc)
+	output, err := c.cognitoClient.ResendConfirmationCodeWithContext(ctx, rc)
 	if err != nil {
 		return err
 	}
@@ -171,7 +204,7 @@ func (c cognitoClient) CheckUsernameTaken(ctx context.Context, username string)
 		UserPoolId: aws.String(c.userPoolID),
 		Username:   aws.String(username),
 	}
-	_, err := c.cognitoClient.AdminGetUser(cu)
+	_, err := c.cognitoClient.AdminGetUserWithContext(ctx, cu)
 	if err != nil {
 		err2, ok := err.(awserr.Error)
 		if ok {
@@ -187,6 +220,43 @@ func (c cognitoClient) CheckUsernameTaken(ctx context.Context, username string)
 	return true, nil
 }
 
+// ListUsers returns the usernames and subs of a page of the user pool's users and the token of the next
+// page, which is empty after the last page
+func (c cognitoClient) ListUsers(ctx context.Context, paginationToken string) ([]model.User, string, error) {
+	lu := &cognito.ListUsersInput{
+		UserPoolId:      aws.String(c.userPoolID),
+		Limit:           aws.Int64(listUsersLimit),
+		AttributesToGet: []*string{aws.String("sub")},
+	}
+	if paginationToken != "" {
+		lu.PaginationToken = aws.String(paginationToken)
+	}
+
+	output, err := c.cognitoClient.ListUsersWithContext(ctx, lu)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Failed to list users")
+	}
+
+	users := make([]model.User, 0, len(output.Users))
+	for _, u := range output.Users {
+		users = append(users, model.User{
+			Username: aws.StringValue(u.Username),
+			Sub:      attributeValue(u.Attributes, "sub"),
+		})
+	}
+	return users, aws.StringValue(output.PaginationToken), nil
+}
+
+// attributeValue returns the value of the named attribute, empty when the user doesn't have it
+func attributeValue(attributes []*cognito.AttributeType, name string) string {
+	for _, attr := range attributes {
+		if aws.StringValue(attr.Name) == name {
+			return aws.StringValue(attr.Value)
+		}
+	}
+	return ""
+}
+
 // Login uses a username and password to log a user in and return their authentication
 func (c cognitoClient) Login(ctx context.Context, username, password string) (*cognito.AuthenticationResultType, error) {
 	logger := log.With(c.logger, "method", "Login")
@@ -204,7 +274,7 @@ func (c cognitoClient) Login(ctx context.Context, username, password string) (*c
 		AuthParameters: params,
 		ClientId:       aws.String(c.appClientID),
 	}
-	output, err := c.cognitoClient.InitiateAuth(auth)
+	output, err := c.cognitoClient.InitiateAuthWithContext(ctx, auth)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to authenticate user")
 	}
@@ -213,38 +283,159 @@ func (c cognitoClient) Login(ctx context.Context, username, password string) (*c
 		return nil, errors.New("Failed to get authenticate user")
 	}
 
-	logger.Log("Login output:", output)
+	logger.Log("Login", username)
 	return output.AuthenticationResult, nil
 }
 
-// ParseAnVerifyJWT is self explanatory
-func (c cognitoClient) ParseAndVerifyJWT(ctx context.Context, token string) (*jwt.Token, error) {
-	logger := log.With(c.logger, "method", "ParseAndVerifyJWT")
+// RefreshSession exchanges a refresh token for new access and id tokens
+func (c cognitoClient) RefreshSession(ctx context.Context, username, refreshToken string) (*cognito.AuthenticationResultType, error) {
+	logger := log.With(c.logger, "method", "RefreshSession")
+
+	s := calculateSecretHash(username, c.appClientID, c.clientSecret)
+	flow := aws.String(flowRefreshToken)
+	params := map[string]*string{
+		"REFRESH_TOKEN": aws.String(refreshToken),
+		"SECRET_HASH":   aws.String(s),
+	}
 
-	t, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		// fmt.Printf("Token: %v\n", t)
+	auth := &cognito.InitiateAuthInput{
+		AuthFlow:       flow,
+		AuthParameters: params,
+		ClientId:       aws.String(c.appClientID),
+	}
+	output, err := c.cognitoClient.InitiateAuthWithContext(ctx, auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to refresh session")
+	}
 
-		// Looking up the key id will return an array of just one key
-		keys := c.wellKnownJWKs.LookupKeyID(token.Header["kid"].(string))
-		if len(keys) == 0 {
-			return nil, errors.New("Could not find matching `kid` in well known tokens")
-		}
+	if output.AuthenticationResult == nil {
+		return nil, errors.New("Failed to refresh session")
+	}
 
-		// Build the public RSA key
-		key, err := keys[0].Materialize()
-		if err != nil {
-			return nil, errors.Wrap(err, "Failed to create public key")
-		}
+	logger.Log("Refreshed session for", username)
+	return output.AuthenticationResult, nil
+}
 
-		rsaPublicKey := key.(*rsa.PublicKey)
-		return rsaPublicKey, nil
-	})
+// ForgotPassword sends the user a code they can use to reset their password
+func (c cognitoClient) ForgotPassword(ctx context.Context, username string) error {
+	logger := log.With(c.logger, "method", "ForgotPassword")
+
+	s := calculateSecretHash(username, c.appClientID, c.clientSecret)
+	fp := &cognito.ForgotPasswordInput{
+		Username:   aws.String(username),
+		ClientId:   aws.String(c.appClientID),
+		SecretHash: aws.String(s),
+	}
+	output, err := c.cognitoClient.ForgotPasswordWithContext(ctx, fp)
 	if err != nil {
-		return nil, errors.Wrap(err, "Invalid token")
+		return errors.Wrap(err, "Failed to start forgot password")
 	}
 
-	if t.Valid != true {
-		return nil, errors.New("Token is not valid")
+	logger.Log("Forgot password output:", output)
+	return nil
+}
+
+// ConfirmForgotPassword resets the user's password using the code sent by ForgotPassword
+func (c cognitoClient) ConfirmForgotPassword(ctx context.Context, username, code, password string) error {
+	logger := log.With(c.logger, "method", "ConfirmForgotPassword")
+
+	s := calculateSecretHash(username, c.appClientID, c.clientSecret)
+	cfp := &cognito.ConfirmForgotPasswordInput{
+		Username:         aws.String(username),
+		ConfirmationCode: aws.String(code),
+		Password:         aws.String(password),
+		ClientId:         aws.String(c.appClientID),
+		SecretHash:       aws.String(s),
+	}
+	_, err := c.cognitoClient.ConfirmForgotPasswordWithContext(ctx, cfp)
+	if err != nil {
+		return errors.Wrap(err, "Failed to reset password")
+	}
+
+	logger.Log("Password reset")
+	return nil
+}
+
+// ChangePassword changes the password of the user the access token belongs to
+func (c cognitoClient) ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) error {
+	logger := log.With(c.logger, "method", "ChangePassword")
+
+	cp := &cognito.ChangePasswordInput{
+		AccessToken:      aws.String(accessToken),
+		PreviousPassword: aws.String(oldPassword),
+		ProposedPassword: aws.String(newPassword),
+	}
+	_, err := c.cognitoClient.ChangePasswordWithContext(ctx, cp)
+	if err != nil {
+		return errors.Wrap(err, "Failed to change password")
+	}
+
+	logger.Log("Password changed")
+	return nil
+}
+
+// GlobalSignOut revokes every token issued to the user the access token belongs to
+func (c cognitoClient) GlobalSignOut(ctx context.Context, accessToken string) error {
+	logger := log.With(c.logger, "method", "GlobalSignOut")
+
+	so := &cognito.GlobalSignOutInput{
+		AccessToken: aws.String(accessToken),
+	}
+	_, err := c.cognitoClient.GlobalSignOutWithContext(ctx, so)
+	if err != nil {
+		return errors.Wrap(err, "Failed to sign out")
+	}
+
+	logger.Log("Signed out globally")
+	return nil
+}
+
+// revokeTokenInput is the input of cognito's RevokeToken operation, which the sdk we use predates
+type revokeTokenInput struct {
+	_ struct{} `type:"structure"`
+
+	ClientId     *string `type:"string" required:"true"`
+	ClientSecret *string `type:"string" sensitive:"true"`
+	Token        *string `type:"string" required:"true" sensitive:"true"`
+}
+
+type revokeTokenOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+// RevokeToken revokes a refresh token and the access tokens issued with it. Cognito only revokes tokens
+// issued to our app client, the username isn't needed.
+func (c cognitoClient) RevokeToken(ctx context.Context, username, refreshToken string) error {
+	logger := log.With(c.logger, "method", "RevokeToken")
+
+	op := &request.Operation{
+		Name:       "RevokeToken",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	rt := &revokeTokenInput{
+		ClientId:     aws.String(c.appClientID),
+		ClientSecret: aws.String(c.clientSecret),
+		Token:        aws.String(refreshToken),
+	}
+	req := c.cognitoClient.NewRequest(op, rt, &revokeTokenOutput{})
+	req.SetContext(ctx)
+	err := req.Send()
+	if err != nil {
+		return errors.Wrap(err, "Failed to revoke token")
+	}
+
+	logger.Log("Revoke token", username)
+	return nil
+}
+
+// ParseAnVerifyJWT is self explanatory
+func (c cognitoClient) ParseAndVerifyJWT(ctx context.Context, token string) (*jwt.Token, error) {
+	logger := log.With(c.logger, "method", "ParseAndVerifyJWT")
+
+	t, err := c.verifier.Parse(token)
+	if err != nil {
+		return nil, err
 	}
 
 	logger.Log("Token is valid")
@@ -258,31 +449,96 @@ func (c cognitoClient) GetUserDetails(ctx context.Context, accessToken string) (
 	ui := &cognito.GetUserInput{
 		AccessToken: aws.String(accessToken),
 	}
-	output, err := c.cognitoClient.GetUser(ui)
+	output, err := c.cognitoClient.GetUserWithContext(ctx, ui)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to get user")
 	}
 
+	// Only users who confirmed their sign up can log in, so the owner of an access token is confirmed
+	// even while a changed email waits to be verified
 	user := &model.User{
-		Username: aws.StringValue(output.Username),
+		Username:  aws.StringValue(output.Username),
+		Confirmed: true,
 	}
 
 	// Put user attributes into user model
 	for _, attr := range output.UserAttributes {
 		switch aws.StringValue(attr.Name) {
+		case "sub":
+			user.Sub = aws.StringValue(attr.Value)
 		case "phone_number":
 			user.PhoneNumber = aws.StringValue(attr.Value)
 		case "email":
 			user.Email = aws.StringValue(attr.Value)
 		case "email_verified":
-			confirmed, err := strconv.ParseBool(aws.StringValue(attr.Value))
-			if err != nil {
-				user.Confirmed = false
-			}
-			user.Confirmed = confirmed
+			user.EmailVerified, _ = strconv.ParseBool(aws.StringValue(attr.Value))
+		case "phone_number_verified":
+			user.PhoneNumberVerified, _ = strconv.ParseBool(aws.StringValue(attr.Value))
 		}
 	}
 
-	logger.Log("GetUserDetails output:", output)
+	logger.Log("GetUserDetails", user.Username)
 	return user, nil
 }
+
+// UpdateContactDetails changes the email and phone number of the user the access token belongs to, an
+// empty value is left as it is. Cognito marks a changed value unverified and sends a code to it.
+func (c cognitoClient) UpdateContactDetails(
+	ctx context.Context,
+	accessToken, email, phoneNumber string,
+) ([]model.CodeDelivery, error) {
+	logger := log.With(c.logger, "method", "UpdateContactDetails")
+
+	attributes := []*cognito.AttributeType{}
+	if email != "" {
+		attributes = append(attributes, &cognito.AttributeType{
+			Name:  aws.String(model.AttributeEmail),
+			Value: aws.String(email),
+		})
+	}
+	if phoneNumber != "" {
+		attributes = append(attributes, &cognito.AttributeType{
+			Name:  aws.String(model.AttributePhoneNumber),
+			Value: aws.String(phoneNumber),
+		})
+	}
+
+	ua := &cognito.UpdateUserAttributesInput{
+		AccessToken:    aws.String(accessToken),
+		UserAttributes: attributes,
+	}
+	output, err := c.cognitoClient.UpdateUserAttributesWithContext(ctx, ua)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to update user attributes")
+	}
+
+	deliveries := make([]model.CodeDelivery, 0, len(output.CodeDeliveryDetailsList))
+	for _, d := range output.CodeDeliveryDetailsList {
+		deliveries = append(deliveries, model.CodeDelivery{
+			AttributeName:  aws.StringValue(d.AttributeName),
+			DeliveryMedium: aws.StringValue(d.DeliveryMedium),
+			Destination:    aws.StringValue(d.Destination),
+		})
+	}
+
+	logger.Log("Update contact details", len(deliveries))
+	return deliveries, nil
+}
+
+// VerifyAttribute verifies the attribute of the user the access token belongs to with the code sent to it
+func (c cognitoClient) VerifyAttribute(ctx context.Context, accessToken, attribute, code string) error {
+	logger := log.With(c.logger, "method", "VerifyAttribute")
+
+	va := &cognito.VerifyUserAttributeInput{
+		AccessToken:   aws.String(accessToken),
+		AttributeName: aws.String(attribute),
+		Code:          aws.String(code),
+	}
+	_, err := c.cognitoClient.VerifyUserAttributeWithContext(ctx, va)
+	if err != nil {
+		return errors.Wrap(err, "Failed to verify user attribute")
+	}
+
+	logger.Log("Verify attribute", attribute)
+	return nil
+}
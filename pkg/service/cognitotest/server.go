@@ -0,0 +1,995 @@
+// Package cognitotest provides an in-process stand-in for the AWS Cognito Identity Provider
+// API, so code using the cognito client can be tested without network access or credentials.
+package cognitotest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PedPet/user/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/lestrrat/go-jwx/jwk"
+)
+
+const (
+	targetPrefix = "AWSCognitoIdentityProviderService."
+	keyID        = "cognitotest"
+	tokenTTL     = time.Hour
+	// Region is the region the fake user pool pretends to live in
+	Region = "eu-west-1"
+	// UserPoolID is the id of the fake user pool
+	UserPoolID = "eu-west-1_cognitotest"
+	// ClientID is the app client id the fake user pool accepts
+	ClientID = "cognitotestclient"
+	// ClientSecret is the app client secret used to check secret hashes
+	ClientSecret = "cognitotestsecret"
+)
+
+type user struct {
+	sub        string
+	username   string
+	password   string
+	attributes map[string]string
+	confirmed  bool
+	code       string
+	// attributeCodes are the codes sent to verify changed attributes, by attribute name
+	attributeCodes map[string]string
+	createdAt      time.Time
+}
+
+// Server is a fake cognito user pool served over HTTP. Point an aws session at URL and
+// the cognito client at JWKSURL to use it.
+type Server struct {
+	URL string
+
+	srv        *httptest.Server
+	key        *rsa.PrivateKey
+	keySet     []byte
+	mu         sync.Mutex
+	users      map[string]*user
+	tokens     map[string]string
+	refresh    map[string]string
+	operations map[string]func(body []byte) (interface{}, error)
+}
+
+// NewServer starts a fake cognito user pool. The caller should call Close when finished.
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("cognitotest: failed to generate key: %v", err))
+	}
+
+	jwkKey, err := jwk.New(&key.PublicKey)
+	if err != nil {
+		panic(fmt.Sprintf("cognitotest: failed to create jwk: %v", err))
+	}
+	jwkKey.Set(jwk.KeyIDKey, keyID)
+	jwkKey.Set(jwk.AlgorithmKey, jwt.SigningMethodRS256.Alg())
+	jwkKey.Set(jwk.KeyUsageKey, string(jwk.ForSignature))
+	keySet, err := json.Marshal(jwk.Set{Keys: []jwk.Key{jwkKey}})
+	if err != nil {
+		panic(fmt.Sprintf("cognitotest: failed to marshal jwks: %v", err))
+	}
+
+	s := &Server{
+		key:     key,
+		keySet:  keySet,
+		users:   map[string]*user{},
+		tokens:  map[string]string{},
+		refresh: map[string]string{},
+	}
+	s.operations = map[string]func(body []byte) (interface{}, error){
+		"SignUp":                 s.signUp,
+		"ConfirmSignUp":          s.confirmSignUp,
+		"ResendConfirmationCode": s.resendConfirmationCode,
+		"InitiateAuth":           s.initiateAuth,
+		"ForgotPassword":         s.forgotPassword,
+		"ConfirmForgotPassword":  s.confirmForgotPassword,
+		"ChangePassword":         s.changePassword,
+		"GlobalSignOut":          s.globalSignOut,
+		"RevokeToken":            s.revokeToken,
+		"GetUser":                s.getUser,
+		"AdminGetUser":           s.adminGetUser,
+		"AdminDeleteUser":        s.adminDeleteUser,
+		"ListUsers":              s.listUsers,
+		"UpdateUserAttributes":   s.updateUserAttributes,
+		"VerifyUserAttribute":    s.verifyUserAttribute,
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Issuer is the value of the `iss` claim in tokens issued by the server
+func (s *Server) Issuer() string {
+	return s.URL + "/" + UserPoolID
+}
+
+// JWKSURL is the url of the server's well known JSON web key set
+func (s *Server) JWKSURL() string {
+	return s.Issuer() + "/.well-known/jwks.json"
+}
+
+// Settings returns aws settings pointing at the server
+func (s *Server) Settings() config.AWSSettings {
+	return config.AWSSettings{
+		AccessKeyID:         "cognitotest",
+		SecretAccessKey:     "cognitotest",
+		CognitoUserPoolID:   UserPoolID,
+		CognitoAppClientID:  ClientID,
+		CognitoClientSecret: ClientSecret,
+		Region:              Region,
+		Endpoint:            s.URL,
+		JWKSURL:             s.JWKSURL(),
+	}
+}
+
+// Session creates an aws session whose cognito calls are sent to the server
+func (s *Server) Session() (*session.Session, error) {
+	return session.NewSession(&aws.Config{
+		Region:      aws.String(Region),
+		Endpoint:    aws.String(s.URL),
+		Credentials: credentials.NewStaticCredentials("cognitotest", "cognitotest", ""),
+		MaxRetries:  aws.Int(0),
+	})
+}
+
+// Code returns the last confirmation code sent to a user, as if read from their email
+func (s *Server) Code(username string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[username]
+	if !ok {
+		return ""
+	}
+	return u.code
+}
+
+// AttributeCode returns the last code sent to verify a user's changed attribute, as if read from their
+// email or phone
+func (s *Server) AttributeCode(username, attribute string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[username]
+	if !ok {
+		return ""
+	}
+	return u.attributeCodes[attribute]
+}
+
+// ConfirmUser marks a user as confirmed, like AdminConfirmSignUp
+func (s *Server) ConfirmUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[username]; ok {
+		u.confirmed = true
+		u.attributes["email_verified"] = "true"
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/"+UserPoolID+"/.well-known/jwks.json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(s.keySet)
+		return
+	}
+
+	target := r.Header.Get("X-Amz-Target")
+	op, ok := s.operations[strings.TrimPrefix(target, targetPrefix)]
+	if r.Method != http.MethodPost || !ok {
+		writeError(w, newError("UnknownOperationException", "Unknown operation "+target))
+		return
+	}
+
+	var body json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, newError("SerializationException", err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	resp, err := op(body)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Error is a cognito error response
+type Error struct {
+	Code    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newError(code, message string) error {
+	return Error{Code: code, Message: message}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(Error)
+	if !ok {
+		e = Error{Code: cognito.ErrCodeInternalErrorException, Message: err.Error()}
+	}
+
+	status := http.StatusBadRequest
+	if e.Code == cognito.ErrCodeInternalErrorException {
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Header().Set("X-Amzn-ErrorType", e.Code)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+func secretHash(username string) string {
+	h := hmac.New(sha256.New, []byte(ClientSecret))
+	h.Write([]byte(username + ClientID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// checkClient validates the app client id and the secret hash for the user
+func checkClient(clientID, hash *string, username string) error {
+	if aws.StringValue(clientID) != ClientID {
+		return newError(
+			cognito.ErrCodeResourceNotFoundException,
+			"User pool client "+aws.StringValue(clientID)+" does not exist.",
+		)
+	}
+
+	if !hmac.Equal([]byte(aws.StringValue(hash)), []byte(secretHash(username))) {
+		return newError(
+			cognito.ErrCodeNotAuthorizedException,
+			"Unable to verify secret hash for client "+ClientID,
+		)
+	}
+
+	return nil
+}
+
+// checkPassword applies a minimal password policy
+func checkPassword(password string) error {
+	if len(password) < 8 {
+		return newError(
+			cognito.ErrCodeInvalidPasswordException,
+			"Password did not conform with policy: Password not long enough",
+		)
+	}
+	return nil
+}
+
+func newCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(fmt.Sprintf("cognitotest: failed to generate code: %v", err))
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+func newID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		panic(fmt.Sprintf("cognitotest: failed to generate id: %v", err))
+	}
+	return id.String()
+}
+
+func (s *Server) user(username string) (*user, error) {
+	u, ok := s.users[username]
+	if !ok {
+		return nil, newError(cognito.ErrCodeUserNotFoundException, "User does not exist.")
+	}
+	return u, nil
+}
+
+func containsString(values []*string, value string) bool {
+	for _, v := range values {
+		if aws.StringValue(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *user) attributeList() []map[string]string {
+	attrs := []map[string]string{{"Name": "sub", "Value": u.sub}}
+	for name, value := range u.attributes {
+		attrs = append(attrs, map[string]string{"Name": name, "Value": value})
+	}
+	return attrs
+}
+
+func (u *user) status() string {
+	if u.confirmed {
+		return cognito.UserStatusTypeConfirmed
+	}
+	return cognito.UserStatusTypeUnconfirmed
+}
+
+func codeDelivery(u *user) map[string]string {
+	return attributeDelivery("email", u.attributes["email"])
+}
+
+// attributeDelivery describes a code sent to an email or phone number, masking the destination
+func attributeDelivery(name, value string) map[string]string {
+	if name == "phone_number" {
+		if len(value) > 4 {
+			value = "+*******" + value[len(value)-4:]
+		}
+		return map[string]string{
+			"AttributeName":  name,
+			"DeliveryMedium": cognito.DeliveryMediumTypeSms,
+			"Destination":    value,
+		}
+	}
+
+	if at := strings.Index(value, "@"); at > 0 {
+		value = value[:1] + "***" + value[at:]
+	}
+	return map[string]string{
+		"AttributeName":  name,
+		"DeliveryMedium": cognito.DeliveryMediumTypeEmail,
+		"Destination":    value,
+	}
+}
+
+func (s *Server) signUp(body []byte) (interface{}, error) {
+	var in cognito.SignUpInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	username := aws.StringValue(in.Username)
+	err = checkClient(in.ClientId, in.SecretHash, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := s.users[username]; ok {
+		return nil, newError(cognito.ErrCodeUsernameExistsException, "User already exists")
+	}
+
+	err = checkPassword(aws.StringValue(in.Password))
+	if err != nil {
+		return nil, err
+	}
+
+	u := &user{
+		sub:        newID(),
+		username:   username,
+		password:   aws.StringValue(in.Password),
+		attributes: map[string]string{"email_verified": "false"},
+		code:       newCode(),
+		createdAt:  time.Now(),
+	}
+	for _, attr := range in.UserAttributes {
+		u.attributes[aws.StringValue(attr.Name)] = aws.StringValue(attr.Value)
+	}
+	s.users[username] = u
+
+	return map[string]interface{}{
+		"UserConfirmed":       false,
+		"UserSub":             u.sub,
+		"CodeDeliveryDetails": codeDelivery(u),
+	}, nil
+}
+
+func (s *Server) confirmSignUp(body []byte) (interface{}, error) {
+	var in cognito.ConfirmSignUpInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	username := aws.StringValue(in.Username)
+	err = checkClient(in.ClientId, in.SecretHash, username)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.user(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.confirmed {
+		return nil, newError(
+			cognito.ErrCodeNotAuthorizedException,
+			"User cannot be confirmed. Current status is CONFIRMED",
+		)
+	}
+
+	if u.code == "" || aws.StringValue(in.ConfirmationCode) != u.code {
+		return nil, newError(
+			cognito.ErrCodeCodeMismatchException,
+			"Invalid verification code provided, please try again.",
+		)
+	}
+
+	u.confirmed = true
+	u.code = ""
+	u.attributes["email_verified"] = "true"
+	return map[string]interface{}{}, nil
+}
+
+func (s *Server) resendConfirmationCode(body []byte) (interface{}, error) {
+	var in cognito.ResendConfirmationCodeInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	username := aws.StringValue(in.Username)
+	err = checkClient(in.ClientId, in.SecretHash, username)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.user(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.confirmed {
+		return nil, newError(cognito.ErrCodeInvalidParameterException, "User is already confirmed.")
+	}
+
+	u.code = newCode()
+	return map[string]interface{}{
+		"CodeDeliveryDetails": codeDelivery(u),
+	}, nil
+}
+
+func (s *Server) initiateAuth(body []byte) (interface{}, error) {
+	var in cognito.InitiateAuthInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	switch aws.StringValue(in.AuthFlow) {
+	case cognito.AuthFlowTypeUserPasswordAuth:
+		return s.userPasswordAuth(&in)
+	case cognito.AuthFlowTypeRefreshTokenAuth, cognito.AuthFlowTypeRefreshToken:
+		return s.refreshTokenAuth(&in)
+	default:
+		return nil, newError(
+			cognito.ErrCodeInvalidParameterException,
+			"Unsupported auth flow "+aws.StringValue(in.AuthFlow),
+		)
+	}
+}
+
+func (s *Server) userPasswordAuth(in *cognito.InitiateAuthInput) (interface{}, error) {
+	username := aws.StringValue(in.AuthParameters["USERNAME"])
+	err := checkClient(in.ClientId, in.AuthParameters["SECRET_HASH"], username)
+	if err != nil {
+		return nil, err
+	}
+
+	u, ok := s.users[username]
+	if !ok || u.password != aws.StringValue(in.AuthParameters["PASSWORD"]) {
+		return nil, newError(cognito.ErrCodeNotAuthorizedException, "Incorrect username or password.")
+	}
+
+	if !u.confirmed {
+		return nil, newError(cognito.ErrCodeUserNotConfirmedException, "User is not confirmed.")
+	}
+
+	result, err := s.authenticationResult(u)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := make([]byte, 32)
+	_, err = rand.Read(refresh)
+	if err != nil {
+		return nil, err
+	}
+	result["RefreshToken"] = hex.EncodeToString(refresh)
+	s.refresh[result["RefreshToken"].(string)] = u.username
+
+	return map[string]interface{}{
+		"AuthenticationResult": result,
+		"ChallengeParameters":  map[string]string{},
+	}, nil
+}
+
+// refreshTokenAuth issues new access and id tokens, like cognito it does not rotate the refresh token
+func (s *Server) refreshTokenAuth(in *cognito.InitiateAuthInput) (interface{}, error) {
+	invalid := newError(cognito.ErrCodeNotAuthorizedException, "Invalid Refresh Token")
+
+	username, ok := s.refresh[aws.StringValue(in.AuthParameters["REFRESH_TOKEN"])]
+	if !ok {
+		return nil, invalid
+	}
+
+	err := checkClient(in.ClientId, in.AuthParameters["SECRET_HASH"], username)
+	if err != nil {
+		return nil, err
+	}
+
+	u, ok := s.users[username]
+	if !ok {
+		return nil, invalid
+	}
+
+	result, err := s.authenticationResult(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"AuthenticationResult": result,
+		"ChallengeParameters":  map[string]string{},
+	}, nil
+}
+
+func (s *Server) forgotPassword(body []byte) (interface{}, error) {
+	var in cognito.ForgotPasswordInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	username := aws.StringValue(in.Username)
+	err = checkClient(in.ClientId, in.SecretHash, username)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.user(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.confirmed {
+		return nil, newError(
+			cognito.ErrCodeInvalidParameterException,
+			"Cannot reset password for the user as there is no registered/verified email or phone_number",
+		)
+	}
+
+	u.code = newCode()
+	return map[string]interface{}{
+		"CodeDeliveryDetails": codeDelivery(u),
+	}, nil
+}
+
+func (s *Server) confirmForgotPassword(body []byte) (interface{}, error) {
+	var in cognito.ConfirmForgotPasswordInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	username := aws.StringValue(in.Username)
+	err = checkClient(in.ClientId, in.SecretHash, username)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.user(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.code == "" || aws.StringValue(in.ConfirmationCode) != u.code {
+		return nil, newError(
+			cognito.ErrCodeCodeMismatchException,
+			"Invalid verification code provided, please try again.",
+		)
+	}
+
+	err = checkPassword(aws.StringValue(in.Password))
+	if err != nil {
+		return nil, err
+	}
+
+	u.password = aws.StringValue(in.Password)
+	u.code = ""
+	return map[string]interface{}{}, nil
+}
+
+func (s *Server) changePassword(body []byte) (interface{}, error) {
+	var in cognito.ChangePasswordInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	u, err := s.accessTokenUser(aws.StringValue(in.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.password != aws.StringValue(in.PreviousPassword) {
+		return nil, newError(cognito.ErrCodeNotAuthorizedException, "Incorrect username or password.")
+	}
+
+	err = checkPassword(aws.StringValue(in.ProposedPassword))
+	if err != nil {
+		return nil, err
+	}
+
+	u.password = aws.StringValue(in.ProposedPassword)
+	return map[string]interface{}{}, nil
+}
+
+func (s *Server) globalSignOut(body []byte) (interface{}, error) {
+	var in cognito.GlobalSignOutInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	u, err := s.accessTokenUser(aws.StringValue(in.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	for token, username := range s.tokens {
+		if username == u.username {
+			delete(s.tokens, token)
+		}
+	}
+	for token, username := range s.refresh {
+		if username == u.username {
+			delete(s.refresh, token)
+		}
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+// revokeTokenInput is the input of RevokeToken, which the sdk predates
+type revokeTokenInput struct {
+	ClientId     *string
+	ClientSecret *string
+	Token        *string
+}
+
+// revokeToken revokes a refresh token, like cognito a token that isn't valid is already revoked
+func (s *Server) revokeToken(body []byte) (interface{}, error) {
+	var in revokeTokenInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	if aws.StringValue(in.ClientId) != ClientID || aws.StringValue(in.ClientSecret) != ClientSecret {
+		return nil, newError(cognito.ErrCodeNotAuthorizedException, "Client is not authorized to revoke tokens")
+	}
+
+	delete(s.refresh, aws.StringValue(in.Token))
+	return map[string]interface{}{}, nil
+}
+
+func (s *Server) getUser(body []byte) (interface{}, error) {
+	var in cognito.GetUserInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	u, err := s.accessTokenUser(aws.StringValue(in.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"Username":       u.username,
+		"UserAttributes": u.attributeList(),
+	}, nil
+}
+
+func (s *Server) adminGetUser(body []byte) (interface{}, error) {
+	var in cognito.AdminGetUserInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	if aws.StringValue(in.UserPoolId) != UserPoolID {
+		return nil, newError(
+			cognito.ErrCodeResourceNotFoundException,
+			"User pool "+aws.StringValue(in.UserPoolId)+" does not exist.",
+		)
+	}
+
+	u, err := s.user(aws.StringValue(in.Username))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"Username":             u.username,
+		"UserAttributes":       u.attributeList(),
+		"UserStatus":           u.status(),
+		"Enabled":              true,
+		"UserCreateDate":       u.createdAt.Unix(),
+		"UserLastModifiedDate": u.createdAt.Unix(),
+	}, nil
+}
+
+func (s *Server) adminDeleteUser(body []byte) (interface{}, error) {
+	var in cognito.AdminDeleteUserInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	if aws.StringValue(in.UserPoolId) != UserPoolID {
+		return nil, newError(
+			cognito.ErrCodeResourceNotFoundException,
+			"User pool "+aws.StringValue(in.UserPoolId)+" does not exist.",
+		)
+	}
+
+	u, err := s.user(aws.StringValue(in.Username))
+	if err != nil {
+		return nil, err
+	}
+
+	delete(s.users, u.username)
+	for token, username := range s.tokens {
+		if username == u.username {
+			delete(s.tokens, token)
+		}
+	}
+	for token, username := range s.refresh {
+		if username == u.username {
+			delete(s.refresh, token)
+		}
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+// listUsers pages through the users in username order, the pagination token is the index of the next
+// page's first user
+func (s *Server) listUsers(body []byte) (interface{}, error) {
+	var in cognito.ListUsersInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	if aws.StringValue(in.UserPoolId) != UserPoolID {
+		return nil, newError(
+			cognito.ErrCodeResourceNotFoundException,
+			"User pool "+aws.StringValue(in.UserPoolId)+" does not exist.",
+		)
+	}
+
+	usernames := make([]string, 0, len(s.users))
+	for username := range s.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	start := 0
+	if in.PaginationToken != nil {
+		start, err = strconv.Atoi(aws.StringValue(in.PaginationToken))
+		if err != nil || start < 0 || start > len(usernames) {
+			return nil, newError(cognito.ErrCodeInvalidParameterException, "Invalid pagination token.")
+		}
+	}
+	limit := int(aws.Int64Value(in.Limit))
+	if limit <= 0 || limit > 60 {
+		limit = 60
+	}
+	end := start + limit
+	if end > len(usernames) {
+		end = len(usernames)
+	}
+
+	users := []map[string]interface{}{}
+	for _, username := range usernames[start:end] {
+		u := s.users[username]
+		// Every attribute unless only some are asked for
+		attrs := []map[string]string{}
+		for _, attr := range u.attributeList() {
+			if in.AttributesToGet == nil || containsString(in.AttributesToGet, attr["Name"]) {
+				attrs = append(attrs, attr)
+			}
+		}
+		users = append(users, map[string]interface{}{
+			"Username":             u.username,
+			"Attributes":           attrs,
+			"UserStatus":           u.status(),
+			"Enabled":              true,
+			"UserCreateDate":       u.createdAt.Unix(),
+			"UserLastModifiedDate": u.createdAt.Unix(),
+		})
+	}
+
+	resp := map[string]interface{}{"Users": users}
+	if end < len(usernames) {
+		resp["PaginationToken"] = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+// updateUserAttributes changes the attributes, a changed email or phone number is unverified and sent a
+// code to verify it with
+func (s *Server) updateUserAttributes(body []byte) (interface{}, error) {
+	var in cognito.UpdateUserAttributesInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	u, err := s.accessTokenUser(aws.StringValue(in.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range in.UserAttributes {
+		name := aws.StringValue(attr.Name)
+		if name == "sub" || strings.HasSuffix(name, "_verified") {
+			return nil, newError(cognito.ErrCodeInvalidParameterException, "Cannot modify an immutable attribute "+name+".")
+		}
+	}
+
+	deliveries := []map[string]string{}
+	for _, attr := range in.UserAttributes {
+		name, value := aws.StringValue(attr.Name), aws.StringValue(attr.Value)
+		u.attributes[name] = value
+		if name != "email" && name != "phone_number" {
+			continue
+		}
+
+		u.attributes[name+"_verified"] = "false"
+		if u.attributeCodes == nil {
+			u.attributeCodes = map[string]string{}
+		}
+		u.attributeCodes[name] = newCode()
+		deliveries = append(deliveries, attributeDelivery(name, value))
+	}
+
+	return map[string]interface{}{
+		"CodeDeliveryDetailsList": deliveries,
+	}, nil
+}
+
+func (s *Server) verifyUserAttribute(body []byte) (interface{}, error) {
+	var in cognito.VerifyUserAttributeInput
+	err := json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, newError("SerializationException", err.Error())
+	}
+
+	u, err := s.accessTokenUser(aws.StringValue(in.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(in.AttributeName)
+	code, ok := u.attributeCodes[name]
+	if !ok || aws.StringValue(in.Code) != code {
+		return nil, newError(
+			cognito.ErrCodeCodeMismatchException,
+			"Invalid verification code provided, please try again.",
+		)
+	}
+
+	delete(u.attributeCodes, name)
+	u.attributes[name+"_verified"] = "true"
+	return map[string]interface{}{}, nil
+}
+
+// accessTokenUser finds the user an access token was issued to
+func (s *Server) accessTokenUser(token string) (*user, error) {
+	invalid := newError(cognito.ErrCodeNotAuthorizedException, "Invalid Access Token")
+
+	username, ok := s.tokens[token]
+	if !ok {
+		return nil, invalid
+	}
+
+	t, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		return &s.key.PublicKey, nil
+	})
+	if err != nil || !t.Valid {
+		return nil, newError(cognito.ErrCodeNotAuthorizedException, "Access Token has expired")
+	}
+
+	u, ok := s.users[username]
+	if !ok {
+		return nil, invalid
+	}
+	return u, nil
+}
+
+func (s *Server) authenticationResult(u *user) (map[string]interface{}, error) {
+	now := time.Now()
+
+	access, err := s.sign(jwt.MapClaims{
+		"sub":       u.sub,
+		"iss":       s.Issuer(),
+		"client_id": ClientID,
+		"token_use": "access",
+		"scope":     "aws.cognito.signin.user.admin",
+		"auth_time": now.Unix(),
+		"iat":       now.Unix(),
+		"exp":       now.Add(tokenTTL).Unix(),
+		"jti":       newID(),
+		"event_id":  newID(),
+		"username":  u.username,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.sign(jwt.MapClaims{
+		"sub":                   u.sub,
+		"aud":                   ClientID,
+		"iss":                   s.Issuer(),
+		"token_use":             "id",
+		"auth_time":             now.Unix(),
+		"iat":                   now.Unix(),
+		"exp":                   now.Add(tokenTTL).Unix(),
+		"event_id":              newID(),
+		"cognito:username":      u.username,
+		"email":                 u.attributes["email"],
+		"email_verified":        u.attributes["email_verified"] == "true",
+		"phone_number":          u.attributes["phone_number"],
+		"phone_number_verified": u.attributes["phone_number_verified"] == "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.tokens[access] = u.username
+	return map[string]interface{}{
+		"AccessToken": access,
+		"IdToken":     id,
+		"ExpiresIn":   int64(tokenTTL / time.Second),
+		"TokenType":   "Bearer",
+	}, nil
+}
+
+func (s *Server) sign(claims jwt.MapClaims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = keyID
+	return t.SignedString(s.key)
+}
@@ -2,17 +2,22 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/PedPet/user/config"
 	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/service/cognitotest"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/require"
 )
 
 type needed struct {
@@ -21,6 +26,7 @@ type needed struct {
 	sess     *session.Session
 	ctx      context.Context
 	user     *model.User
+	server   *cognitotest.Server
 }
 
 func instantiateTest(t *testing.T) needed {
@@ -35,16 +41,12 @@ func instantiateTest(t *testing.T) needed {
 		)
 	}
 
-	settings, err := config.LoadSettings()
-	if err != nil {
-		t.Errorf("Failed to get settings: %v", err)
+	server := cognitotest.NewServer()
+	settings := &config.Settings{
+		Aws: server.Settings(),
 	}
 
-	conf := &aws.Config{
-		Region:      aws.String("eu-west-1"),
-		Credentials: credentials.NewStaticCredentials(settings.Aws.AccessKeyID, settings.Aws.SecretAccessKey, ""),
-	}
-	sess, err := session.NewSession(conf)
+	sess, err := server.Session()
 	if err != nil {
 		level.Error(logger).Log("exit", err)
 		os.Exit(-1)
@@ -71,15 +73,29 @@ func instantiateTest(t *testing.T) needed {
 		sess:     sess,
 		ctx:      ctx,
 		user:     user,
+		server:   server,
 	}
 }
 
+// confirmedUser registers the test user and confirms them with the code the fake pool sent
+func confirmedUser(t *testing.T, needed needed, cc CognitoClient) {
+	err := cc.Register(needed.ctx, needed.user)
+	require.NoError(t, err, "Failed to register user")
+
+	err = cc.OTP(needed.ctx, needed.user, needed.server.Code(needed.user.Username))
+	require.NoError(t, err, "Failed to confirm user")
+}
+
 func TestRegister(t *testing.T) {
 	needed := instantiateTest(t)
+	defer needed.server.Close()
 	settings := needed.settings.Aws
 
 	identity := cognito.New(needed.sess)
-	cc, err := NewCognitoClient(identity, settings, needed.logger)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
 
 	err = cc.Register(needed.ctx, needed.user)
 	if err != nil {
@@ -89,12 +105,21 @@ func TestRegister(t *testing.T) {
 
 func TestOTP(t *testing.T) {
 	needed := instantiateTest(t)
+	defer needed.server.Close()
 	settings := needed.settings.Aws
 
 	identity := cognito.New(needed.sess)
-	cc, err := NewCognitoClient(identity, settings, needed.logger)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
 
-	err = cc.OTP(needed.ctx, needed.user, "199967")
+	err = cc.Register(needed.ctx, needed.user)
+	if err != nil {
+		t.Fatalf("Failed to register user: %v", err)
+	}
+
+	err = cc.OTP(needed.ctx, needed.user, needed.server.Code(needed.user.Username))
 	if err != nil {
 		t.Errorf("Failed to confirm OTP / user: %v", err)
 	}
@@ -102,13 +127,20 @@ func TestOTP(t *testing.T) {
 
 func TestResendConfirmation(t *testing.T) {
 	needed := instantiateTest(t)
+	defer needed.server.Close()
 	settings := needed.settings.Aws
 
 	identity := cognito.New(needed.sess)
-	cc, err := NewCognitoClient(identity, settings, needed.logger)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	err = cc.Register(needed.ctx, needed.user)
 	if err != nil {
-		t.Errorf("Failed to create new cognito client: %s", err)
+		t.Fatalf("Failed to register user: %v", err)
 	}
+
 	err = cc.ResendConfirmation(needed.ctx, needed.user.Username)
 	if err != nil {
 		t.Errorf("Failed to resend confirmation: %v", err)
@@ -117,48 +149,304 @@ func TestResendConfirmation(t *testing.T) {
 
 func TestCheckUsernameTaken(t *testing.T) {
 	needed := instantiateTest(t)
+	defer needed.server.Close()
 	settings := needed.settings.Aws
 
 	identity := cognito.New(needed.sess)
-	cc, err := NewCognitoClient(identity, settings, needed.logger)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
 	if err != nil {
-		t.Errorf("Failed to create new cognito client: %s", err)
+		t.Fatalf("Failed to create new cognito client: %s", err)
 	}
+
 	taken, err := cc.CheckUsernameTaken(needed.ctx, needed.user.Username)
 	if err != nil {
 		t.Errorf("Failed to check if username is already taken: %v", err)
 	}
+	if taken {
+		t.Errorf("Username should not be taken before registering")
+	}
+
+	err = cc.Register(needed.ctx, needed.user)
+	if err != nil {
+		t.Fatalf("Failed to register user: %v", err)
+	}
+
+	taken, err = cc.CheckUsernameTaken(needed.ctx, needed.user.Username)
+	if err != nil {
+		t.Errorf("Failed to check if username is already taken: %v", err)
+	}
 
 	t.Logf("Username taken: %v", taken)
+	if !taken {
+		t.Errorf("Username should be taken after registering")
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	needed := instantiateTest(t)
+	defer needed.server.Close()
+	settings := needed.settings.Aws
+
+	identity := cognito.New(needed.sess)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	err = cc.Register(needed.ctx, needed.user)
+	if err != nil {
+		t.Fatalf("Failed to register user: %v", err)
+	}
+
+	err = cc.DeleteUser(needed.ctx, needed.user.Username)
+	if err != nil {
+		t.Errorf("Failed to delete user: %v", err)
+	}
+
+	taken, err := cc.CheckUsernameTaken(needed.ctx, needed.user.Username)
+	if err != nil {
+		t.Errorf("Failed to check if username is already taken: %v", err)
+	}
+	if taken {
+		t.Errorf("Username should not be taken after deleting the user")
+	}
+
+	err = cc.DeleteUser(needed.ctx, needed.user.Username)
+	if err != nil {
+		t.Errorf("Deleting a user that doesn't exist should succeed: %v", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	needed := instantiateTest(t)
+	defer needed.server.Close()
+	settings := needed.settings.Aws
+
+	identity := cognito.New(needed.sess)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	// More users than fit in a page
+	registered := map[string]string{}
+	for i := 0; i <= listUsersLimit; i++ {
+		user := *needed.user
+		user.Username = fmt.Sprintf("user%03d", i)
+		err = cc.Register(needed.ctx, &user)
+		if err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+		if user.Sub == "" {
+			t.Fatalf("Expected registering to set the user's sub")
+		}
+		registered[user.Username] = user.Sub
+	}
+
+	listed := map[string]string{}
+	pages := 0
+	token := ""
+	for {
+		users, next, err := cc.ListUsers(needed.ctx, token)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		pages++
+		for _, user := range users {
+			listed[user.Username] = user.Sub
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	if pages != 2 {
+		t.Errorf("Expected the users to be listed in 2 pages, got %d", pages)
+	}
+	if !reflect.DeepEqual(registered, listed) {
+		t.Errorf("Expected every registered user to be listed once with their sub, got %d users", len(listed))
+	}
 }
 
 func TestLogin(t *testing.T) {
 	needed := instantiateTest(t)
+	defer needed.server.Close()
 	settings := needed.settings.Aws
 
 	identity := cognito.New(needed.sess)
-	cc, err := NewCognitoClient(identity, settings, needed.logger)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
 	if err != nil {
-		t.Errorf("Failed to create new cognito client: %s", err)
+		t.Fatalf("Failed to create new cognito client: %s", err)
 	}
+
+	confirmedUser(t, needed, cc)
 	_, err = cc.Login(needed.ctx, needed.user.Username, needed.user.Password)
 	if err != nil {
 		t.Errorf("Failed to login: %v", err)
 	}
 
+	// The request is abandoned with the caller's context
+	ctx, cancel := context.WithCancel(needed.ctx)
+	cancel()
+	_, err = cc.Login(ctx, needed.user.Username, needed.user.Password)
+	if code := awsErrorCode(err); code != request.CanceledErrorCode {
+		t.Errorf("Expected the login to be cancelled, got %v", err)
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	needed := instantiateTest(t)
+	defer needed.server.Close()
+	settings := needed.settings.Aws
+
+	identity := cognito.New(needed.sess)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	confirmedUser(t, needed, cc)
+	auth, err := cc.Login(needed.ctx, needed.user.Username, needed.user.Password)
+	if err != nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+
+	refreshed, err := cc.RefreshSession(needed.ctx, needed.user.Username, aws.StringValue(auth.RefreshToken))
+	if err != nil {
+		t.Fatalf("Failed to refresh session: %v", err)
+	}
+	if aws.StringValue(refreshed.AccessToken) == "" {
+		t.Errorf("Refreshed session has no access token")
+	}
+
+	_, err = cc.RefreshSession(needed.ctx, needed.user.Username, "not-a-refresh-token")
+	if err == nil {
+		t.Errorf("Expected an invalid refresh token to be rejected")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	needed := instantiateTest(t)
+	defer needed.server.Close()
+	settings := needed.settings.Aws
+
+	identity := cognito.New(needed.sess)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	confirmedUser(t, needed, cc)
+	auth, err := cc.Login(needed.ctx, needed.user.Username, needed.user.Password)
+	if err != nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+
+	err = cc.RevokeToken(needed.ctx, needed.user.Username, aws.StringValue(auth.RefreshToken))
+	if err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+
+	_, err = cc.RefreshSession(needed.ctx, needed.user.Username, aws.StringValue(auth.RefreshToken))
+	if err == nil {
+		t.Errorf("Expected the revoked refresh token to be rejected")
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	needed := instantiateTest(t)
+	defer needed.server.Close()
+	settings := needed.settings.Aws
+
+	identity := cognito.New(needed.sess)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	confirmedUser(t, needed, cc)
+	err = cc.ForgotPassword(needed.ctx, needed.user.Username)
+	if err != nil {
+		t.Fatalf("Failed to start forgot password: %v", err)
+	}
+
+	newPassword := needed.user.Password + "2"
+	code := needed.server.Code(needed.user.Username)
+	err = cc.ConfirmForgotPassword(needed.ctx, needed.user.Username, code, newPassword)
+	if err != nil {
+		t.Fatalf("Failed to reset password: %v", err)
+	}
+
+	_, err = cc.Login(needed.ctx, needed.user.Username, newPassword)
+	if err != nil {
+		t.Errorf("Failed to login with new password: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	needed := instantiateTest(t)
+	defer needed.server.Close()
+	settings := needed.settings.Aws
+
+	identity := cognito.New(needed.sess)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	confirmedUser(t, needed, cc)
+	auth, err := cc.Login(needed.ctx, needed.user.Username, needed.user.Password)
+	if err != nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+	accessToken := aws.StringValue(auth.AccessToken)
+
+	newPassword := needed.user.Password + "2"
+	err = cc.ChangePassword(needed.ctx, accessToken, "wrong", newPassword)
+	if err == nil {
+		t.Errorf("Expected an incorrect old password to be rejected")
+	}
+
+	err = cc.ChangePassword(needed.ctx, accessToken, needed.user.Password, newPassword)
+	if err != nil {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+
+	err = cc.GlobalSignOut(needed.ctx, accessToken)
+	if err != nil {
+		t.Fatalf("Failed to sign out: %v", err)
+	}
+
+	_, err = cc.RefreshSession(needed.ctx, needed.user.Username, aws.StringValue(auth.RefreshToken))
+	if err == nil {
+		t.Errorf("Expected the refresh token to be revoked by the sign out")
+	}
+
+	_, err = cc.Login(needed.ctx, needed.user.Username, newPassword)
+	if err != nil {
+		t.Errorf("Failed to login with new password: %v", err)
+	}
 }
 
 func TestParseAndVerifyJWT(t *testing.T) {
 	needed := instantiateTest(t)
+	defer needed.server.Close()
 	settings := needed.settings.Aws
 
 	identity := cognito.New(needed.sess)
-	cc, err := NewCognitoClient(identity, settings, needed.logger)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	confirmedUser(t, needed, cc)
+	auth, err := cc.Login(needed.ctx, needed.user.Username, needed.user.Password)
 	if err != nil {
-		t.Errorf("Failed to create new cognito client: %s", err)
+		t.Fatalf("Failed to login: %v", err)
 	}
 
-	_, err = cc.ParseAndVerifyJWT(needed.ctx, "eyJraWQiOiJtSlpDZ21EbDVzRUNvWUtTc1FYVk8wcFF5YkZJS2RjRks4aSswdXNcLytmZz0iLCJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJiM2EzYWMyZi1mN2YwLTQwODktOTczNy0wNmUzNTc5ZGYzYTIiLCJlbWFpbF92ZXJpZmllZCI6dHJ1ZSwiaXNzIjoiaHR0cHM6XC9cL2NvZ25pdG8taWRwLmV1LXdlc3QtMS5hbWF6b25hd3MuY29tXC9ldS13ZXN0LTFfMHBrZ051bzFGIiwicGhvbmVfbnVtYmVyX3ZlcmlmaWVkIjpmYWxzZSwiY3VzdG9tOnVpZCI6IjM3YjBiNWE5LTg5NTktNGQzOC1iYzkwLWVkNTU3OTEwYzkyZiIsImNvZ25pdG86dXNlcm5hbWUiOiJTQzc2MzkiLCJhdWQiOiI0djVvOW5jZHE0NDUxc3IwMGppbzdzbWwwaCIsImV2ZW50X2lkIjoiZmZmNjZhZWYtZmFmNi00NzYyLWFkMzgtYTc2ODQzMzc0YzU3IiwidG9rZW5fdXNlIjoiaWQiLCJhdXRoX3RpbWUiOjE1ODc3NzM2ODIsInBob25lX251bWJlciI6Iis0NDc3MzM4MTQ4MDkiLCJleHAiOjE1ODc3NzcyODIsImlhdCI6MTU4Nzc3MzY4MiwiZW1haWwiOiJzY3JvdHRAZ21haWwuY29tIn0.c_V0c7kbfViuL_HFeqMO4soAqcCL7KjnZaRaxmr3FaIw00lrSP_uZXzqZnhebj0G1WzgV9aC6YlHskC3t3dbdN24U4YxrVCBEAn2pIL9NomIMIJJyl0rEtvMOcznYhKaM3D6Op97Xaae3I15mh5J47InV543Re765F-U5xG3CvMTbZo5WLJwpDfqNAzMnhKWTgbQs456hhSrvm-TYN07x_PVSfhnJoFR7LieLTTW7_xpx1S1x1PfKb4NUtdVSMHzf8wInqLAeJsaFv7V7-gUZnS_i-64P-IT-dETBprqN1GtHMR9akcSiYeDwFrwIG-S_Yo-rYu5LD6OgmvA08maVA")
+	_, err = cc.ParseAndVerifyJWT(needed.ctx, aws.StringValue(auth.IdToken))
 	if err != nil {
 		t.Errorf("Failed to parse and verify jwt: %s", err)
 	}
@@ -166,16 +454,78 @@ func TestParseAndVerifyJWT(t *testing.T) {
 
 func TestGetUserDetails(t *testing.T) {
 	needed := instantiateTest(t)
+	defer needed.server.Close()
 	settings := needed.settings.Aws
 
 	identity := cognito.New(needed.sess)
-	cc, err := NewCognitoClient(identity, settings, needed.logger)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	confirmedUser(t, needed, cc)
+	auth, err := cc.Login(needed.ctx, needed.user.Username, needed.user.Password)
 	if err != nil {
-		t.Errorf("Failed to create new cognito client: %s", err)
+		t.Fatalf("Failed to login: %v", err)
 	}
 
-	_, err = cc.GetUserDetails(needed.ctx, "eyJraWQiOiJTSmVTM2VIT1pvSFFWaXErcUlcL1lQNzVpNjhnQldQQXJmcUEwRUI4ZnZZMD0iLCJhbGciOiJSUzI1NiJ9.eyJzdWIiOiIzNTNhNzdlYi00NzI3LTQ5YTgtYjU1Yi0yODdkMGNjZTBjMjQiLCJkZXZpY2Vfa2V5IjoiZXUtd2VzdC0xXzVhMmY0ZTdmLTFiN2QtNGVhNy05MzQ4LTQ0ODIwYTllZDhiMiIsImV2ZW50X2lkIjoiNjg4MTNmMjItNTgxMi00YjA0LTlhMDEtMzc2YjQyODg3Mjk2IiwidG9rZW5fdXNlIjoiYWNjZXNzIiwic2NvcGUiOiJhd3MuY29nbml0by5zaWduaW4udXNlci5hZG1pbiIsImF1dGhfdGltZSI6MTU5MDUwMzk4OSwiaXNzIjoiaHR0cHM6XC9cL2NvZ25pdG8taWRwLmV1LXdlc3QtMS5hbWF6b25hd3MuY29tXC9ldS13ZXN0LTFfOHNyOG9EdVJLIiwiZXhwIjoxNTkwNTA3NTg5LCJpYXQiOjE1OTA1MDM5OTAsImp0aSI6IjQ4ZmMwYzdjLWNjZjAtNGZkNy05OGY2LTkyNDFiZTgxY2QwYiIsImNsaWVudF9pZCI6IjRrdXBqZGg4ZnEzdWJsMTc3YjZjMWZidWF0IiwidXNlcm5hbWUiOiJzYzc2MzkifQ.GTClCaUBf3Yi2fxZiT0Lg-HU0YoMmIlNIFI02TiPHBSCipGELw3ERaTZYPSQNd90FomcvRJlUVvbIVjGh_Z8DgAoBjXnafA0wJJh_MgeTul03vh6NAbCjc8H9AoiHj-ALuKv8Bs63WxBpXV0X6sXh72V0GGyzYbfsNV_DK9dAd7XbUyo4tqUlux3QK47IkhK0CjqjxmXAk5RDbCiYHl1yEEm-tTvq-1hJNXaluFZieUhdb8Tuo3OuAmUY6sEQEZZV3q_bxkjAWFL4vecFYWPBHALim94Clle4VIyIW5J1PcD46Ut0wIzJm9CEvQko3gmYkBI8Jtdi0P4dId9UyQkGQ")
+	_, err = cc.GetUserDetails(needed.ctx, aws.StringValue(auth.AccessToken))
 	if err != nil {
 		t.Errorf("Failed to get user details: %s", err)
 	}
 }
+
+func TestUpdateContactDetails(t *testing.T) {
+	needed := instantiateTest(t)
+	defer needed.server.Close()
+	settings := needed.settings.Aws
+
+	identity := cognito.New(needed.sess)
+	cc, err := NewCognitoClient(context.Background(), identity, settings, auth.DiscardJWKSMetrics(), needed.logger)
+	if err != nil {
+		t.Fatalf("Failed to create new cognito client: %s", err)
+	}
+
+	confirmedUser(t, needed, cc)
+	auth, err := cc.Login(needed.ctx, needed.user.Username, needed.user.Password)
+	if err != nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+	accessToken := aws.StringValue(auth.AccessToken)
+
+	deliveries, err := cc.UpdateContactDetails(needed.ctx, accessToken, "scott@example.com", "")
+	if err != nil {
+		t.Fatalf("Failed to update contact details: %v", err)
+	}
+	expected := []model.CodeDelivery{{AttributeName: "email", DeliveryMedium: "EMAIL", Destination: "s***@example.com"}}
+	if !reflect.DeepEqual(deliveries, expected) {
+		t.Errorf("Expected the code to be sent to the new email, got %+v", deliveries)
+	}
+
+	user, err := cc.GetUserDetails(needed.ctx, accessToken)
+	if err != nil {
+		t.Fatalf("Failed to get user details: %v", err)
+	}
+	if user.Email != "scott@example.com" || user.EmailVerified || !user.Confirmed {
+		t.Errorf("Expected a confirmed user with an unverified new email, got %+v", user)
+	}
+
+	err = cc.VerifyAttribute(needed.ctx, accessToken, model.AttributeEmail, "000000")
+	if err == nil {
+		t.Errorf("Expected a wrong code to be rejected")
+	}
+
+	code := needed.server.AttributeCode(needed.user.Username, model.AttributeEmail)
+	err = cc.VerifyAttribute(needed.ctx, accessToken, model.AttributeEmail, code)
+	if err != nil {
+		t.Fatalf("Failed to verify email: %v", err)
+	}
+
+	user, err = cc.GetUserDetails(needed.ctx, accessToken)
+	if err != nil {
+		t.Fatalf("Failed to get user details: %v", err)
+	}
+	if !user.EmailVerified || user.PhoneNumberVerified {
+		t.Errorf("Expected only the email to be verified, got %+v", user)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/pkg/errors"
+)
+
+// Error is a failure the caller of the service can act on. Every kind is a package level value so
+// transports can compare against them and translate them into their own status codes.
+type Error struct {
+	reason string
+}
+
+func (e *Error) Error() string {
+	return e.reason
+}
+
+// Failures returned by the service in place of the identity provider's and repository's errors
+var (
+	ErrUserNotFound     = &Error{"User does not exist"}
+	ErrUsernameExists   = &Error{"Username already exists"}
+	ErrInvalidCode      = &Error{"Invalid verification code"}
+	ErrCodeExpired      = &Error{"Verification code has expired"}
+	ErrUserNotConfirmed = &Error{"User is not confirmed"}
+	ErrWrongPassword    = &Error{"Incorrect username or password"}
+	ErrNotAuthorized    = &Error{"Not authorized"}
+	ErrTooManyAttempts  = &Error{"Too many attempts, try again later"}
+	ErrInvalidPassword  = &Error{"Password does not conform to the password policy"}
+	ErrPhoneUnsupported = &Error{"Phone numbers can't be verified by the identity provider"}
+)
+
+// errorTypes names each of the service's errors for metrics and error responses
+var errorTypes = map[error]string{
+	ErrUserNotFound:     "user_not_found",
+	ErrUsernameExists:   "username_exists",
+	ErrInvalidCode:      "invalid_code",
+	ErrCodeExpired:      "code_expired",
+	ErrUserNotConfirmed: "user_not_confirmed",
+	ErrWrongPassword:    "wrong_password",
+	ErrNotAuthorized:    "not_authorized",
+	ErrTooManyAttempts:  "too_many_attempts",
+	ErrInvalidPassword:  "invalid_password",
+	ErrPhoneUnsupported: "phone_unsupported",
+}
+
+// wrongPasswordMessage is the message both cognito and the local client give for bad credentials,
+// every other NotAuthorizedException is about a token
+const wrongPasswordMessage = "Incorrect username or password."
+
+// awsErrors maps the cognito error codes, also used by the local client, to the service's errors. Cognito
+// can't send SMS codes without an SMS role, and the local client can't send them at all.
+var awsErrors = map[string]*Error{
+	cognito.ErrCodeUserNotFoundException:               ErrUserNotFound,
+	cognito.ErrCodeUsernameExistsException:             ErrUsernameExists,
+	cognito.ErrCodeAliasExistsException:                ErrUsernameExists,
+	cognito.ErrCodeCodeMismatchException:               ErrInvalidCode,
+	cognito.ErrCodeExpiredCodeException:                ErrCodeExpired,
+	cognito.ErrCodeUserNotConfirmedException:           ErrUserNotConfirmed,
+	cognito.ErrCodeNotAuthorizedException:              ErrNotAuthorized,
+	cognito.ErrCodeTooManyRequestsException:            ErrTooManyAttempts,
+	cognito.ErrCodeTooManyFailedAttemptsException:      ErrTooManyAttempts,
+	cognito.ErrCodeLimitExceededException:              ErrTooManyAttempts,
+	cognito.ErrCodeInvalidPasswordException:            ErrInvalidPassword,
+	cognito.ErrCodeInvalidSmsRoleAccessPolicyException: ErrPhoneUnsupported,
+}
+
+// translateError replaces identity provider and repository errors with the service's errors, any
+// other error is returned as it is
+func translateError(err error) error {
+	cause := errors.Cause(err)
+	switch cause {
+	case repository.ErrUserNotFound, repository.ErrIdentityNotFound:
+		return ErrUserNotFound
+	case repository.ErrSignUpInProgress:
+		return ErrUsernameExists
+	}
+
+	awsErr, ok := cause.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	if awsErr.Code() == cognito.ErrCodeNotAuthorizedException && awsErr.Message() == wrongPasswordMessage {
+		return ErrWrongPassword
+	}
+	if serviceErr, ok := awsErrors[awsErr.Code()]; ok {
+		return serviceErr
+	}
+	return err
+}
+
+// ErrorType names the kind of error for metrics and error responses, errors the service doesn't know
+// are internal
+func ErrorType(err error) string {
+	err = translateError(err)
+	if name, ok := errorTypes[err]; ok {
+		return name
+	}
+	if _, ok := err.(*auth.TokenError); ok {
+		return "invalid_token"
+	}
+	return "internal"
+}
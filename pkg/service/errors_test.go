@@ -0,0 +1,70 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateError(t *testing.T) {
+	unknown := errors.New("Something went wrong")
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{
+			name:     "Wrong password",
+			err:      errors.Wrap(awserr.New(cognito.ErrCodeNotAuthorizedException, "Incorrect username or password.", nil), "Failed to authenticate user"),
+			expected: ErrWrongPassword,
+		},
+		{
+			name:     "Invalid token",
+			err:      awserr.New(cognito.ErrCodeNotAuthorizedException, "Invalid Access Token", nil),
+			expected: ErrNotAuthorized,
+		},
+		{
+			name:     "Username exists",
+			err:      awserr.New(cognito.ErrCodeUsernameExistsException, "User already exists", nil),
+			expected: ErrUsernameExists,
+		},
+		{
+			name:     "Too many attempts",
+			err:      awserr.New(cognito.ErrCodeLimitExceededException, "Attempt limit exceeded", nil),
+			expected: ErrTooManyAttempts,
+		},
+		{
+			name:     "Phone unsupported",
+			err:      awserr.New(cognito.ErrCodeInvalidSmsRoleAccessPolicyException, "Phone numbers can't be verified", nil),
+			expected: ErrPhoneUnsupported,
+		},
+		{
+			name:     "Repository user not found",
+			err:      errors.Wrap(repository.ErrUserNotFound, ""),
+			expected: ErrUserNotFound,
+		},
+		{
+			name:     "Sign up in progress",
+			err:      repository.ErrSignUpInProgress,
+			expected: ErrUsernameExists,
+		},
+		{
+			name:     "Unknown error",
+			err:      unknown,
+			expected: unknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, translateError(tc.err))
+		})
+	}
+}
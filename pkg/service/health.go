@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/health"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/pkg/errors"
+)
+
+// HealthChecker is an identity provider with dependencies of its own to check, such as the user pool
+// and its key set
+type HealthChecker interface {
+	HealthChecks() map[string]health.Check
+}
+
+// HealthChecks checks the user pool can be reached and its key set is fresh
+func (c *cognitoClient) HealthChecks() map[string]health.Check {
+	checks := map[string]health.Check{
+		"cognito": c.checkReachable,
+	}
+	if jwks, ok := c.verifier.Keys().(*auth.JWKS); ok {
+		checks["jwks"] = jwks.Check
+	}
+
+	return checks
+}
+
+// checkReachable describes the user pool. Any answer from cognito, even an access denied, shows it
+// can be reached, only failing to get one or a server error fails the check.
+func (c *cognitoClient) checkReachable(ctx context.Context) error {
+	_, err := c.cognitoClient.DescribeUserPoolWithContext(ctx, &cognito.DescribeUserPoolInput{
+		UserPoolId: aws.String(c.userPoolID),
+	})
+	if err == nil {
+		return nil
+	}
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() < http.StatusInternalServerError {
+		return nil
+	}
+
+	return errors.Wrap(err, "Failed to reach cognito")
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/PedPet/user/model"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/metrics"
+)
+
+// instruments records a call's count, errors and latency, labelled with the method. Errors are
+// also labelled with the kind of error.
+type instruments struct {
+	requests metrics.Counter
+	errors   metrics.Counter
+	latency  metrics.Histogram
+}
+
+func (i instruments) observe(method string, begin time.Time, err error) {
+	i.requests.With("method", method).Add(1)
+	if err != nil {
+		i.errors.With("method", method, "error", ErrorType(err)).Add(1)
+	}
+	i.latency.With("method", method).Observe(time.Since(begin).Seconds())
+}
+
+type instrumentingMiddleware struct {
+	instruments
+	next User
+}
+
+// InstrumentingMiddleware counts the calls to and errors from every method of the service and records
+// their latency in seconds. requests and latency are labelled with "method", errors with "method" and
+// "error".
+func InstrumentingMiddleware(requests, errors metrics.Counter, latency metrics.Histogram) Middleware {
+	return func(next User) User {
+		return &instrumentingMiddleware{
+			instruments: instruments{requests: requests, errors: errors, latency: latency},
+			next:        next,
+		}
+	}
+}
+
+func (mw instrumentingMiddleware) CreateUser(ctx context.Context, username, email, password string) (user *model.User, err error) {
+	defer func(begin time.Time) { mw.observe("CreateUser", begin, err) }(time.Now())
+	return mw.next.CreateUser(ctx, username, email, password)
+}
+
+func (mw instrumentingMiddleware) UserDetails(ctx context.Context, token string) (user *model.User, err error) {
+	defer func(begin time.Time) { mw.observe("UserDetails", begin, err) }(time.Now())
+	return mw.next.UserDetails(ctx, token)
+}
+
+func (mw instrumentingMiddleware) ConfirmUser(ctx context.Context, username, otp string) (err error) {
+	defer func(begin time.Time) { mw.observe("ConfirmUser", begin, err) }(time.Now())
+	return mw.next.ConfirmUser(ctx, username, otp)
+}
+
+func (mw instrumentingMiddleware) ResendConfirmation(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) { mw.observe("ResendConfirmation", begin, err) }(time.Now())
+	return mw.next.ResendConfirmation(ctx, username)
+}
+
+func (mw instrumentingMiddleware) UsernameTaken(ctx context.Context, username string) (taken bool, err error) {
+	defer func(begin time.Time) { mw.observe("UsernameTaken", begin, err) }(time.Now())
+	return mw.next.UsernameTaken(ctx, username)
+}
+
+func (mw instrumentingMiddleware) Login(ctx context.Context, username, password string) (session *model.Session, err error) {
+	defer func(begin time.Time) { mw.observe("Login", begin, err) }(time.Now())
+	return mw.next.Login(ctx, username, password)
+}
+
+func (mw instrumentingMiddleware) RefreshSession(ctx context.Context, username, refreshToken string) (session *model.Session, err error) {
+	defer func(begin time.Time) { mw.observe("RefreshSession", begin, err) }(time.Now())
+	return mw.next.RefreshSession(ctx, username, refreshToken)
+}
+
+func (mw instrumentingMiddleware) ForgotPassword(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) { mw.observe("ForgotPassword", begin, err) }(time.Now())
+	return mw.next.ForgotPassword(ctx, username)
+}
+
+func (mw instrumentingMiddleware) ConfirmForgotPassword(ctx context.Context, username, code, password string) (err error) {
+	defer func(begin time.Time) { mw.observe("ConfirmForgotPassword", begin, err) }(time.Now())
+	return mw.next.ConfirmForgotPassword(ctx, username, code, password)
+}
+
+func (mw instrumentingMiddleware) ChangePassword(
+	ctx context.Context,
+	token, oldPassword, newPassword string,
+	signOutOthers bool,
+) (session *model.Session, err error) {
+	defer func(begin time.Time) { mw.observe("ChangePassword", begin, err) }(time.Now())
+	return mw.next.ChangePassword(ctx, token, oldPassword, newPassword, signOutOthers)
+}
+
+func (mw instrumentingMiddleware) Logout(ctx context.Context, username, refreshToken string) (err error) {
+	defer func(begin time.Time) { mw.observe("Logout", begin, err) }(time.Now())
+	return mw.next.Logout(ctx, username, refreshToken)
+}
+
+func (mw instrumentingMiddleware) GlobalSignOut(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) { mw.observe("GlobalSignOut", begin, err) }(time.Now())
+	return mw.next.GlobalSignOut(ctx, token)
+}
+
+func (mw instrumentingMiddleware) VerifyJWT(ctx context.Context, token string) (claims *model.Claims, err error) {
+	defer func(begin time.Time) { mw.observe("VerifyJWT", begin, err) }(time.Now())
+	return mw.next.VerifyJWT(ctx, token)
+}
+
+func (mw instrumentingMiddleware) GetProfile(ctx context.Context, token string) (profile *model.Profile, err error) {
+	defer func(begin time.Time) { mw.observe("GetProfile", begin, err) }(time.Now())
+	return mw.next.GetProfile(ctx, token)
+}
+
+func (mw instrumentingMiddleware) UpdateProfile(
+	ctx context.Context,
+	token string,
+	profile *model.Profile,
+) (updated *model.Profile, err error) {
+	defer func(begin time.Time) { mw.observe("UpdateProfile", begin, err) }(time.Now())
+	return mw.next.UpdateProfile(ctx, token, profile)
+}
+
+func (mw instrumentingMiddleware) UpdateContactDetails(
+	ctx context.Context,
+	token, email, phoneNumber string,
+) (deliveries []model.CodeDelivery, err error) {
+	defer func(begin time.Time) { mw.observe("UpdateContactDetails", begin, err) }(time.Now())
+	return mw.next.UpdateContactDetails(ctx, token, email, phoneNumber)
+}
+
+func (mw instrumentingMiddleware) VerifyAttribute(ctx context.Context, token, attribute, code string) (err error) {
+	defer func(begin time.Time) { mw.observe("VerifyAttribute", begin, err) }(time.Now())
+	return mw.next.VerifyAttribute(ctx, token, attribute, code)
+}
+
+// CognitoMiddleware describes an identity provider client middleware
+type CognitoMiddleware func(CognitoClient) CognitoClient
+
+type instrumentingCognitoClient struct {
+	instruments
+	next CognitoClient
+}
+
+// InstrumentingCognitoMiddleware counts the calls to and errors from the identity provider and
+// records their latency in seconds, labelled like InstrumentingMiddleware
+func InstrumentingCognitoMiddleware(requests, errors metrics.Counter, latency metrics.Histogram) CognitoMiddleware {
+	return func(next CognitoClient) CognitoClient {
+		return &instrumentingCognitoClient{
+			instruments: instruments{requests: requests, errors: errors, latency: latency},
+			next:        next,
+		}
+	}
+}
+
+func (c instrumentingCognitoClient) Register(ctx context.Context, user *model.User) (err error) {
+	defer func(begin time.Time) { c.observe("Register", begin, err) }(time.Now())
+	return c.next.Register(ctx, user)
+}
+
+func (c instrumentingCognitoClient) DeleteUser(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) { c.observe("DeleteUser", begin, err) }(time.Now())
+	return c.next.DeleteUser(ctx, username)
+}
+
+func (c instrumentingCognitoClient) OTP(ctx context.Context, user *model.User, otp string) (err error) {
+	defer func(begin time.Time) { c.observe("OTP", begin, err) }(time.Now())
+	return c.next.OTP(ctx, user, otp)
+}
+
+func (c instrumentingCognitoClient) ResendConfirmation(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) { c.observe("ResendConfirmation", begin, err) }(time.Now())
+	return c.next.ResendConfirmation(ctx, username)
+}
+
+func (c instrumentingCognitoClient) CheckUsernameTaken(ctx context.Context, username string) (taken bool, err error) {
+	defer func(begin time.Time) { c.observe("CheckUsernameTaken", begin, err) }(time.Now())
+	return c.next.CheckUsernameTaken(ctx, username)
+}
+
+func (c instrumentingCognitoClient) ListUsers(
+	ctx context.Context,
+	paginationToken string,
+) (users []model.User, next string, err error) {
+	defer func(begin time.Time) { c.observe("ListUsers", begin, err) }(time.Now())
+	return c.next.ListUsers(ctx, paginationToken)
+}
+
+func (c instrumentingCognitoClient) Login(
+	ctx context.Context,
+	username, password string,
+) (auth *cognito.AuthenticationResultType, err error) {
+	defer func(begin time.Time) { c.observe("Login", begin, err) }(time.Now())
+	return c.next.Login(ctx, username, password)
+}
+
+func (c instrumentingCognitoClient) RefreshSession(
+	ctx context.Context,
+	username, refreshToken string,
+) (auth *cognito.AuthenticationResultType, err error) {
+	defer func(begin time.Time) { c.observe("RefreshSession", begin, err) }(time.Now())
+	return c.next.RefreshSession(ctx, username, refreshToken)
+}
+
+func (c instrumentingCognitoClient) ForgotPassword(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) { c.observe("ForgotPassword", begin, err) }(time.Now())
+	return c.next.ForgotPassword(ctx, username)
+}
+
+func (c instrumentingCognitoClient) ConfirmForgotPassword(ctx context.Context, username, code, password string) (err error) {
+	defer func(begin time.Time) { c.observe("ConfirmForgotPassword", begin, err) }(time.Now())
+	return c.next.ConfirmForgotPassword(ctx, username, code, password)
+}
+
+func (c instrumentingCognitoClient) ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) (err error) {
+	defer func(begin time.Time) { c.observe("ChangePassword", begin, err) }(time.Now())
+	return c.next.ChangePassword(ctx, accessToken, oldPassword, newPassword)
+}
+
+func (c instrumentingCognitoClient) GlobalSignOut(ctx context.Context, accessToken string) (err error) {
+	defer func(begin time.Time) { c.observe("GlobalSignOut", begin, err) }(time.Now())
+	return c.next.GlobalSignOut(ctx, accessToken)
+}
+
+func (c instrumentingCognitoClient) RevokeToken(ctx context.Context, username, refreshToken string) (err error) {
+	defer func(begin time.Time) { c.observe("RevokeToken", begin, err) }(time.Now())
+	return c.next.RevokeToken(ctx, username, refreshToken)
+}
+
+func (c instrumentingCognitoClient) getWellKnownJWTKs(ctx context.Context) error {
+	return c.next.getWellKnownJWTKs(ctx)
+}
+
+func (c instrumentingCognitoClient) ParseAndVerifyJWT(ctx context.Context, token string) (t *jwt.Token, err error) {
+	defer func(begin time.Time) { c.observe("ParseAndVerifyJWT", begin, err) }(time.Now())
+	return c.next.ParseAndVerifyJWT(ctx, token)
+}
+
+func (c instrumentingCognitoClient) GetUserDetails(ctx context.Context, accessToken string) (user *model.User, err error) {
+	defer func(begin time.Time) { c.observe("GetUserDetails", begin, err) }(time.Now())
+	return c.next.GetUserDetails(ctx, accessToken)
+}
+
+func (c instrumentingCognitoClient) UpdateContactDetails(
+	ctx context.Context,
+	accessToken, email, phoneNumber string,
+) (deliveries []model.CodeDelivery, err error) {
+	defer func(begin time.Time) { c.observe("UpdateContactDetails", begin, err) }(time.Now())
+	return c.next.UpdateContactDetails(ctx, accessToken, email, phoneNumber)
+}
+
+func (c instrumentingCognitoClient) VerifyAttribute(ctx context.Context, accessToken, attribute, code string) (err error) {
+	defer func(begin time.Time) { c.observe("VerifyAttribute", begin, err) }(time.Now())
+	return c.next.VerifyAttribute(ctx, accessToken, attribute, code)
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// loginStub is a service that only implements Login
+type loginStub struct {
+	User
+	err error
+}
+
+func (s loginStub) Login(ctx context.Context, username, password string) (*model.Session, error) {
+	return &model.Session{}, s.err
+}
+
+func TestInstrumentingMiddleware(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"method"})
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors_total"}, []string{"method", "error"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "request_duration_seconds"}, []string{"method"})
+	instrument := InstrumentingMiddleware(
+		kitprometheus.NewCounter(requests),
+		kitprometheus.NewCounter(errs),
+		kitprometheus.NewHistogram(latency),
+	)
+
+	ctx := context.Background()
+	instrument(loginStub{}).Login(ctx, "SC7639", "Swarleyfin1!")
+	wrongPassword := awserr.New(cognito.ErrCodeNotAuthorizedException, "Incorrect username or password.", nil)
+	instrument(loginStub{err: wrongPassword}).Login(ctx, "SC7639", "wrong")
+	instrument(loginStub{err: auth.ErrTokenExpired}).Login(ctx, "SC7639", "wrong")
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(requests.WithLabelValues("Login")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(errs.WithLabelValues("Login", "wrong_password")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(errs.WithLabelValues("Login", "invalid_token")))
+	assert.Equal(t, 1, testutil.CollectAndCount(latency))
+}
@@ -0,0 +1,871 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/auth"
+	"github.com/PedPet/user/pkg/logging"
+	"github.com/PedPet/user/pkg/mail"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gofrs/uuid"
+	"github.com/lestrrat/go-jwx/jwk"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultLocalIssuer   = "pedpet-user"
+	defaultLocalClientID = "local"
+	defaultAccessTTL     = time.Hour
+	defaultRefreshTTL    = 30 * 24 * time.Hour
+	defaultCodeTTL       = 24 * time.Hour
+	localScope           = "aws.cognito.signin.user.admin"
+	codeDigits           = 6
+	maxCodeAttempts      = 5
+	// codePurposePassword marks a code sent by ForgotPassword, it isn't a user attribute
+	codePurposePassword = "password"
+)
+
+type localClient struct {
+	repository      repository.Identity
+	mailer          mail.Mailer
+	logger          log.Logger
+	issuer          string
+	clientID        string
+	keyID           string
+	hashAlgorithm   string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	codeTTL         time.Duration
+	privateKey      *rsa.PrivateKey
+	wellKnownJWKs   *jwk.Set
+	verifier        *auth.Verifier
+}
+
+// NewLocalClient creates a CognitoClient backed by our own database instead of AWS Cognito.
+// Passwords are hashed locally, tokens are signed with a local RSA key and verification
+// codes are delivered through the mailer.
+func NewLocalClient(
+	rep repository.Identity,
+	mailer mail.Mailer,
+	cfg config.LocalSettings,
+	logger log.Logger,
+) (CognitoClient, error) {
+	c := &localClient{
+		repository:      rep,
+		mailer:          mailer,
+		logger:          logger,
+		issuer:          cfg.Issuer,
+		clientID:        cfg.ClientID,
+		keyID:           cfg.KeyID,
+		hashAlgorithm:   cfg.HashAlgorithm,
+		accessTokenTTL:  cfg.AccessTokenTTL,
+		refreshTokenTTL: cfg.RefreshTokenTTL,
+		codeTTL:         cfg.CodeTTL,
+	}
+	if c.issuer == "" {
+		c.issuer = defaultLocalIssuer
+	}
+	if c.clientID == "" {
+		c.clientID = defaultLocalClientID
+	}
+	if c.hashAlgorithm == "" {
+		c.hashAlgorithm = HashBcrypt
+	}
+	if c.accessTokenTTL == 0 {
+		c.accessTokenTTL = defaultAccessTTL
+	}
+	if c.refreshTokenTTL == 0 {
+		c.refreshTokenTTL = defaultRefreshTTL
+	}
+	if c.codeTTL == 0 {
+		c.codeTTL = defaultCodeTTL
+	}
+
+	// Fail fast on a misconfigured algorithm rather than on the first sign up
+	_, err := hashPassword(c.hashAlgorithm, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create local identity client")
+	}
+
+	c.privateKey, err = loadPrivateKey(cfg.PrivateKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create local identity client")
+	}
+	if cfg.PrivateKeyPath == "" {
+		level.Warn(logger).Log("msg", "no private key configured, generated an ephemeral signing key")
+	}
+
+	err = c.getWellKnownJWTKs(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create local identity client")
+	}
+	c.verifier = auth.NewVerifier(c.wellKnownJWKs, auth.Config{
+		Issuer:    c.issuer,
+		ClientID:  c.clientID,
+		ClockSkew: cfg.ClockSkew,
+	})
+
+	return c, nil
+}
+
+// loadPrivateKey reads a PEM encoded RSA key, or generates a throwaway key when no path is set
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate private key")
+		}
+		return key, nil
+	}
+
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read private key")
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse private key")
+	}
+
+	return key, nil
+}
+
+// getWellKnownJWTKs builds the key set from the local signing key
+func (c *localClient) getWellKnownJWTKs(ctx context.Context) error {
+	key, err := jwk.New(&c.privateKey.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create JSON web key")
+	}
+
+	if c.keyID == "" {
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return errors.Wrap(err, "Failed to create JSON web key thumbprint")
+		}
+		c.keyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	key.Set(jwk.KeyIDKey, c.keyID)
+	key.Set(jwk.AlgorithmKey, jwt.SigningMethodRS256.Alg())
+	key.Set(jwk.KeyUsageKey, string(jwk.ForSignature))
+
+	c.wellKnownJWKs = &jwk.Set{Keys: []jwk.Key{key}}
+	return nil
+}
+
+// Register stores a new unconfirmed identity and emails the user a verification code
+func (c localClient) Register(ctx context.Context, user *model.User) error {
+	logger := log.With(c.logger, "method", "Register")
+
+	_, err := c.repository.GetIdentity(ctx, user.Username)
+	if err == nil {
+		return awserr.New(cognito.ErrCodeUsernameExistsException, "User already exists", nil)
+	}
+	if err != repository.ErrIdentityNotFound {
+		return err
+	}
+
+	hash, err := hashPassword(c.hashAlgorithm, user.Password)
+	if err != nil {
+		return err
+	}
+
+	sub, err := uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate user sub")
+	}
+
+	identity := &model.Identity{
+		Sub:          sub.String(),
+		Username:     user.Username,
+		Email:        user.Email,
+		PhoneNumber:  user.PhoneNumber,
+		PasswordHash: hash,
+	}
+	code, err := c.newCode(identity)
+	if err != nil {
+		return err
+	}
+
+	err = c.repository.CreateIdentity(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	err = c.sendCode(ctx, identity, code)
+	if err != nil {
+		return err
+	}
+
+	user.Sub = identity.Sub
+	logger.Log("Register User", identity.Sub)
+	return nil
+}
+
+// DeleteUser removes the user's identity to roll back their registration, a user that doesn't exist is
+// already removed
+func (c localClient) DeleteUser(ctx context.Context, username string) error {
+	logger := log.With(c.logger, "method", "DeleteUser")
+
+	err := c.repository.DeleteIdentity(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Delete user")
+	return nil
+}
+
+// OTP handles registrations confirmation via verification code
+func (c localClient) OTP(ctx context.Context, user *model.User, otp string) error {
+	logger := log.With(c.logger, "method", "OTP")
+
+	identity, err := c.getIdentity(ctx, user.Username)
+	if err != nil {
+		return err
+	}
+
+	if identity.Confirmed {
+		return awserr.New(
+			cognito.ErrCodeNotAuthorizedException,
+			"User cannot be confirmed. Current status is CONFIRMED",
+			nil,
+		)
+	}
+
+	err = c.checkCode(ctx, identity, otp)
+	if err != nil {
+		return err
+	}
+
+	// The code was sent to the email, so it's verified too
+	identity.Confirmed = true
+	identity.EmailVerified = true
+	identity.CodeHash = ""
+	err = c.repository.UpdateIdentity(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Confirm sign up", identity.Sub)
+	return nil
+}
+
+// ResendConfirmation issues a fresh verification code and emails it to the user
+func (c localClient) ResendConfirmation(ctx context.Context, username string) error {
+	logger := log.With(c.logger, "method", "ResendConfirmation")
+
+	identity, err := c.getIdentity(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if identity.Confirmed {
+		return awserr.New(cognito.ErrCodeInvalidParameterException, "User is already confirmed.", nil)
+	}
+
+	code, err := c.newCode(identity)
+	if err != nil {
+		return err
+	}
+
+	err = c.repository.UpdateIdentity(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	err = c.sendCode(ctx, identity, code)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Resend confirmation", identity.Sub)
+	return nil
+}
+
+// CheckUsernameTaken checks to see if a username is already in use
+func (c localClient) CheckUsernameTaken(ctx context.Context, username string) (bool, error) {
+	_, err := c.repository.GetIdentity(ctx, username)
+	if err == repository.ErrIdentityNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ListUsers returns the usernames and subs of a page of the identities, the token is the last username of
+// the page and is empty after the last page
+func (c localClient) ListUsers(ctx context.Context, paginationToken string) ([]model.User, string, error) {
+	identities, err := c.repository.ListIdentities(ctx, paginationToken, listUsersLimit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	users := make([]model.User, 0, len(identities))
+	for _, identity := range identities {
+		users = append(users, model.User{Username: identity.Username, Sub: identity.Sub})
+	}
+	if len(users) < listUsersLimit {
+		return users, "", nil
+	}
+	return users, users[len(users)-1].Username, nil
+}
+
+// Login checks the user's password and issues locally signed access and id tokens
+func (c localClient) Login(ctx context.Context, username, password string) (*cognito.AuthenticationResultType, error) {
+	logger := log.With(c.logger, "method", "Login")
+
+	notAuthorized := awserr.New(cognito.ErrCodeNotAuthorizedException, "Incorrect username or password.", nil)
+
+	identity, err := c.repository.GetIdentity(ctx, username)
+	if err == repository.ErrIdentityNotFound {
+		return nil, errors.Wrap(notAuthorized, "Failed to authenticate user")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := checkPassword(identity.PasswordHash, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.Wrap(notAuthorized, "Failed to authenticate user")
+	}
+
+	if !identity.Confirmed {
+		return nil, errors.Wrap(
+			awserr.New(cognito.ErrCodeUserNotConfirmedException, "User is not confirmed.", nil),
+			"Failed to authenticate user",
+		)
+	}
+
+	result, err := c.authenticationResult(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := c.newRefreshToken(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	result.RefreshToken = aws.String(refreshToken)
+
+	logger.Log("Login", identity.Sub)
+	return result, nil
+}
+
+// RefreshSession exchanges a refresh token for new access and id tokens
+func (c localClient) RefreshSession(ctx context.Context, username, refreshToken string) (*cognito.AuthenticationResultType, error) {
+	logger := log.With(c.logger, "method", "RefreshSession")
+
+	invalid := awserr.New(cognito.ErrCodeNotAuthorizedException, "Invalid Refresh Token", nil)
+
+	token, err := c.repository.GetRefreshToken(ctx, hashCode(refreshToken))
+	if err == repository.ErrRefreshTokenNotFound {
+		return nil, errors.Wrap(invalid, "Failed to refresh session")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Revoked || token.Username != username {
+		return nil, errors.Wrap(invalid, "Failed to refresh session")
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, errors.Wrap(
+			awserr.New(cognito.ErrCodeNotAuthorizedException, "Refresh Token has expired", nil),
+			"Failed to refresh session",
+		)
+	}
+
+	identity, err := c.getIdentity(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.authenticationResult(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log("Refreshed session for", identity.Sub)
+	return result, nil
+}
+
+// authenticationResult signs a new pair of access and id tokens
+func (c localClient) authenticationResult(identity *model.Identity) (*cognito.AuthenticationResultType, error) {
+	now := time.Now()
+	accessToken, err := c.signAccessToken(identity, now)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := c.signIDToken(identity, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cognito.AuthenticationResultType{
+		AccessToken: aws.String(accessToken),
+		IdToken:     aws.String(idToken),
+		ExpiresIn:   aws.Int64(int64(c.accessTokenTTL / time.Second)),
+		TokenType:   aws.String("Bearer"),
+	}, nil
+}
+
+// newRefreshToken generates an opaque refresh token and stores its hash
+func (c localClient) newRefreshToken(ctx context.Context, identity *model.Identity) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to generate refresh token")
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(b)
+
+	err = c.repository.CreateRefreshToken(ctx, &model.RefreshToken{
+		TokenHash: hashCode(refreshToken),
+		Username:  identity.Username,
+		ExpiresAt: time.Now().Add(c.refreshTokenTTL).UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// ForgotPassword emails the user a code they can use to reset their password
+func (c localClient) ForgotPassword(ctx context.Context, username string) error {
+	logger := log.With(c.logger, "method", "ForgotPassword")
+
+	identity, err := c.getIdentity(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	// Codes are only delivered by email, so an unconfirmed user or an unverified new email can't be sent one
+	if !identity.Confirmed || !identity.EmailVerified {
+		return awserr.New(
+			cognito.ErrCodeInvalidParameterException,
+			"Cannot reset password for the user as there is no registered/verified email or phone_number",
+			nil,
+		)
+	}
+
+	code, err := c.newCode(identity)
+	if err != nil {
+		return err
+	}
+	identity.CodeAttribute = codePurposePassword
+
+	err = c.repository.UpdateIdentity(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	err = c.mailer.Send(ctx, identity.Email, "Reset your password", "Your password reset code is "+code)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Forgot password", identity.Sub)
+	return nil
+}
+
+// ConfirmForgotPassword resets the user's password using the code sent by ForgotPassword
+func (c localClient) ConfirmForgotPassword(ctx context.Context, username, code, password string) error {
+	logger := log.With(c.logger, "method", "ConfirmForgotPassword")
+
+	identity, err := c.getIdentity(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	// Only the code sent by ForgotPassword resets the password, not one for signing up or verifying an attribute
+	if identity.CodeAttribute != codePurposePassword {
+		return awserr.New(cognito.ErrCodeCodeMismatchException, "Invalid verification code provided, please try again.", nil)
+	}
+
+	err = c.checkCode(ctx, identity, code)
+	if err != nil {
+		return err
+	}
+
+	identity.PasswordHash, err = hashPassword(c.hashAlgorithm, password)
+	if err != nil {
+		return err
+	}
+
+	identity.CodeHash = ""
+	identity.CodeAttribute = ""
+	err = c.repository.UpdateIdentity(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Password reset", identity.Sub)
+	return nil
+}
+
+// ChangePassword changes the password of the user the access token belongs to
+func (c localClient) ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) error {
+	logger := log.With(c.logger, "method", "ChangePassword")
+
+	identity, err := c.accessTokenIdentity(ctx, accessToken)
+	if err != nil {
+		return errors.Wrap(err, "Failed to change password")
+	}
+
+	ok, err := checkPassword(identity.PasswordHash, oldPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Wrap(
+			awserr.New(cognito.ErrCodeNotAuthorizedException, "Incorrect username or password.", nil),
+			"Failed to change password",
+		)
+	}
+
+	identity.PasswordHash, err = hashPassword(c.hashAlgorithm, newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = c.repository.UpdateIdentity(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Password changed", identity.Sub)
+	return nil
+}
+
+// GlobalSignOut revokes every refresh token issued to the user the access token belongs to
+func (c localClient) GlobalSignOut(ctx context.Context, accessToken string) error {
+	logger := log.With(c.logger, "method", "GlobalSignOut")
+
+	identity, err := c.accessTokenIdentity(ctx, accessToken)
+	if err != nil {
+		return errors.Wrap(err, "Failed to sign out")
+	}
+
+	err = c.repository.RevokeRefreshTokens(ctx, identity.Username)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Signed out globally", identity.Sub)
+	return nil
+}
+
+// RevokeToken revokes one of the user's refresh tokens, a token issued to someone else is refused so a
+// user can't log another out
+func (c localClient) RevokeToken(ctx context.Context, username, refreshToken string) error {
+	logger := log.With(c.logger, "method", "RevokeToken")
+
+	token, err := c.repository.GetRefreshToken(ctx, hashCode(refreshToken))
+	if err == repository.ErrRefreshTokenNotFound || (err == nil && token.Username != username) {
+		return errors.Wrap(
+			awserr.New(cognito.ErrCodeNotAuthorizedException, "Invalid Refresh Token", nil),
+			"Failed to revoke token",
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = c.repository.RevokeRefreshToken(ctx, token.TokenHash)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Revoke token", username)
+	return nil
+}
+
+// ParseAndVerifyJWT verifies a token against the local signing key
+func (c localClient) ParseAndVerifyJWT(ctx context.Context, token string) (*jwt.Token, error) {
+	logger := log.With(c.logger, "method", "ParseAndVerifyJWT")
+
+	t, err := c.verifier.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log("Token is valid")
+	return t, nil
+}
+
+// GetUserDetails gets the user's attributes for the owner of the access token
+func (c localClient) GetUserDetails(ctx context.Context, accessToken string) (*model.User, error) {
+	identity, err := c.accessTokenIdentity(ctx, accessToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get user")
+	}
+
+	return &model.User{
+		Sub:                 identity.Sub,
+		Username:            identity.Username,
+		Email:               identity.Email,
+		PhoneNumber:         identity.PhoneNumber,
+		Confirmed:           identity.Confirmed,
+		EmailVerified:       identity.EmailVerified,
+		PhoneNumberVerified: identity.PhoneNumberVerified,
+	}, nil
+}
+
+// UpdateContactDetails changes the email and phone number of the user the access token belongs to, an
+// empty or unchanged value is left as it is. A changed email is unverified until the code sent to it is
+// used. Codes can only be delivered by email, so a phone number that could never be verified is refused.
+func (c localClient) UpdateContactDetails(
+	ctx context.Context,
+	accessToken, email, phoneNumber string,
+) ([]model.CodeDelivery, error) {
+	logger := log.With(c.logger, "method", "UpdateContactDetails")
+
+	identity, err := c.accessTokenIdentity(ctx, accessToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to update user attributes")
+	}
+
+	if phoneNumber != "" && phoneNumber != identity.PhoneNumber {
+		return nil, errPhoneUnsupported()
+	}
+
+	deliveries := []model.CodeDelivery{}
+	var code string
+	if email != "" && email != identity.Email {
+		identity.Email = email
+		identity.EmailVerified = false
+		code, err = c.newCode(identity)
+		if err != nil {
+			return nil, err
+		}
+		identity.CodeAttribute = model.AttributeEmail
+
+		deliveries = append(deliveries, model.CodeDelivery{
+			AttributeName:  model.AttributeEmail,
+			DeliveryMedium: cognito.DeliveryMediumTypeEmail,
+			Destination:    logging.MaskEmail(email),
+		})
+	}
+
+	err = c.repository.UpdateIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if code != "" {
+		err = c.sendCode(ctx, identity, code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Log("Update contact details", identity.Sub)
+	return deliveries, nil
+}
+
+// VerifyAttribute verifies the attribute of the user the access token belongs to with the code sent to it
+func (c localClient) VerifyAttribute(ctx context.Context, accessToken, attribute, code string) error {
+	logger := log.With(c.logger, "method", "VerifyAttribute")
+
+	identity, err := c.accessTokenIdentity(ctx, accessToken)
+	if err != nil {
+		return errors.Wrap(err, "Failed to verify user attribute")
+	}
+
+	if attribute == model.AttributePhoneNumber {
+		return errPhoneUnsupported()
+	}
+
+	// Only the code sent for the attribute verifies it, not one for signing up or resetting the password
+	if identity.CodeAttribute != attribute {
+		return awserr.New(cognito.ErrCodeCodeMismatchException, "Invalid verification code provided, please try again.", nil)
+	}
+
+	err = c.checkCode(ctx, identity, code)
+	if err != nil {
+		return err
+	}
+
+	identity.EmailVerified = true
+	identity.CodeHash = ""
+	identity.CodeAttribute = ""
+	err = c.repository.UpdateIdentity(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("Verify attribute", attribute)
+	return nil
+}
+
+// errPhoneUnsupported is the error cognito gives when it has no SMS role to send phone codes with
+func errPhoneUnsupported() error {
+	return awserr.New(
+		cognito.ErrCodeInvalidSmsRoleAccessPolicyException,
+		"Phone numbers can't be verified by the local identity provider, there's no way to send them a code",
+		nil,
+	)
+}
+
+// accessTokenIdentity verifies an access token and looks up the identity it was issued to
+func (c localClient) accessTokenIdentity(ctx context.Context, accessToken string) (*model.Identity, error) {
+	t, err := c.ParseAndVerifyJWT(ctx, accessToken)
+	if err != nil {
+		return nil, awserr.New(cognito.ErrCodeNotAuthorizedException, "Invalid Access Token", err)
+	}
+
+	claims := t.Claims.(jwt.MapClaims)
+	if claims["token_use"] != "access" {
+		return nil, awserr.New(cognito.ErrCodeNotAuthorizedException, "Invalid Access Token", nil)
+	}
+	username, _ := claims["username"].(string)
+
+	return c.getIdentity(ctx, username)
+}
+
+// getIdentity looks up an identity, translating a missing row into the cognito error code
+func (c localClient) getIdentity(ctx context.Context, username string) (*model.Identity, error) {
+	identity, err := c.repository.GetIdentity(ctx, username)
+	if err == repository.ErrIdentityNotFound {
+		return nil, awserr.New(cognito.ErrCodeUserNotFoundException, "User does not exist.", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// newCode generates a verification code and stores its hash and expiry on the identity, the code doesn't
+// verify any attribute until the caller sets one
+func (c localClient) newCode(identity *model.Identity) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to generate verification code")
+	}
+
+	code := fmt.Sprintf("%0*d", codeDigits, n.Int64())
+	identity.CodeHash = hashCode(code)
+	identity.CodeAttribute = ""
+	identity.CodeExpiresAt = time.Now().Add(c.codeTTL).UTC()
+	return code, nil
+}
+
+// checkCode compares a verification code against the identity's stored code. Every attempt is counted
+// before comparing, so a 6 digit code can't be brute forced even by guessing in parallel. Once it has had
+// maxCodeAttempts the code is refused until a new one is sent.
+func (c localClient) checkCode(ctx context.Context, identity *model.Identity, code string) error {
+	if identity.CodeHash == "" {
+		return awserr.New(cognito.ErrCodeCodeMismatchException, "Invalid verification code provided, please try again.", nil)
+	}
+
+	allowed, err := c.repository.RecordCodeAttempt(ctx, identity.Username, identity.CodeHash, maxCodeAttempts)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return awserr.New(cognito.ErrCodeLimitExceededException, "Attempt limit exceeded, please try after some time.", nil)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(identity.CodeHash), []byte(hashCode(code))) != 1 {
+		return awserr.New(cognito.ErrCodeCodeMismatchException, "Invalid verification code provided, please try again.", nil)
+	}
+
+	if time.Now().After(identity.CodeExpiresAt) {
+		return awserr.New(cognito.ErrCodeExpiredCodeException, "Invalid code provided, please request a code again.", nil)
+	}
+
+	return nil
+}
+
+func (c localClient) sendCode(ctx context.Context, identity *model.Identity, code string) error {
+	return c.mailer.Send(
+		ctx,
+		identity.Email,
+		"Your verification code",
+		"Your verification code is "+code,
+	)
+}
+
+// hashCode hashes verification codes and refresh tokens so only digests are stored
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c localClient) signAccessToken(identity *model.Identity, now time.Time) (string, error) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to generate token id")
+	}
+
+	return c.sign(jwt.MapClaims{
+		"sub":       identity.Sub,
+		"iss":       c.issuer,
+		"client_id": c.clientID,
+		"token_use": "access",
+		"scope":     localScope,
+		"auth_time": now.Unix(),
+		"iat":       now.Unix(),
+		"exp":       now.Add(c.accessTokenTTL).Unix(),
+		"jti":       jti.String(),
+		"username":  identity.Username,
+	})
+}
+
+func (c localClient) signIDToken(identity *model.Identity, now time.Time) (string, error) {
+	return c.sign(jwt.MapClaims{
+		"sub":                   identity.Sub,
+		"aud":                   c.clientID,
+		"iss":                   c.issuer,
+		"token_use":             "id",
+		"auth_time":             now.Unix(),
+		"iat":                   now.Unix(),
+		"exp":                   now.Add(c.accessTokenTTL).Unix(),
+		"cognito:username":      identity.Username,
+		"email":                 identity.Email,
+		"email_verified":        identity.EmailVerified,
+		"phone_number":          identity.PhoneNumber,
+		"phone_number_verified": identity.PhoneNumberVerified,
+	})
+}
+
+func (c localClient) sign(claims jwt.MapClaims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = c.keyID
+
+	signed, err := t.SignedString(c.privateKey)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to sign token")
+	}
+
+	return signed, nil
+}
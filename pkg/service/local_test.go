@@ -0,0 +1,460 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/bxcodec/faker/v3"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type identityRepoStub struct {
+	mu            sync.Mutex
+	identities    map[string]model.Identity
+	refreshTokens map[string]model.RefreshToken
+	// codeAttempts are the attempts at each identity's code, by username
+	codeAttempts map[string]int
+}
+
+func newIdentityRepoStub() *identityRepoStub {
+	return &identityRepoStub{
+		identities:    map[string]model.Identity{},
+		refreshTokens: map[string]model.RefreshToken{},
+		codeAttempts:  map[string]int{},
+	}
+}
+
+func (r *identityRepoStub) CreateIdentity(ctx context.Context, identity *model.Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity.ID = len(r.identities) + 1
+	r.identities[identity.Username] = *identity
+	return nil
+}
+
+func (r *identityRepoStub) GetIdentity(ctx context.Context, username string) (*model.Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity, ok := r.identities[username]
+	if !ok {
+		return nil, repository.ErrIdentityNotFound
+	}
+	return &identity, nil
+}
+
+func (r *identityRepoStub) UpdateIdentity(ctx context.Context, identity *model.Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.identities[identity.Username].CodeHash != identity.CodeHash {
+		r.codeAttempts[identity.Username] = 0
+	}
+	r.identities[identity.Username] = *identity
+	return nil
+}
+
+func (r *identityRepoStub) RecordCodeAttempt(ctx context.Context, username, codeHash string, limit int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.identities[username].CodeHash != codeHash || r.codeAttempts[username] >= limit {
+		return false, nil
+	}
+	r.codeAttempts[username]++
+	return true, nil
+}
+
+func (r *identityRepoStub) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.ID = len(r.refreshTokens) + 1
+	r.refreshTokens[token.TokenHash] = *token
+	return nil
+}
+
+func (r *identityRepoStub) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[tokenHash]
+	if !ok {
+		return nil, repository.ErrRefreshTokenNotFound
+	}
+	return &token, nil
+}
+
+func (r *identityRepoStub) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[tokenHash]
+	if ok {
+		token.Revoked = true
+		r.refreshTokens[tokenHash] = token
+	}
+	return nil
+}
+
+func (r *identityRepoStub) RevokeRefreshTokens(ctx context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, token := range r.refreshTokens {
+		if token.Username == username {
+			token.Revoked = true
+			r.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (r *identityRepoStub) ListIdentities(ctx context.Context, after string, limit int) ([]model.Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identities := []model.Identity{}
+	for username, identity := range r.identities {
+		if username > after {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Username < identities[j].Username })
+	if len(identities) > limit {
+		identities = identities[:limit]
+	}
+	return identities, nil
+}
+
+func (r *identityRepoStub) DeleteIdentity(ctx context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.identities, username)
+	for hash, token := range r.refreshTokens {
+		if token.Username == username {
+			delete(r.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+type mailerStub struct {
+	mu   sync.Mutex
+	sent map[string]string
+}
+
+func (m *mailerStub) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent[to] = body
+	return nil
+}
+
+// code pulls the last verification code emailed to an address
+func (m *mailerStub) code(to string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	body := m.sent[to]
+	return body[strings.LastIndex(body, " ")+1:]
+}
+
+func newLocalTestClient(t *testing.T, algorithm string) (CognitoClient, *mailerStub) {
+	mailer := &mailerStub{sent: map[string]string{}}
+	cc, err := NewLocalClient(newIdentityRepoStub(), mailer, config.LocalSettings{
+		HashAlgorithm: algorithm,
+	}, log.NewNopLogger())
+	require.NoError(t, err)
+
+	return cc, mailer
+}
+
+func awsErrorCode(err error) string {
+	if err, ok := errors.Cause(err).(awserr.Error); ok {
+		return err.Code()
+	}
+	return ""
+}
+
+func TestLocalClientSignUpAndLogin(t *testing.T) {
+	for _, algorithm := range []string{HashBcrypt, HashArgon2id} {
+		algorithm := algorithm
+		t.Run(algorithm, func(t *testing.T) {
+			ctx := context.Background()
+			cc, mailer := newLocalTestClient(t, algorithm)
+			user := &model.User{
+				Username:    faker.Username(),
+				Email:       "scrott@gmail.com",
+				Password:    faker.Password() + "1!",
+				PhoneNumber: "+447733814809",
+			}
+
+			require.NoError(t, cc.Register(ctx, user))
+
+			taken, err := cc.CheckUsernameTaken(ctx, user.Username)
+			require.NoError(t, err)
+			assert.True(t, taken)
+
+			err = cc.Register(ctx, user)
+			assert.Equal(t, cognito.ErrCodeUsernameExistsException, awsErrorCode(err))
+
+			_, err = cc.Login(ctx, user.Username, user.Password)
+			assert.Equal(t, cognito.ErrCodeUserNotConfirmedException, awsErrorCode(err))
+
+			err = cc.OTP(ctx, user, "000000x")
+			assert.Equal(t, cognito.ErrCodeCodeMismatchException, awsErrorCode(err))
+
+			require.NoError(t, cc.OTP(ctx, user, mailer.code(user.Email)))
+
+			_, err = cc.Login(ctx, user.Username, "wrong")
+			assert.Equal(t, cognito.ErrCodeNotAuthorizedException, awsErrorCode(err))
+
+			auth, err := cc.Login(ctx, user.Username, user.Password)
+			require.NoError(t, err)
+
+			token, err := cc.ParseAndVerifyJWT(ctx, aws.StringValue(auth.AccessToken))
+			require.NoError(t, err)
+			assert.Equal(t, user.Username, token.Claims.(jwt.MapClaims)["username"])
+
+			details, err := cc.GetUserDetails(ctx, aws.StringValue(auth.AccessToken))
+			require.NoError(t, err)
+			assert.Equal(t, user.Email, details.Email)
+			assert.True(t, details.Confirmed)
+			assert.NotEmpty(t, user.Sub)
+			assert.Equal(t, user.Sub, details.Sub)
+
+			refreshed, err := cc.RefreshSession(ctx, user.Username, aws.StringValue(auth.RefreshToken))
+			require.NoError(t, err)
+			assert.NotEmpty(t, aws.StringValue(refreshed.AccessToken))
+			assert.Nil(t, refreshed.RefreshToken)
+
+			_, err = cc.RefreshSession(ctx, "someoneelse", aws.StringValue(auth.RefreshToken))
+			assert.Equal(t, cognito.ErrCodeNotAuthorizedException, awsErrorCode(err))
+		})
+	}
+}
+
+func TestLocalClientForgotPassword(t *testing.T) {
+	ctx := context.Background()
+	cc, mailer := newLocalTestClient(t, HashBcrypt)
+	user := &model.User{
+		Username: faker.Username(),
+		Email:    "scrott@gmail.com",
+		Password: faker.Password() + "1!",
+	}
+
+	require.NoError(t, cc.Register(ctx, user))
+
+	err := cc.ForgotPassword(ctx, user.Username)
+	assert.Equal(t, cognito.ErrCodeInvalidParameterException, awsErrorCode(err))
+
+	require.NoError(t, cc.OTP(ctx, user, mailer.code(user.Email)))
+	require.NoError(t, cc.ForgotPassword(ctx, user.Username))
+
+	newPassword := faker.Password() + "2@"
+	err = cc.ConfirmForgotPassword(ctx, user.Username, "999999x", newPassword)
+	assert.Equal(t, cognito.ErrCodeCodeMismatchException, awsErrorCode(err))
+
+	require.NoError(t, cc.ConfirmForgotPassword(ctx, user.Username, mailer.code(user.Email), newPassword))
+
+	_, err = cc.Login(ctx, user.Username, user.Password)
+	assert.Equal(t, cognito.ErrCodeNotAuthorizedException, awsErrorCode(err))
+
+	result, err := cc.Login(ctx, user.Username, newPassword)
+	require.NoError(t, err)
+
+	// A code sent to verify a new email doesn't reset the password
+	_, err = cc.UpdateContactDetails(ctx, *result.AccessToken, "scott@example.com", "")
+	require.NoError(t, err)
+	err = cc.ConfirmForgotPassword(ctx, user.Username, mailer.code("scott@example.com"), faker.Password()+"3#")
+	assert.Equal(t, cognito.ErrCodeCodeMismatchException, awsErrorCode(err))
+
+	// Nor can a code be sent to the new email before it's verified
+	err = cc.ForgotPassword(ctx, user.Username)
+	assert.Equal(t, cognito.ErrCodeInvalidParameterException, awsErrorCode(err))
+}
+
+func TestLocalClientCodeAttempts(t *testing.T) {
+	ctx := context.Background()
+	cc, mailer := newLocalTestClient(t, HashBcrypt)
+	user := &model.User{
+		Username: faker.Username(),
+		Email:    "scrott@gmail.com",
+		Password: faker.Password() + "1!",
+	}
+
+	require.NoError(t, cc.Register(ctx, user))
+	code := mailer.code(user.Email)
+
+	for i := 0; i < maxCodeAttempts; i++ {
+		err := cc.OTP(ctx, user, "999999x")
+		assert.Equal(t, cognito.ErrCodeCodeMismatchException, awsErrorCode(err))
+	}
+
+	err := cc.OTP(ctx, user, code)
+	assert.Equal(t, cognito.ErrCodeLimitExceededException, awsErrorCode(err), "Expected the code to be refused")
+
+	require.NoError(t, cc.ResendConfirmation(ctx, user.Username))
+	assert.NoError(t, cc.OTP(ctx, user, mailer.code(user.Email)))
+}
+
+func TestLocalClientConcurrentCodeAttempts(t *testing.T) {
+	ctx := context.Background()
+	cc, mailer := newLocalTestClient(t, HashBcrypt)
+	user := &model.User{
+		Username: faker.Username(),
+		Email:    "scrott@gmail.com",
+		Password: faker.Password() + "1!",
+	}
+
+	require.NoError(t, cc.Register(ctx, user))
+	require.NoError(t, cc.OTP(ctx, user, mailer.code(user.Email)))
+	require.NoError(t, cc.ForgotPassword(ctx, user.Username))
+
+	var mu sync.Mutex
+	results := map[string]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := cc.ConfirmForgotPassword(ctx, user.Username, fmt.Sprintf("%06d", i), faker.Password()+"2@")
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[awsErrorCode(err)]++
+		}(i)
+	}
+	wg.Wait()
+
+	// Each guess may have got the right code, what matters is how many were checked
+	assert.Equal(t, maxCodeAttempts, 50-results[cognito.ErrCodeLimitExceededException], "Expected only %d guesses to be checked", maxCodeAttempts)
+}
+
+func TestLocalClientChangePassword(t *testing.T) {
+	ctx := context.Background()
+	cc, mailer := newLocalTestClient(t, HashArgon2id)
+	user := &model.User{
+		Username: faker.Username(),
+		Email:    "scrott@gmail.com",
+		Password: faker.Password() + "1!",
+	}
+
+	require.NoError(t, cc.Register(ctx, user))
+	require.NoError(t, cc.OTP(ctx, user, mailer.code(user.Email)))
+	auth, err := cc.Login(ctx, user.Username, user.Password)
+	require.NoError(t, err)
+	accessToken := aws.StringValue(auth.AccessToken)
+
+	newPassword := faker.Password() + "2@"
+	err = cc.ChangePassword(ctx, accessToken, "wrong", newPassword)
+	assert.Equal(t, cognito.ErrCodeNotAuthorizedException, awsErrorCode(err))
+
+	require.NoError(t, cc.ChangePassword(ctx, accessToken, user.Password, newPassword))
+
+	_, err = cc.Login(ctx, user.Username, user.Password)
+	assert.Equal(t, cognito.ErrCodeNotAuthorizedException, awsErrorCode(err))
+
+	_, err = cc.Login(ctx, user.Username, newPassword)
+	require.NoError(t, err)
+
+	require.NoError(t, cc.GlobalSignOut(ctx, accessToken))
+
+	_, err = cc.RefreshSession(ctx, user.Username, aws.StringValue(auth.RefreshToken))
+	assert.Equal(t, cognito.ErrCodeNotAuthorizedException, awsErrorCode(err))
+}
+
+func TestLocalClientRejectsForeignToken(t *testing.T) {
+	ctx := context.Background()
+	cc, mailer := newLocalTestClient(t, HashBcrypt)
+	other, _ := newLocalTestClient(t, HashBcrypt)
+	user := &model.User{
+		Username: faker.Username(),
+		Email:    "scrott@gmail.com",
+		Password: faker.Password() + "1!",
+	}
+
+	require.NoError(t, cc.Register(ctx, user))
+	require.NoError(t, cc.OTP(ctx, user, mailer.code(user.Email)))
+	auth, err := cc.Login(ctx, user.Username, user.Password)
+	require.NoError(t, err)
+
+	_, err = other.ParseAndVerifyJWT(ctx, aws.StringValue(auth.AccessToken))
+	assert.Error(t, err)
+}
+
+func TestLocalClientUpdateContactDetails(t *testing.T) {
+	ctx := context.Background()
+	cc, mailer := newLocalTestClient(t, HashBcrypt)
+	user := &model.User{
+		Username:    faker.Username(),
+		Email:       "scrott@gmail.com",
+		Password:    faker.Password() + "1!",
+		PhoneNumber: "+447733814809",
+	}
+
+	require.NoError(t, cc.Register(ctx, user))
+	require.NoError(t, cc.OTP(ctx, user, mailer.code(user.Email)))
+	auth, err := cc.Login(ctx, user.Username, user.Password)
+	require.NoError(t, err)
+	accessToken := aws.StringValue(auth.AccessToken)
+
+	details, err := cc.GetUserDetails(ctx, accessToken)
+	require.NoError(t, err)
+	assert.True(t, details.EmailVerified, "Expected confirming the sign up to verify the email")
+	assert.False(t, details.PhoneNumberVerified)
+
+	// There's no way to send a phone number a code, so changing it is refused
+	_, err = cc.UpdateContactDetails(ctx, accessToken, "scott@example.com", "+447700900123")
+	assert.Equal(t, cognito.ErrCodeInvalidSmsRoleAccessPolicyException, awsErrorCode(err))
+	assert.Equal(t, ErrPhoneUnsupported, translateError(err))
+
+	details, err = cc.GetUserDetails(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, user.Email, details.Email, "Expected nothing to change")
+
+	deliveries, err := cc.UpdateContactDetails(ctx, accessToken, "scott@example.com", user.PhoneNumber)
+	require.NoError(t, err)
+	assert.Equal(t, []model.CodeDelivery{
+		{AttributeName: model.AttributeEmail, DeliveryMedium: cognito.DeliveryMediumTypeEmail, Destination: "s***@example.com"},
+	}, deliveries)
+
+	details, err = cc.GetUserDetails(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "scott@example.com", details.Email)
+	assert.Equal(t, user.PhoneNumber, details.PhoneNumber)
+	assert.True(t, details.Confirmed)
+	assert.False(t, details.EmailVerified)
+
+	err = cc.VerifyAttribute(ctx, accessToken, model.AttributePhoneNumber, mailer.code("scott@example.com"))
+	assert.Equal(t, ErrPhoneUnsupported, translateError(err))
+
+	require.NoError(t, cc.VerifyAttribute(ctx, accessToken, model.AttributeEmail, mailer.code("scott@example.com")))
+
+	details, err = cc.GetUserDetails(ctx, accessToken)
+	require.NoError(t, err)
+	assert.True(t, details.EmailVerified)
+
+	// A password reset code doesn't verify an attribute
+	require.NoError(t, cc.ForgotPassword(ctx, user.Username))
+	err = cc.VerifyAttribute(ctx, accessToken, model.AttributeEmail, mailer.code("scott@example.com"))
+	assert.Equal(t, cognito.ErrCodeCodeMismatchException, awsErrorCode(err))
+}
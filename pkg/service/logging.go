@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/logging"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Middleware describes a service middleware
+type Middleware func(User) User
+
+type loggingMiddleware struct {
+	next   User
+	logger log.Logger
+}
+
+// LoggingMiddleware logs every call to the service with its duration, outcome and request id.
+// Only usernames are logged from the arguments, never passwords, tokens or codes.
+func LoggingMiddleware(logger log.Logger) Middleware {
+	return func(next User) User {
+		return &loggingMiddleware{
+			next:   next,
+			logger: logger,
+		}
+	}
+}
+
+func (mw loggingMiddleware) log(ctx context.Context, method string, begin time.Time, err error, keyvals ...interface{}) {
+	logger := log.With(mw.logger,
+		"method", method,
+		"request_id", logging.RequestID(ctx),
+		"took", time.Since(begin),
+	)
+	if err != nil {
+		level.Error(logger).Log(append(keyvals, "outcome", "failure", "err", err)...)
+		return
+	}
+
+	level.Info(logger).Log(append(keyvals, "outcome", "success")...)
+}
+
+func (mw loggingMiddleware) CreateUser(ctx context.Context, username, email, password string) (user *model.User, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "CreateUser", begin, err, "username", username, "email", logging.MaskEmail(email))
+	}(time.Now())
+	return mw.next.CreateUser(ctx, username, email, password)
+}
+
+func (mw loggingMiddleware) UserDetails(ctx context.Context, token string) (user *model.User, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "UserDetails", begin, err)
+	}(time.Now())
+	return mw.next.UserDetails(ctx, token)
+}
+
+func (mw loggingMiddleware) ConfirmUser(ctx context.Context, username, otp string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ConfirmUser", begin, err, "username", username)
+	}(time.Now())
+	return mw.next.ConfirmUser(ctx, username, otp)
+}
+
+func (mw loggingMiddleware) ResendConfirmation(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ResendConfirmation", begin, err, "username", username)
+	}(time.Now())
+	return mw.next.ResendConfirmation(ctx, username)
+}
+
+func (mw loggingMiddleware) UsernameTaken(ctx context.Context, username string) (taken bool, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "UsernameTaken", begin, err, "username", username, "taken", taken)
+	}(time.Now())
+	return mw.next.UsernameTaken(ctx, username)
+}
+
+func (mw loggingMiddleware) Login(ctx context.Context, username, password string) (session *model.Session, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Login", begin, err, "username", username)
+	}(time.Now())
+	return mw.next.Login(ctx, username, password)
+}
+
+func (mw loggingMiddleware) RefreshSession(ctx context.Context, username, refreshToken string) (session *model.Session, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "RefreshSession", begin, err, "username", username)
+	}(time.Now())
+	return mw.next.RefreshSession(ctx, username, refreshToken)
+}
+
+func (mw loggingMiddleware) ForgotPassword(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ForgotPassword", begin, err, "username", username)
+	}(time.Now())
+	return mw.next.ForgotPassword(ctx, username)
+}
+
+func (mw loggingMiddleware) ConfirmForgotPassword(ctx context.Context, username, code, password string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ConfirmForgotPassword", begin, err, "username", username)
+	}(time.Now())
+	return mw.next.ConfirmForgotPassword(ctx, username, code, password)
+}
+
+func (mw loggingMiddleware) ChangePassword(
+	ctx context.Context,
+	token, oldPassword, newPassword string,
+	signOutOthers bool,
+) (session *model.Session, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "ChangePassword", begin, err, "sign_out_others", signOutOthers)
+	}(time.Now())
+	return mw.next.ChangePassword(ctx, token, oldPassword, newPassword, signOutOthers)
+}
+
+func (mw loggingMiddleware) Logout(ctx context.Context, username, refreshToken string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "Logout", begin, err, "username", username)
+	}(time.Now())
+	return mw.next.Logout(ctx, username, refreshToken)
+}
+
+func (mw loggingMiddleware) GlobalSignOut(ctx context.Context, token string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "GlobalSignOut", begin, err)
+	}(time.Now())
+	return mw.next.GlobalSignOut(ctx, token)
+}
+
+func (mw loggingMiddleware) VerifyJWT(ctx context.Context, token string) (claims *model.Claims, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "VerifyJWT", begin, err)
+	}(time.Now())
+	return mw.next.VerifyJWT(ctx, token)
+}
+
+func (mw loggingMiddleware) GetProfile(ctx context.Context, token string) (profile *model.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "GetProfile", begin, err)
+	}(time.Now())
+	return mw.next.GetProfile(ctx, token)
+}
+
+func (mw loggingMiddleware) UpdateProfile(
+	ctx context.Context,
+	token string,
+	profile *model.Profile,
+) (updated *model.Profile, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "UpdateProfile", begin, err)
+	}(time.Now())
+	return mw.next.UpdateProfile(ctx, token, profile)
+}
+
+func (mw loggingMiddleware) UpdateContactDetails(
+	ctx context.Context,
+	token, email, phoneNumber string,
+) (deliveries []model.CodeDelivery, err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "UpdateContactDetails", begin, err,
+			"email", logging.MaskEmail(email), "phone_number", logging.MaskPhone(phoneNumber))
+	}(time.Now())
+	return mw.next.UpdateContactDetails(ctx, token, email, phoneNumber)
+}
+
+func (mw loggingMiddleware) VerifyAttribute(ctx context.Context, token, attribute, code string) (err error) {
+	defer func(begin time.Time) {
+		mw.log(ctx, "VerifyAttribute", begin, err, "attribute", attribute)
+	}(time.Now())
+	return mw.next.VerifyAttribute(ctx, token, attribute, code)
+}
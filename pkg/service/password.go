@@ -0,0 +1,104 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms supported by the local identity provider
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+var errUnknownHash = errors.New("Unknown password hash format")
+
+// hashPassword hashes a password with the given algorithm. The result is self describing
+// so checkPassword can verify it regardless of the currently configured algorithm.
+func hashPassword(algorithm, password string) (string, error) {
+	switch algorithm {
+	case HashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", errors.Wrap(err, "Failed to generate salt")
+		}
+
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf(
+			"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case HashBcrypt, "":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", errors.Wrap(err, "Failed to hash password")
+		}
+
+		return string(hash), nil
+	default:
+		return "", errors.Errorf("Unsupported password hash algorithm %q", algorithm)
+	}
+}
+
+// checkPassword reports whether password matches the stored hash
+func checkPassword(hash, password string) (bool, error) {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "Failed to compare password hash")
+		}
+
+		return true, nil
+	}
+
+	// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errUnknownHash
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false, errUnknownHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil {
+		return false, errUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, errUnknownHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
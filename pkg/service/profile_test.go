@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/bxcodec/faker/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type profileRepoStub struct {
+	mu       sync.Mutex
+	profiles map[int]model.Profile
+}
+
+func (r *profileRepoStub) GetProfile(ctx context.Context, userID int) (*model.Profile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	profile, ok := r.profiles[userID]
+	if !ok {
+		return nil, repository.ErrProfileNotFound
+	}
+	return &profile, nil
+}
+
+func (r *profileRepoStub) UpdateProfile(ctx context.Context, profile *model.Profile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.profiles[profile.UserID] = *profile
+	return nil
+}
+
+func TestProfile(t *testing.T) {
+	st := newSignUpTest(t)
+	ctx := context.Background()
+	username := faker.Username()
+
+	user, err := st.service.CreateUser(ctx, username, "scrott@gmail.com", "Swarleyfin1!")
+	require.NoError(t, err)
+	require.NoError(t, st.service.ConfirmUser(ctx, username, st.mailer.code("scrott@gmail.com")))
+	session, err := st.service.Login(ctx, username, "Swarleyfin1!")
+	require.NoError(t, err)
+
+	profile, err := st.service.GetProfile(ctx, session.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, &model.Profile{UserID: user.ID}, profile, "Expected an empty profile until one is stored")
+
+	dateOfBirth := time.Date(1990, time.March, 14, 0, 0, 0, 0, time.UTC)
+	updated, err := st.service.UpdateProfile(ctx, session.AccessToken, &model.Profile{
+		FirstName:   "Scott",
+		LastName:    "Crott",
+		DisplayName: "scrott",
+		Locale:      "en-GB",
+		Timezone:    "Europe/London",
+		DateOfBirth: dateOfBirth,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, updated.UserID)
+	assert.False(t, updated.UpdatedAt.IsZero())
+
+	profile, err = st.service.GetProfile(ctx, session.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, updated, profile)
+
+	_, err = st.service.UpdateProfile(ctx, "not a token", &model.Profile{})
+	assert.Error(t, err)
+	assert.Len(t, st.profiles.profiles, 1, "Expected only the caller's profile to be stored")
+}
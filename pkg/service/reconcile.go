@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// listUsersPage is the most users read from the users table at a time
+const listUsersPage = 500
+
+// UsernameMismatch is a stored user whose username differs from the identity provider's only by case
+type UsernameMismatch struct {
+	ID       int    `json:"id"`
+	Stored   string `json:"stored"`
+	Identity string `json:"identity"`
+}
+
+// SubMismatch is a stored user not linked to the identity provider's sub, Stored is empty for rows
+// stored before subs were kept
+type SubMismatch struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Stored   string `json:"stored"`
+	Identity string `json:"identity"`
+}
+
+// ReconcileReport is what a reconciliation found. MissingRows are users of the identity provider
+// without a row, OrphanedRows are rows without a user. InProgress are usernames left to the sign up
+// recovery. Fixed counts the differences fixed, none in a dry run. SubsOnly reports only rows not linked
+// to their sub, as LinkSubs does.
+type ReconcileReport struct {
+	DryRun        bool               `json:"dryRun"`
+	SubsOnly      bool               `json:"subsOnly"`
+	IdentityUsers int                `json:"identityUsers"`
+	StoredUsers   int                `json:"storedUsers"`
+	MissingRows   []string           `json:"missingRows"`
+	OrphanedRows  []model.User       `json:"orphanedRows"`
+	Mismatches    []UsernameMismatch `json:"mismatches"`
+	SubMismatches []SubMismatch      `json:"subMismatches"`
+	InProgress    []string           `json:"inProgress"`
+	Fixed         int                `json:"fixed"`
+	Errors        []string           `json:"errors"`
+}
+
+// Differences is the number of differences found
+func (r *ReconcileReport) Differences() int {
+	return len(r.MissingRows) + len(r.OrphanedRows) + len(r.Mismatches) + len(r.SubMismatches)
+}
+
+// Reconciler compares the users table with the identity provider's users, which drift apart when
+// users are deleted from the console, created outside the service or an insert fails
+type Reconciler struct {
+	users    repository.User
+	signUps  repository.SignUp
+	cognito  CognitoClient
+	interval time.Duration
+	fix      bool
+	logger   log.Logger
+}
+
+// NewReconciler creates the reconciliation of the users table, settings says how often Run reconciles
+// and whether it fixes the differences
+func NewReconciler(
+	users repository.User,
+	signUps repository.SignUp,
+	cognito CognitoClient,
+	settings config.ReconcileSettings,
+	logger log.Logger,
+) *Reconciler {
+	return &Reconciler{
+		users:    users,
+		signUps:  signUps,
+		cognito:  cognito,
+		interval: settings.Interval,
+		fix:      settings.Fix,
+		logger:   log.With(logger, "component", "reconcile"),
+	}
+}
+
+// Run reconciles every interval until ctx is done, it returns straight away when no interval is set
+func (r *Reconciler) Run(ctx context.Context) {
+	if r.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := r.Reconcile(ctx, !r.fix)
+		if err != nil {
+			level.Error(r.logger).Log("err", err)
+			continue
+		}
+
+		logger := level.Info(r.logger)
+		if report.Differences() > 0 || len(report.Errors) > 0 {
+			logger = level.Warn(r.logger)
+		}
+		logger.Log(
+			"msg", "reconciled users",
+			"dry_run", report.DryRun,
+			"identity_users", report.IdentityUsers,
+			"stored_users", report.StoredUsers,
+			"missing_rows", len(report.MissingRows),
+			"orphaned_rows", len(report.OrphanedRows),
+			"mismatches", len(report.Mismatches),
+			"sub_mismatches", len(report.SubMismatches),
+			"in_progress", len(report.InProgress),
+			"fixed", report.Fixed,
+			"errors", len(report.Errors),
+		)
+	}
+}
+
+// Reconcile finds the differences between the users table and the identity provider. Missing and
+// orphaned rows are checked again before they're reported, so users signing up meanwhile aren't
+// mistaken for differences. The differences are fixed unless dryRun is set: missing rows are created,
+// orphaned rows deleted, mismatched usernames changed to the identity provider's and rows without a sub
+// linked to it. A row linked to another sub is left, the username was reused after its user was deleted.
+// Failing to check or fix a difference is recorded in the report.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	return r.reconcile(ctx, dryRun, false)
+}
+
+// LinkSubs links the rows without a sub to the identity provider's user of the same username, unless
+// dryRun is set. Unlike Reconcile no row is created, deleted or renamed, so it's safe to backfill the
+// subs of a users table that has drifted from the identity provider.
+func (r *Reconciler) LinkSubs(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	return r.reconcile(ctx, dryRun, true)
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, dryRun, subsOnly bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		DryRun:        dryRun,
+		SubsOnly:      subsOnly,
+		MissingRows:   []string{},
+		OrphanedRows:  []model.User{},
+		Mismatches:    []UsernameMismatch{},
+		SubMismatches: []SubMismatch{},
+		InProgress:    []string{},
+		Errors:        []string{},
+	}
+
+	var rows []model.User
+	afterID := 0
+	for {
+		users, err := r.users.ListUsers(ctx, afterID, listUsersPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			rows = append(rows, user)
+			afterID = user.ID
+		}
+		if len(users) < listUsersPage {
+			break
+		}
+	}
+	report.StoredUsers = len(rows)
+
+	// Users of the identity provider, true once a row has matched them, and their subs
+	identities := map[string]bool{}
+	subs := map[string]string{}
+	token := ""
+	for {
+		users, next, err := r.cognito.ListUsers(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			identities[user.Username] = false
+			subs[user.Username] = user.Sub
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	report.IdentityUsers = len(identities)
+
+	// Rows matching a user exactly first, the rows left are compared ignoring case as the identity
+	// provider may not consider it. A second row of a username is a duplicate and left as an orphan.
+	unmatched := map[string][]model.User{}
+	for _, row := range rows {
+		if matched, ok := identities[row.Username]; ok && !matched {
+			identities[row.Username] = true
+			r.reconcileSub(ctx, report, row, subs[row.Username])
+			continue
+		}
+		key := strings.ToLower(row.Username)
+		unmatched[key] = append(unmatched[key], row)
+	}
+	if subsOnly {
+		return report, nil
+	}
+
+	usernames := make([]string, 0, len(identities))
+	for username, matched := range identities {
+		if !matched {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		key := strings.ToLower(username)
+		if candidates := unmatched[key]; len(candidates) > 0 {
+			unmatched[key] = candidates[1:]
+			r.reconcileMismatch(ctx, report, candidates[0], username)
+			r.reconcileSub(ctx, report, candidates[0], subs[username])
+			continue
+		}
+		r.reconcileMissing(ctx, report, username, subs[username])
+	}
+
+	orphans := []model.User{}
+	for _, candidates := range unmatched {
+		orphans = append(orphans, candidates...)
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].ID < orphans[j].ID })
+	for _, row := range orphans {
+		_, duplicate := identities[row.Username]
+		r.reconcileOrphan(ctx, report, row, duplicate)
+	}
+
+	return report, nil
+}
+
+// reconcileMismatch changes a row's username to the identity provider's
+func (r *Reconciler) reconcileMismatch(ctx context.Context, report *ReconcileReport, row model.User, username string) {
+	report.Mismatches = append(report.Mismatches, UsernameMismatch{ID: row.ID, Stored: row.Username, Identity: username})
+	if !report.DryRun {
+		r.record(report, username, r.users.UpdateUsername(ctx, row.ID, username))
+	}
+}
+
+// reconcileSub links a row to the identity provider's sub
+func (r *Reconciler) reconcileSub(ctx context.Context, report *ReconcileReport, row model.User, sub string) {
+	if row.Sub == sub || sub == "" {
+		return
+	}
+
+	report.SubMismatches = append(report.SubMismatches, SubMismatch{
+		ID:       row.ID,
+		Username: row.Username,
+		Stored:   row.Sub,
+		Identity: sub,
+	})
+	if row.Sub != "" {
+		r.record(report, row.Username, errors.Errorf("Row %d is linked to sub %s", row.ID, row.Sub))
+		return
+	}
+	if !report.DryRun {
+		r.record(report, row.Username, r.users.UpdateSub(ctx, row.ID, sub))
+	}
+}
+
+// reconcileMissing stores a user of the identity provider without a row
+func (r *Reconciler) reconcileMissing(ctx context.Context, report *ReconcileReport, username, sub string) {
+	// Sign ups register the user before storing them, the sign up recovery finishes those interrupted
+	_, err := r.signUps.GetSignUp(ctx, username)
+	if err == nil {
+		report.InProgress = append(report.InProgress, username)
+		return
+	}
+	if err != repository.ErrSignUpNotFound {
+		r.record(report, username, err)
+		return
+	}
+
+	// The user may have been stored since the rows were listed
+	user := &model.User{Username: username}
+	err = r.users.GetUser(ctx, user)
+	if err == nil {
+		return
+	}
+	if errors.Cause(err) != repository.ErrUserNotFound {
+		r.record(report, username, err)
+		return
+	}
+
+	report.MissingRows = append(report.MissingRows, username)
+	if !report.DryRun {
+		user.Sub = sub
+		r.record(report, username, r.users.CreateUser(ctx, user))
+	}
+}
+
+// reconcileOrphan deletes a row with no user of the identity provider, or a duplicate of a row that
+// matched one
+func (r *Reconciler) reconcileOrphan(ctx context.Context, report *ReconcileReport, user model.User, duplicate bool) {
+	// The user may have signed up since the identity provider's users were listed
+	if !duplicate {
+		registered, err := r.cognito.CheckUsernameTaken(ctx, user.Username)
+		if err != nil {
+			r.record(report, user.Username, err)
+			return
+		}
+		if registered {
+			return
+		}
+	}
+
+	report.OrphanedRows = append(report.OrphanedRows, user)
+	if !report.DryRun {
+		r.record(report, user.Username, r.users.DeleteUser(ctx, user.ID))
+	}
+}
+
+// record counts a fix, or records why checking or fixing a difference failed
+func (r *Reconciler) record(report *ReconcileReport, username string, err error) {
+	if err != nil {
+		level.Error(r.logger).Log("username", username, "err", err)
+		report.Errors = append(report.Errors, username+": "+err.Error())
+		return
+	}
+	report.Fixed++
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	st := newSignUpTest(t)
+	reconciler := NewReconciler(st.users, st.signUps, st.cognito, config.ReconcileSettings{}, log.NewNopLogger())
+
+	register := func(username string) string {
+		user := &model.User{
+			Username: username,
+			Email:    "scrott@gmail.com",
+			Password: "Swarleyfin1!",
+		}
+		require.NoError(t, st.cognito.Register(ctx, user))
+		return user.Sub
+	}
+	store := func(username, sub string) int {
+		user := &model.User{Username: username, Sub: sub}
+		require.NoError(t, st.users.CreateUser(ctx, user))
+		return user.ID
+	}
+
+	// In step
+	store("alice", register("alice"))
+	// Created outside the service
+	bobSub := register("bob")
+	// Deleted from the console
+	carol := store("carol", "")
+	// Stored with a different case, before subs were kept
+	daveSub := register("Dave")
+	dave := store("dave", "")
+	// Still signing up
+	register("erin")
+	require.NoError(t, st.signUps.StartSignUp(ctx, "erin", time.Now()))
+
+	report, err := reconciler.Reconcile(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, &ReconcileReport{
+		DryRun:        true,
+		IdentityUsers: 4,
+		StoredUsers:   3,
+		MissingRows:   []string{"bob"},
+		OrphanedRows:  []model.User{{ID: carol, Username: "carol"}},
+		Mismatches:    []UsernameMismatch{{ID: dave, Stored: "dave", Identity: "Dave"}},
+		SubMismatches: []SubMismatch{{ID: dave, Username: "dave", Identity: daveSub}},
+		InProgress:    []string{"erin"},
+		Errors:        []string{},
+	}, report)
+	assert.Equal(t, map[string]int{"alice": 1, "carol": carol, "dave": dave}, st.users.users, "Expected a dry run to change nothing")
+
+	report, err = reconciler.Reconcile(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Fixed)
+	assert.Empty(t, report.Errors)
+
+	user := &model.User{Username: "bob"}
+	assert.NoError(t, st.users.GetUser(ctx, user), "Expected the missing row to be created")
+	assert.Equal(t, bobSub, user.Sub)
+	user = &model.User{Username: "Dave"}
+	assert.NoError(t, st.users.GetUser(ctx, user), "Expected the username to be changed")
+	assert.Equal(t, dave, user.ID)
+	assert.Equal(t, daveSub, user.Sub, "Expected the row to be linked to the sub")
+	_, orphaned := st.users.users["carol"]
+	assert.False(t, orphaned, "Expected the orphaned row to be deleted")
+
+	report, err = reconciler.Reconcile(ctx, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Differences())
+	assert.Equal(t, []string{"erin"}, report.InProgress)
+}
+
+func TestReconcileLinkSubs(t *testing.T) {
+	ctx := context.Background()
+	st := newSignUpTest(t)
+	reconciler := NewReconciler(st.users, st.signUps, st.cognito, config.ReconcileSettings{}, log.NewNopLogger())
+
+	register := func(username string) string {
+		user := &model.User{
+			Username: username,
+			Email:    "scrott@gmail.com",
+			Password: "Swarleyfin1!",
+		}
+		require.NoError(t, st.cognito.Register(ctx, user))
+		return user.Sub
+	}
+	store := func(username string) int {
+		user := &model.User{Username: username}
+		require.NoError(t, st.users.CreateUser(ctx, user))
+		return user.ID
+	}
+
+	// Stored before subs were kept
+	aliceSub := register("alice")
+	alice := store("alice")
+	// Created outside the service
+	register("bob")
+	// Deleted from the console
+	carol := store("carol")
+	// Stored with a different case
+	register("Dave")
+	dave := store("dave")
+
+	report, err := reconciler.LinkSubs(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, &ReconcileReport{
+		SubsOnly:      true,
+		IdentityUsers: 3,
+		StoredUsers:   3,
+		MissingRows:   []string{},
+		OrphanedRows:  []model.User{},
+		Mismatches:    []UsernameMismatch{},
+		SubMismatches: []SubMismatch{{ID: alice, Username: "alice", Identity: aliceSub}},
+		InProgress:    []string{},
+		Fixed:         1,
+		Errors:        []string{},
+	}, report)
+	assert.Equal(t, aliceSub, st.users.subs[alice], "Expected the row to be linked to the sub")
+	assert.Equal(t, map[string]int{"alice": alice, "carol": carol, "dave": dave}, st.users.users, "Expected no row to be created, deleted or renamed")
+}
@@ -2,41 +2,74 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
 
 	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/auth"
 	"github.com/PedPet/user/pkg/repository"
 	"github.com/aws/aws-sdk-go/aws"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
 )
 
-// User describes the service.
+// User describes the service. Failures the caller can act on are returned as one of the Error values,
+// rejected tokens as one of the auth.TokenError values.
 type User interface {
 	CreateUser(ctx context.Context, username, email, password string) (*model.User, error)
 	UserDetails(ctx context.Context, token string) (*model.User, error)
 	ConfirmUser(ctx context.Context, username, otp string) error
 	ResendConfirmation(ctx context.Context, username string) error
 	UsernameTaken(ctx context.Context, username string) (bool, error)
-	Login(ctx context.Context, username, password string) (string, error)
-	VerifyJWT(ctx context.Context, token string) (bool, error)
+	Login(ctx context.Context, username, password string) (*model.Session, error)
+	RefreshSession(ctx context.Context, username, refreshToken string) (*model.Session, error)
+	ForgotPassword(ctx context.Context, username string) error
+	ConfirmForgotPassword(ctx context.Context, username, code, password string) error
+	ChangePassword(ctx context.Context, token, oldPassword, newPassword string, signOutOthers bool) (*model.Session, error)
+	Logout(ctx context.Context, username, refreshToken string) error
+	GlobalSignOut(ctx context.Context, token string) error
+	VerifyJWT(ctx context.Context, token string) (*model.Claims, error)
+	GetProfile(ctx context.Context, token string) (*model.Profile, error)
+	UpdateProfile(ctx context.Context, token string, profile *model.Profile) (*model.Profile, error)
+	UpdateContactDetails(ctx context.Context, token, email, phoneNumber string) ([]model.CodeDelivery, error)
+	VerifyAttribute(ctx context.Context, token, attribute, code string) error
 }
 
 type service struct {
 	repository repository.User
+	sessions   repository.Session
+	signUps    repository.SignUp
+	profiles   repository.Profile
 	cognito    CognitoClient
 	logger     log.Logger
 }
 
 // NewUserService creates a login service with required dependencies
-func NewUserService(rep repository.User, cognito CognitoClient, logger log.Logger) User {
+func NewUserService(
+	rep repository.User,
+	sessions repository.Session,
+	signUps repository.SignUp,
+	profiles repository.Profile,
+	cognito CognitoClient,
+	logger log.Logger,
+) User {
 	return &service{
 		repository: rep,
+		sessions:   sessions,
+		signUps:    signUps,
+		profiles:   profiles,
 		cognito:    cognito,
 		logger:     logger,
 	}
 }
 
-// CreateUser registers a user with cognito and then stores the username with an id for further user data storage
+// CreateUser registers a user with cognito and then stores the username with an id for further user data storage.
+// The sign up is recorded until both are done, when storing the user fails the registration is rolled back so
+// the username isn't left taken. Sign ups interrupted part way are finished or rolled back by SignUpRecovery.
 func (s service) CreateUser(ctx context.Context, username, email, password string) (*model.User, error) {
 	logger := log.With(s.logger, "method", "CreateUser")
 
@@ -46,43 +79,89 @@ func (s service) CreateUser(ctx context.Context, username, email, password strin
 		Password: password,
 	}
 
-	err := s.cognito.Register(ctx, user)
+	err := s.signUps.StartSignUp(ctx, username, time.Now())
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return nil, err
+		return nil, translateError(err)
+	}
+
+	err = s.cognito.Register(ctx, user)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		// The identity provider refused the user, nothing was registered. Other failures may have
+		// registered them, the sign up is left for the recovery to check.
+		if providerRejected(err) {
+			finishSignUp(ctx, s.signUps, logger, username)
+		}
+		return nil, translateError(err)
 	}
 
 	err = s.repository.CreateUser(ctx, user)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return nil, err
+		rollBackSignUp(ctx, s.cognito, s.signUps, logger, username)
+		return nil, translateError(err)
 	}
 
-	logger.Log("Create user", user)
+	finishSignUp(ctx, s.signUps, logger, username)
+	logger.Log("Create user", user.ID)
 	return user, nil
 }
 
-// GetUser gets the user's details from cognito and the database and
+// UserDetails gets the user's details from cognito and the database. The row is found by the user's sub as
+// usernames can change, rows stored before subs were kept are found by username and linked to the sub.
 func (s service) UserDetails(ctx context.Context, token string) (*model.User, error) {
 	logger := log.With(s.logger, "method", "GetUser")
 
-	user, err := s.cognito.GetUserDetails(ctx, token)
+	user, err := s.tokenUser(ctx, token)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return nil, err
+		return nil, translateError(err)
 	}
 
-	err = s.repository.GetUser(ctx, user)
+	logger.Log("User details" /*, user*/)
+
+	return user, nil
+}
+
+// tokenUser gets the details of the user the access token was issued to with the id of their row
+func (s service) tokenUser(ctx context.Context, token string) (*model.User, error) {
+	user, err := s.cognito.GetUserDetails(ctx, token)
 	if err != nil {
-		level.Error(logger).Log("err", err)
 		return nil, err
 	}
 
-	logger.Log("User details" /*, user*/)
+	err = s.repository.GetUserBySub(ctx, user)
+	if errors.Cause(err) == repository.ErrUserNotFound {
+		err = s.linkUser(ctx, user)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	return user, nil
 }
 
+// linkUser finds a row not yet linked to a sub by the user's username and links it to user.Sub. A row
+// linked to another sub belongs to a previous user of the username.
+func (s service) linkUser(ctx context.Context, user *model.User) error {
+	sub := user.Sub
+	err := s.repository.GetUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	if user.Sub == sub {
+		return nil
+	}
+	if user.Sub != "" {
+		user.Sub = sub
+		return errors.Wrap(repository.ErrUserNotFound, "Username is linked to another sub")
+	}
+
+	user.Sub = sub
+	return s.repository.UpdateSub(ctx, user.ID, sub)
+}
+
 func (s service) ConfirmUser(ctx context.Context, username, otp string) error {
 	logger := log.With(s.logger, "method", "ConfirmUser")
 
@@ -92,7 +171,7 @@ func (s service) ConfirmUser(ctx context.Context, username, otp string) error {
 	err := s.cognito.OTP(ctx, user, otp)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return err
+		return translateError(err)
 	}
 
 	logger.Log("Confirm user" /*, *user*/)
@@ -105,7 +184,7 @@ func (s service) ResendConfirmation(ctx context.Context, username string) error
 	err := s.cognito.ResendConfirmation(ctx, username)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return err
+		return translateError(err)
 	}
 
 	logger.Log("Resend confirmation")
@@ -118,34 +197,294 @@ func (s service) UsernameTaken(ctx context.Context, username string) (bool, erro
 	taken, err := s.cognito.CheckUsernameTaken(ctx, username)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return false, err
+		return false, translateError(err)
 	}
 
 	logger.Log("Username taken", taken)
 	return taken, nil
 }
 
-func (s service) Login(ctx context.Context, username, password string) (string, error) {
+func (s service) Login(ctx context.Context, username, password string) (*model.Session, error) {
 	logger := log.With(s.logger, "method", "Login")
 
 	auth, err := s.cognito.Login(ctx, username, password)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return "", err
+		return nil, translateError(err)
+	}
+
+	logger.Log("Login")
+	return authToSession(auth), nil
+}
+
+// RefreshSession uses a refresh token to get new access and id tokens without the user's password
+func (s service) RefreshSession(ctx context.Context, username, refreshToken string) (*model.Session, error) {
+	logger := log.With(s.logger, "method", "RefreshSession")
+
+	revoked, err := s.sessions.TokenRevoked(ctx, hashToken(refreshToken))
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+	if revoked {
+		level.Error(logger).Log("err", auth.ErrTokenRevoked)
+		return nil, auth.ErrTokenRevoked
+	}
+
+	auth, err := s.cognito.RefreshSession(ctx, username, refreshToken)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	logger.Log("Refresh session")
+	return authToSession(auth), nil
+}
+
+// ForgotPassword sends the user a code to reset their password with
+func (s service) ForgotPassword(ctx context.Context, username string) error {
+	logger := log.With(s.logger, "method", "ForgotPassword")
+
+	err := s.cognito.ForgotPassword(ctx, username)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return translateError(err)
+	}
+
+	logger.Log("Forgot password")
+	return nil
+}
+
+// ConfirmForgotPassword sets a new password for the user using the code sent by ForgotPassword
+func (s service) ConfirmForgotPassword(ctx context.Context, username, code, password string) error {
+	logger := log.With(s.logger, "method", "ConfirmForgotPassword")
+
+	err := s.cognito.ConfirmForgotPassword(ctx, username, code, password)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return translateError(err)
+	}
+
+	logger.Log("Confirm forgot password")
+	return nil
+}
+
+// ChangePassword changes a logged in user's password. When signOutOthers is set every other session
+// is revoked and the caller is given a fresh session, as their current tokens are revoked too.
+func (s service) ChangePassword(
+	ctx context.Context,
+	token, oldPassword, newPassword string,
+	signOutOthers bool,
+) (*model.Session, error) {
+	logger := log.With(s.logger, "method", "ChangePassword")
+
+	user, err := s.cognito.GetUserDetails(ctx, token)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	err = s.cognito.ChangePassword(ctx, token, oldPassword, newPassword)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	if !signOutOthers {
+		logger.Log("Change password")
+		return nil, nil
+	}
+
+	err = s.signOut(ctx, token, user.Username)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	auth, err := s.cognito.Login(ctx, user.Username, newPassword)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	logger.Log("Change password and sign out other sessions")
+	return authToSession(auth), nil
+}
+
+// Logout revokes a single refresh token with the identity provider, which refuses tokens that aren't the
+// user's, and records it so RefreshSession rejects it whichever provider issued it
+func (s service) Logout(ctx context.Context, username, refreshToken string) error {
+	logger := log.With(s.logger, "method", "Logout")
+
+	err := s.cognito.RevokeToken(ctx, username, refreshToken)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return translateError(err)
 	}
 
-	logger.Log("Login", auth)
-	return aws.StringValue(auth.AccessToken), nil
+	err = s.sessions.RevokeToken(ctx, hashToken(refreshToken), username)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return translateError(err)
+	}
+
+	logger.Log("Logout")
+	return nil
 }
 
-func (s service) VerifyJWT(ctx context.Context, jwt string) (bool, error) {
+// GlobalSignOut revokes every session of the user the token belongs to
+func (s service) GlobalSignOut(ctx context.Context, token string) error {
+	logger := log.With(s.logger, "method", "GlobalSignOut")
+
+	user, err := s.cognito.GetUserDetails(ctx, token)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return translateError(err)
+	}
+
+	err = s.signOut(ctx, token, user.Username)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return translateError(err)
+	}
+
+	logger.Log("Global sign out")
+	return nil
+}
+
+// signOut revokes the user's sessions with the identity provider and records when it happened so
+// VerifyJWT can reject tokens issued before it. The time is truncated to the second to match the
+// precision of the token's iat claim, so tokens issued straight afterwards are still accepted.
+func (s service) signOut(ctx context.Context, token, username string) error {
+	err := s.cognito.GlobalSignOut(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	return s.sessions.SignOut(ctx, username, time.Now().Truncate(time.Second))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func authToSession(auth *cognito.AuthenticationResultType) *model.Session {
+	return &model.Session{
+		AccessToken:  aws.StringValue(auth.AccessToken),
+		IDToken:      aws.StringValue(auth.IdToken),
+		RefreshToken: aws.StringValue(auth.RefreshToken),
+		ExpiresIn:    aws.Int64Value(auth.ExpiresIn),
+		TokenType:    aws.StringValue(auth.TokenType),
+	}
+}
+
+// VerifyJWT checks the token's signature and claims and that it wasn't issued before the user last
+// signed out of every session. A rejected token returns one of the auth.TokenError values.
+func (s service) VerifyJWT(ctx context.Context, tokenString string) (*model.Claims, error) {
 	logger := log.With(s.logger, "method", "VerifyJWT")
 
-	token, err := s.cognito.ParseAndVerifyJWT(ctx, jwt)
+	token, err := s.cognito.ParseAndVerifyJWT(ctx, tokenString)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("Token has unexpected claims")
+	}
+	claims := auth.Claims(mapClaims)
+
+	signedOutAt, err := s.sessions.SignedOutAt(ctx, claims.Username)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return false, err
+		return nil, translateError(err)
 	}
 
-	return token.Valid, nil
+	if !signedOutAt.IsZero() && claims.IssuedAt.Before(signedOutAt) {
+		level.Error(logger).Log("err", auth.ErrTokenRevoked)
+		return nil, auth.ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// GetProfile gets the profile of the user the token was issued to, which is empty until they store one
+func (s service) GetProfile(ctx context.Context, token string) (*model.Profile, error) {
+	logger := log.With(s.logger, "method", "GetProfile")
+
+	user, err := s.tokenUser(ctx, token)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	profile, err := s.profiles.GetProfile(ctx, user.ID)
+	if err == repository.ErrProfileNotFound {
+		profile, err = &model.Profile{UserID: user.ID}, nil
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	logger.Log("Get profile", user.ID)
+	return profile, nil
+}
+
+// UpdateProfile replaces the profile of the user the token was issued to, fields left empty are cleared
+func (s service) UpdateProfile(ctx context.Context, token string, profile *model.Profile) (*model.Profile, error) {
+	logger := log.With(s.logger, "method", "UpdateProfile")
+
+	user, err := s.tokenUser(ctx, token)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	profile.UserID = user.ID
+	// Truncated to the precision the database stores
+	profile.UpdatedAt = time.Now().Truncate(time.Second)
+	err = s.profiles.UpdateProfile(ctx, profile)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	logger.Log("Update profile", user.ID)
+	return profile, nil
+}
+
+// UpdateContactDetails changes the email and phone number of the user the token was issued to, an empty
+// value is left as it is. A changed value is unverified until VerifyAttribute is given the code sent to it.
+func (s service) UpdateContactDetails(
+	ctx context.Context,
+	token, email, phoneNumber string,
+) ([]model.CodeDelivery, error) {
+	logger := log.With(s.logger, "method", "UpdateContactDetails")
+
+	deliveries, err := s.cognito.UpdateContactDetails(ctx, token, email, phoneNumber)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, translateError(err)
+	}
+
+	logger.Log("Update contact details")
+	return deliveries, nil
+}
+
+// VerifyAttribute verifies the email or phone number of the user the token was issued to with the code
+// sent to it
+func (s service) VerifyAttribute(ctx context.Context, token, attribute, code string) error {
+	logger := log.With(s.logger, "method", "VerifyAttribute")
+
+	err := s.cognito.VerifyAttribute(ctx, token, attribute, code)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return translateError(err)
+	}
+
+	logger.Log("Verify attribute", attribute)
+	return nil
 }
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSignUpInterval   = time.Minute
+	defaultSignUpRetryAfter = 5 * time.Minute
+	// signUpBatch is the most sign ups recovered each interval
+	signUpBatch = 100
+)
+
+// finishSignUp removes the record of a finished sign up. Failing is only logged, the recovery removes
+// the record once it finds the user stored.
+func finishSignUp(ctx context.Context, signUps repository.SignUp, logger log.Logger, username string) {
+	err := signUps.FinishSignUp(context.WithoutCancel(ctx), username)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to finish sign up", "err", err)
+	}
+}
+
+// providerRejected reports whether the identity provider answered with an error, so the request wasn't
+// carried out. A request that didn't reach it, whose answer was lost or that failed on the provider's side
+// with a 5xx may have been.
+func providerRejected(err error) bool {
+	awsErr, ok := errors.Cause(err).(awserr.Error)
+	if !ok {
+		return false
+	}
+	if reqErr, ok := awsErr.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return false
+	}
+
+	switch awsErr.Code() {
+	case request.ErrCodeRequestError,
+		request.ErrCodeResponseTimeout,
+		request.ErrCodeRead,
+		request.ErrCodeSerialization,
+		request.CanceledErrorCode:
+		return false
+	}
+	return true
+}
+
+// rollBackSignUp deletes a user whose sign up failed from the identity provider. When that fails too
+// the sign up is recorded as failed for the recovery to retry. The roll back isn't cancelled with the
+// request, a caller giving up is often why the sign up failed.
+func rollBackSignUp(
+	ctx context.Context,
+	cognito CognitoClient,
+	signUps repository.SignUp,
+	logger log.Logger,
+	username string,
+) error {
+	ctx = context.WithoutCancel(ctx)
+
+	err := cognito.DeleteUser(ctx, username)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to roll back sign up", "err", err)
+		failErr := signUps.FailSignUp(ctx, username, err.Error(), time.Now())
+		if failErr != nil {
+			level.Error(logger).Log("msg", "failed to record failed sign up", "err", failErr)
+		}
+		return err
+	}
+
+	logger.Log("Roll back sign up", username)
+	finishSignUp(ctx, signUps, logger, username)
+	return nil
+}
+
+// SignUpRecovery finishes or rolls back the sign ups left unfinished by a crash or a failed roll back
+type SignUpRecovery struct {
+	users      repository.User
+	signUps    repository.SignUp
+	cognito    CognitoClient
+	interval   time.Duration
+	retryAfter time.Duration
+	logger     log.Logger
+}
+
+// NewSignUpRecovery creates the recovery of unfinished sign ups. Sign ups are left alone until they
+// haven't changed for settings.RetryAfter, so those still in progress aren't touched.
+func NewSignUpRecovery(
+	users repository.User,
+	signUps repository.SignUp,
+	cognito CognitoClient,
+	settings config.SignUpSettings,
+	logger log.Logger,
+) *SignUpRecovery {
+	r := &SignUpRecovery{
+		users:      users,
+		signUps:    signUps,
+		cognito:    cognito,
+		interval:   settings.Interval,
+		retryAfter: settings.RetryAfter,
+		logger:     log.With(logger, "component", "signup_recovery"),
+	}
+	if r.interval <= 0 {
+		r.interval = defaultSignUpInterval
+	}
+	if r.retryAfter <= 0 {
+		r.retryAfter = defaultSignUpRetryAfter
+	}
+
+	return r
+}
+
+// Run recovers sign ups straight away and then every interval until ctx is done
+func (r *SignUpRecovery) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.Recover(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Recover finishes or rolls back each sign up that hasn't changed for the retry delay. A sign up that
+// can't be recovered is logged and tried again next time.
+func (r *SignUpRecovery) Recover(ctx context.Context) error {
+	signUps, err := r.signUps.StaleSignUps(ctx, time.Now().Add(-r.retryAfter), signUpBatch)
+	if err != nil {
+		level.Error(r.logger).Log("err", err)
+		return err
+	}
+
+	for _, signUp := range signUps {
+		logger := log.With(r.logger, "username", signUp.Username, "state", signUp.State, "attempts", signUp.Attempts)
+		err = r.recover(ctx, signUp, logger)
+		if err != nil {
+			level.Error(logger).Log("err", err)
+		}
+	}
+
+	return nil
+}
+
+// recover rolls back a failed sign up. A pending sign up was interrupted, it's finished when the user
+// was registered with the identity provider.
+func (r *SignUpRecovery) recover(ctx context.Context, signUp model.SignUp, logger log.Logger) error {
+	if signUp.State == model.SignUpFailed {
+		return rollBackSignUp(ctx, r.cognito, r.signUps, logger, signUp.Username)
+	}
+
+	user := &model.User{Username: signUp.Username}
+	err := r.users.GetUser(ctx, user)
+	if err == nil {
+		finishSignUp(ctx, r.signUps, logger, signUp.Username)
+		return nil
+	}
+	if errors.Cause(err) != repository.ErrUserNotFound {
+		return err
+	}
+
+	registered, err := r.cognito.CheckUsernameTaken(ctx, signUp.Username)
+	if err != nil {
+		return err
+	}
+	if !registered {
+		logger.Log("Drop sign up", "not registered")
+		finishSignUp(ctx, r.signUps, logger, signUp.Username)
+		return nil
+	}
+
+	err = r.users.CreateUser(ctx, user)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return rollBackSignUp(ctx, r.cognito, r.signUps, logger, signUp.Username)
+	}
+
+	logger.Log("Resume sign up", user.ID)
+	finishSignUp(ctx, r.signUps, logger, signUp.Username)
+	return nil
+}
@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PedPet/user/config"
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/repository"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/bxcodec/faker/v3"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errDatabaseDown = errors.New("dial tcp 10.0.0.1:3306: connection refused")
+
+type userRepoStub struct {
+	mu     sync.Mutex
+	users  map[string]int
+	subs   map[int]string
+	nextID int
+	fail   bool
+}
+
+func (r *userRepoStub) CreateUser(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fail {
+		return errDatabaseDown
+	}
+	r.nextID++
+	user.ID = r.nextID
+	r.users[user.Username] = user.ID
+	if user.Sub != "" {
+		r.subs[user.ID] = user.Sub
+	}
+	return nil
+}
+
+func (r *userRepoStub) GetUser(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.users[user.Username]
+	if !ok {
+		return errors.Wrap(repository.ErrUserNotFound, "")
+	}
+	user.ID = id
+	user.Sub = r.subs[id]
+	return nil
+}
+
+func (r *userRepoStub) GetUserBySub(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, sub := range r.subs {
+		if sub == user.Sub {
+			user.ID = id
+			return nil
+		}
+	}
+	return errors.Wrap(repository.ErrUserNotFound, "")
+}
+
+func (r *userRepoStub) ListUsers(ctx context.Context, afterID, limit int) ([]model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := []model.User{}
+	for username, id := range r.users {
+		if id > afterID {
+			users = append(users, model.User{ID: id, Username: username, Sub: r.subs[id]})
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r *userRepoStub) UpdateUsername(ctx context.Context, id int, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for existing, existingID := range r.users {
+		if existingID == id {
+			delete(r.users, existing)
+			r.users[username] = id
+		}
+	}
+	return nil
+}
+
+func (r *userRepoStub) UpdateSub(ctx context.Context, id int, sub string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.subs[id] = sub
+	return nil
+}
+
+func (r *userRepoStub) DeleteUser(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.subs, id)
+
+	for username, existingID := range r.users {
+		if existingID == id {
+			delete(r.users, username)
+		}
+	}
+	return nil
+}
+
+type signUpRepoStub struct {
+	mu      sync.Mutex
+	signUps map[string]model.SignUp
+}
+
+func (r *signUpRepoStub) StartSignUp(ctx context.Context, username string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.signUps[username]; ok {
+		return repository.ErrSignUpInProgress
+	}
+	r.signUps[username] = model.SignUp{Username: username, State: model.SignUpPending, CreatedAt: at, UpdatedAt: at}
+	return nil
+}
+
+func (r *signUpRepoStub) FailSignUp(ctx context.Context, username, cause string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	signUp := r.signUps[username]
+	signUp.State = model.SignUpFailed
+	signUp.Attempts++
+	signUp.LastError = cause
+	signUp.UpdatedAt = at
+	r.signUps[username] = signUp
+	return nil
+}
+
+func (r *signUpRepoStub) FinishSignUp(ctx context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.signUps, username)
+	return nil
+}
+
+func (r *signUpRepoStub) GetSignUp(ctx context.Context, username string) (*model.SignUp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	signUp, ok := r.signUps[username]
+	if !ok {
+		return nil, repository.ErrSignUpNotFound
+	}
+	return &signUp, nil
+}
+
+func (r *signUpRepoStub) StaleSignUps(ctx context.Context, before time.Time, limit int) ([]model.SignUp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	signUps := []model.SignUp{}
+	for _, signUp := range r.signUps {
+		if signUp.UpdatedAt.Before(before) {
+			signUps = append(signUps, signUp)
+		}
+	}
+	return signUps, nil
+}
+
+func (r *signUpRepoStub) get(username string) (model.SignUp, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	signUp, ok := r.signUps[username]
+	return signUp, ok
+}
+
+// deleteFailingClient is an identity provider that can't delete users, or register them when registerErr
+// is set
+type deleteFailingClient struct {
+	CognitoClient
+	fail        bool
+	registerErr error
+}
+
+func (c *deleteFailingClient) Register(ctx context.Context, user *model.User) error {
+	if c.registerErr != nil {
+		return c.registerErr
+	}
+	return c.CognitoClient.Register(ctx, user)
+}
+
+func (c *deleteFailingClient) DeleteUser(ctx context.Context, username string) error {
+	if c.fail {
+		return errors.New("identity provider unavailable")
+	}
+	return c.CognitoClient.DeleteUser(ctx, username)
+}
+
+type signUpTest struct {
+	users    *userRepoStub
+	signUps  *signUpRepoStub
+	profiles *profileRepoStub
+	cognito  *deleteFailingClient
+	mailer   *mailerStub
+	service  User
+	recovery *SignUpRecovery
+}
+
+func newSignUpTest(t *testing.T) signUpTest {
+	cc, mailer := newLocalTestClient(t, HashBcrypt)
+	st := signUpTest{
+		users:    &userRepoStub{users: map[string]int{}, subs: map[int]string{}},
+		signUps:  &signUpRepoStub{signUps: map[string]model.SignUp{}},
+		profiles: &profileRepoStub{profiles: map[int]model.Profile{}},
+		cognito:  &deleteFailingClient{CognitoClient: cc},
+		mailer:   mailer,
+	}
+	st.service = NewUserService(st.users, nil, st.signUps, st.profiles, st.cognito, log.NewNopLogger())
+	// Recover every sign up straight away rather than leaving them for those still in progress
+	st.recovery = NewSignUpRecovery(st.users, st.signUps, st.cognito, config.SignUpSettings{
+		RetryAfter: time.Nanosecond,
+	}, log.NewNopLogger())
+	return st
+}
+
+func (st signUpTest) registered(t *testing.T, username string) bool {
+	taken, err := st.cognito.CheckUsernameTaken(context.Background(), username)
+	require.NoError(t, err)
+	return taken
+}
+
+func TestCreateUserFinishesSignUp(t *testing.T) {
+	st := newSignUpTest(t)
+	username := faker.Username()
+
+	user, err := st.service.CreateUser(context.Background(), username, "scrott@gmail.com", "Swarleyfin1!")
+	require.NoError(t, err)
+	assert.Equal(t, 1, user.ID)
+	assert.NotEmpty(t, user.Sub)
+	assert.Equal(t, user.Sub, st.users.subs[user.ID], "Expected the row to be linked to the sub")
+
+	_, pending := st.signUps.get(username)
+	assert.False(t, pending, "Expected the sign up to be finished")
+
+	_, err = st.service.CreateUser(context.Background(), username, "scrott@gmail.com", "Swarleyfin1!")
+	assert.Equal(t, ErrUsernameExists, err)
+	_, pending = st.signUps.get(username)
+	assert.False(t, pending, "Expected the refused sign up to be finished")
+}
+
+func TestCreateUserProviderErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		finished bool
+	}{
+		{
+			name:     "Unmapped provider error",
+			err:      awserr.New(cognito.ErrCodeInvalidParameterException, "Invalid email address format.", nil),
+			finished: true,
+		},
+		{
+			name: "Provider failed",
+			err: awserr.NewRequestFailure(
+				awserr.New(cognito.ErrCodeInternalErrorException, "Internal error", nil),
+				500,
+				"3f1b2c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d",
+			),
+			finished: false,
+		},
+		{
+			name:     "Request didn't reach the provider",
+			err:      awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("connection reset")),
+			finished: false,
+		},
+		{
+			name:     "Timed out",
+			err:      context.DeadlineExceeded,
+			finished: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			st := newSignUpTest(t)
+			st.cognito.registerErr = tc.err
+			username := faker.Username()
+
+			_, err := st.service.CreateUser(context.Background(), username, "scrott@gmail.com", "Swarleyfin1!")
+			assert.Error(t, err)
+
+			_, pending := st.signUps.get(username)
+			assert.Equal(t, !tc.finished, pending)
+		})
+	}
+}
+
+func TestUserDetailsLinksSub(t *testing.T) {
+	st := newSignUpTest(t)
+	ctx := context.Background()
+
+	// Registered and stored before subs were kept
+	user := &model.User{
+		Username: faker.Username(),
+		Email:    "scrott@gmail.com",
+		Password: "Swarleyfin1!",
+	}
+	require.NoError(t, st.cognito.Register(ctx, user))
+	require.NoError(t, st.cognito.OTP(ctx, user, st.mailer.code(user.Email)))
+	id := st.users.nextID + 1
+	require.NoError(t, st.users.CreateUser(ctx, &model.User{Username: user.Username}))
+
+	auth, err := st.cognito.Login(ctx, user.Username, user.Password)
+	require.NoError(t, err)
+	token := aws.StringValue(auth.AccessToken)
+
+	details, err := st.service.UserDetails(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, id, details.ID)
+	assert.Equal(t, user.Sub, st.users.subs[id], "Expected the row to be linked to the sub")
+
+	// Found by sub once linked, whatever the row's username
+	require.NoError(t, st.users.UpdateUsername(ctx, id, "renamed"))
+	details, err = st.service.UserDetails(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, id, details.ID)
+
+	// A row of a previous user of the username isn't theirs
+	require.NoError(t, st.users.UpdateUsername(ctx, id, user.Username))
+	require.NoError(t, st.users.UpdateSub(ctx, id, "6f1d3c1e-4a52-4b8e-9d7a-0c1f2e3d4b5a"))
+	_, err = st.service.UserDetails(ctx, token)
+	assert.Equal(t, ErrUserNotFound, err)
+}
+
+func TestCreateUserRollsBack(t *testing.T) {
+	st := newSignUpTest(t)
+	username := faker.Username()
+	st.users.fail = true
+
+	_, err := st.service.CreateUser(context.Background(), username, "scrott@gmail.com", "Swarleyfin1!")
+	assert.Equal(t, errDatabaseDown, errors.Cause(err))
+
+	assert.False(t, st.registered(t, username), "Expected the registration to be rolled back")
+	_, pending := st.signUps.get(username)
+	assert.False(t, pending, "Expected the sign up to be finished")
+}
+
+func TestRecoverFailedRollBack(t *testing.T) {
+	st := newSignUpTest(t)
+	username := faker.Username()
+	st.users.fail = true
+	st.cognito.fail = true
+
+	_, err := st.service.CreateUser(context.Background(), username, "scrott@gmail.com", "Swarleyfin1!")
+	require.Error(t, err)
+
+	signUp, pending := st.signUps.get(username)
+	require.True(t, pending, "Expected the failed roll back to be recorded")
+	assert.Equal(t, model.SignUpFailed, signUp.State)
+	assert.Equal(t, 1, signUp.Attempts)
+	assert.True(t, st.registered(t, username))
+
+	_, err = st.service.CreateUser(context.Background(), username, "scrott@gmail.com", "Swarleyfin1!")
+	assert.Equal(t, ErrUsernameExists, err, "Expected the username to be taken until the sign up is recovered")
+
+	require.NoError(t, st.recovery.Recover(context.Background()))
+	signUp, _ = st.signUps.get(username)
+	assert.Equal(t, 2, signUp.Attempts, "Expected the failed retry to be counted")
+
+	st.cognito.fail = false
+	require.NoError(t, st.recovery.Recover(context.Background()))
+	assert.False(t, st.registered(t, username), "Expected the registration to be rolled back")
+	_, pending = st.signUps.get(username)
+	assert.False(t, pending, "Expected the sign up to be finished")
+}
+
+func TestRecoverInterruptedSignUp(t *testing.T) {
+	st := newSignUpTest(t)
+	ctx := context.Background()
+
+	// Interrupted after registering, the user is stored when the sign up is resumed
+	registered := faker.Username()
+	require.NoError(t, st.signUps.StartSignUp(ctx, registered, time.Now()))
+	require.NoError(t, st.cognito.Register(ctx, &model.User{
+		Username: registered,
+		Email:    "scrott@gmail.com",
+		Password: "Swarleyfin1!",
+	}))
+
+	// Interrupted before registering, there's nothing to do
+	unregistered := faker.Username()
+	require.NoError(t, st.signUps.StartSignUp(ctx, unregistered, time.Now()))
+
+	require.NoError(t, st.recovery.Recover(ctx))
+
+	user := &model.User{Username: registered}
+	require.NoError(t, st.users.GetUser(ctx, user), "Expected the sign up to be resumed")
+	assert.True(t, st.registered(t, registered))
+	assert.False(t, st.registered(t, unregistered))
+	assert.Empty(t, st.signUps.signUps, "Expected both sign ups to be finished")
+}
+
+func TestRecoverLeavesSignUpsInProgress(t *testing.T) {
+	st := newSignUpTest(t)
+	st.recovery.retryAfter = time.Hour
+	username := faker.Username()
+	require.NoError(t, st.signUps.StartSignUp(context.Background(), username, time.Now()))
+
+	require.NoError(t, st.recovery.Recover(context.Background()))
+	_, pending := st.signUps.get(username)
+	assert.True(t, pending)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+
+	"github.com/PedPet/user/model"
+	"github.com/PedPet/user/pkg/tracing"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/dgrijalva/jwt-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracingCognitoClient struct {
+	tracer trace.Tracer
+	next   CognitoClient
+}
+
+// TracingCognitoMiddleware wraps every call to the identity provider in a client span named
+// "identity.<method>"
+func TracingCognitoMiddleware(tracer trace.Tracer) CognitoMiddleware {
+	return func(next CognitoClient) CognitoClient {
+		return &tracingCognitoClient{
+			tracer: tracer,
+			next:   next,
+		}
+	}
+}
+
+func (c tracingCognitoClient) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return c.tracer.Start(ctx, "identity."+method, trace.WithSpanKind(trace.SpanKindClient))
+}
+
+func (c tracingCognitoClient) Register(ctx context.Context, user *model.User) (err error) {
+	ctx, span := c.start(ctx, "Register")
+	defer func() { tracing.End(span, err) }()
+	return c.next.Register(ctx, user)
+}
+
+func (c tracingCognitoClient) DeleteUser(ctx context.Context, username string) (err error) {
+	ctx, span := c.start(ctx, "DeleteUser")
+	defer func() { tracing.End(span, err) }()
+	return c.next.DeleteUser(ctx, username)
+}
+
+func (c tracingCognitoClient) OTP(ctx context.Context, user *model.User, otp string) (err error) {
+	ctx, span := c.start(ctx, "OTP")
+	defer func() { tracing.End(span, err) }()
+	return c.next.OTP(ctx, user, otp)
+}
+
+func (c tracingCognitoClient) ResendConfirmation(ctx context.Context, username string) (err error) {
+	ctx, span := c.start(ctx, "ResendConfirmation")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ResendConfirmation(ctx, username)
+}
+
+func (c tracingCognitoClient) CheckUsernameTaken(ctx context.Context, username string) (taken bool, err error) {
+	ctx, span := c.start(ctx, "CheckUsernameTaken")
+	defer func() { tracing.End(span, err) }()
+	return c.next.CheckUsernameTaken(ctx, username)
+}
+
+func (c tracingCognitoClient) ListUsers(
+	ctx context.Context,
+	paginationToken string,
+) (users []model.User, next string, err error) {
+	ctx, span := c.start(ctx, "ListUsers")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ListUsers(ctx, paginationToken)
+}
+
+func (c tracingCognitoClient) Login(
+	ctx context.Context,
+	username, password string,
+) (auth *cognito.AuthenticationResultType, err error) {
+	ctx, span := c.start(ctx, "Login")
+	defer func() { tracing.End(span, err) }()
+	return c.next.Login(ctx, username, password)
+}
+
+func (c tracingCognitoClient) RefreshSession(
+	ctx context.Context,
+	username, refreshToken string,
+) (auth *cognito.AuthenticationResultType, err error) {
+	ctx, span := c.start(ctx, "RefreshSession")
+	defer func() { tracing.End(span, err) }()
+	return c.next.RefreshSession(ctx, username, refreshToken)
+}
+
+func (c tracingCognitoClient) ForgotPassword(ctx context.Context, username string) (err error) {
+	ctx, span := c.start(ctx, "ForgotPassword")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ForgotPassword(ctx, username)
+}
+
+func (c tracingCognitoClient) ConfirmForgotPassword(ctx context.Context, username, code, password string) (err error) {
+	ctx, span := c.start(ctx, "ConfirmForgotPassword")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ConfirmForgotPassword(ctx, username, code, password)
+}
+
+func (c tracingCognitoClient) ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) (err error) {
+	ctx, span := c.start(ctx, "ChangePassword")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ChangePassword(ctx, accessToken, oldPassword, newPassword)
+}
+
+func (c tracingCognitoClient) GlobalSignOut(ctx context.Context, accessToken string) (err error) {
+	ctx, span := c.start(ctx, "GlobalSignOut")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GlobalSignOut(ctx, accessToken)
+}
+
+func (c tracingCognitoClient) RevokeToken(ctx context.Context, username, refreshToken string) (err error) {
+	ctx, span := c.start(ctx, "RevokeToken")
+	defer func() { tracing.End(span, err) }()
+	return c.next.RevokeToken(ctx, username, refreshToken)
+}
+
+func (c tracingCognitoClient) getWellKnownJWTKs(ctx context.Context) error {
+	return c.next.getWellKnownJWTKs(ctx)
+}
+
+func (c tracingCognitoClient) ParseAndVerifyJWT(ctx context.Context, token string) (t *jwt.Token, err error) {
+	ctx, span := c.start(ctx, "ParseAndVerifyJWT")
+	defer func() { tracing.End(span, err) }()
+	return c.next.ParseAndVerifyJWT(ctx, token)
+}
+
+func (c tracingCognitoClient) GetUserDetails(ctx context.Context, accessToken string) (user *model.User, err error) {
+	ctx, span := c.start(ctx, "GetUserDetails")
+	defer func() { tracing.End(span, err) }()
+	return c.next.GetUserDetails(ctx, accessToken)
+}
+
+func (c tracingCognitoClient) UpdateContactDetails(
+	ctx context.Context,
+	accessToken, email, phoneNumber string,
+) (deliveries []model.CodeDelivery, err error) {
+	ctx, span := c.start(ctx, "UpdateContactDetails")
+	defer func() { tracing.End(span, err) }()
+	return c.next.UpdateContactDetails(ctx, accessToken, email, phoneNumber)
+}
+
+func (c tracingCognitoClient) VerifyAttribute(ctx context.Context, accessToken, attribute, code string) (err error) {
+	ctx, span := c.start(ctx, "VerifyAttribute")
+	defer func() { tracing.End(span, err) }()
+	return c.next.VerifyAttribute(ctx, accessToken, attribute, code)
+}
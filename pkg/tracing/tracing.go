@@ -0,0 +1,76 @@
+// Package tracing sets up where the service's spans are exported and holds the helpers shared by
+// the layers that create them.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/PedPet/user/config"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName names the tracer the user service's spans are created with
+const InstrumentationName = "github.com/PedPet/user"
+
+// NewTracerProvider creates the tracer provider exporting spans to the exporter in settings. Without
+// an exporter spans are still created, so trace context is passed on, but they go nowhere. The
+// provider must be shut down to flush the last spans.
+func NewTracerProvider(ctx context.Context, settings config.TracingSettings, serviceName string) (*sdktrace.TracerProvider, error) {
+	ratio := settings.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	}
+
+	switch settings.Exporter {
+	case config.ExporterOTLP:
+		exporterOptions := []otlptracegrpc.Option{}
+		if settings.Endpoint != "" {
+			exporterOptions = append(exporterOptions, otlptracegrpc.WithEndpoint(settings.Endpoint))
+		}
+		if settings.Insecure {
+			exporterOptions = append(exporterOptions, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err := otlptracegrpc.New(ctx, exporterOptions...)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create otlp trace exporter")
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+
+	case config.ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create stdout trace exporter")
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+
+	case "":
+
+	default:
+		return nil, errors.New("Unknown trace exporter " + settings.Exporter)
+	}
+
+	return sdktrace.NewTracerProvider(options...), nil
+}
+
+// End ends the span, marking it failed with err if there is one
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upIdentitiesTable, downIdentitiesTable)
+}
+
+func upIdentitiesTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        CREATE TABLE IF NOT EXISTS identities (
+            id int(11) not null auto_increment,
+            sub char(36) not null,
+            username varchar(100) not null,
+            email varchar(255) not null,
+            phone_number varchar(20) not null default '',
+            password_hash varchar(255) not null,
+            confirmed tinyint(1) not null default 0,
+            code_hash char(64) not null default '',
+            code_expires_at datetime not null default '1970-01-01 00:00:01',
+            created_at datetime not null default current_timestamp,
+            primary key(id),
+            unique key identities_sub (sub),
+            unique key identities_username (username)
+        )ENGINE=InnoDB
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downIdentitiesTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        DROP TABLE IF EXISTS identities
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upRefreshTokensTable, downRefreshTokensTable)
+}
+
+func upRefreshTokensTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        CREATE TABLE IF NOT EXISTS refresh_tokens (
+            id int(11) not null auto_increment,
+            token_hash char(64) not null,
+            username varchar(100) not null,
+            expires_at datetime not null,
+            revoked tinyint(1) not null default 0,
+            created_at datetime not null default current_timestamp,
+            primary key(id),
+            unique key refresh_tokens_token_hash (token_hash),
+            key refresh_tokens_username (username)
+        )ENGINE=InnoDB
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downRefreshTokensTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        DROP TABLE IF EXISTS refresh_tokens
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
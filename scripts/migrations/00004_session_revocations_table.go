@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upSessionRevocationsTable, downSessionRevocationsTable)
+}
+
+func upSessionRevocationsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        CREATE TABLE IF NOT EXISTS revoked_tokens (
+            id int(11) not null auto_increment,
+            token_hash char(64) not null,
+            username varchar(100) not null,
+            created_at datetime not null default current_timestamp,
+            primary key(id),
+            unique key revoked_tokens_token_hash (token_hash)
+        )ENGINE=InnoDB
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+        CREATE TABLE IF NOT EXISTS sign_outs (
+            username varchar(100) not null,
+            signed_out_at datetime not null,
+            primary key(username)
+        )ENGINE=InnoDB
+    `
+	_, err = tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downSessionRevocationsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        DROP TABLE IF EXISTS sign_outs
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	sql = `
+        DROP TABLE IF EXISTS revoked_tokens
+    `
+	_, err = tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
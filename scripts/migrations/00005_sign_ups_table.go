@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upSignUpsTable, downSignUpsTable)
+}
+
+func upSignUpsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        CREATE TABLE IF NOT EXISTS sign_ups (
+            id int(11) not null auto_increment,
+            username varchar(100) not null,
+            state enum('pending', 'failed') not null default 'pending',
+            attempts int(11) not null default 0,
+            last_error varchar(1000) not null default '',
+            created_at datetime not null default current_timestamp,
+            updated_at datetime not null default current_timestamp,
+            primary key(id),
+            unique key sign_ups_username (username),
+            key sign_ups_updated_at (updated_at)
+        )ENGINE=InnoDB
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downSignUpsTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        DROP TABLE IF EXISTS sign_ups
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upUsersCognitoSub, downUsersCognitoSub)
+}
+
+func upUsersCognitoSub(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        ALTER TABLE users
+            ADD COLUMN cognito_sub char(36) null after username,
+            ADD UNIQUE KEY users_cognito_sub (cognito_sub)
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	// Only users of the self-hosted identity provider are linked here, the migration can't read cognito's
+	// user pool. Cognito users stay unlinked until reconcile --link-subs-only is run after migrating, which
+	// links every row to its sub without creating, deleting or renaming rows. Until then UserDetails links
+	// them one by one by username as they sign in.
+	sql = `
+        UPDATE users
+            JOIN identities ON identities.username = users.username
+            SET users.cognito_sub = identities.sub
+            WHERE users.cognito_sub IS NULL
+    `
+	_, err = tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downUsersCognitoSub(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        ALTER TABLE users
+            DROP KEY users_cognito_sub,
+            DROP COLUMN cognito_sub
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upProfilesTable, downProfilesTable)
+}
+
+func upProfilesTable(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        CREATE TABLE IF NOT EXISTS profiles (
+            user_id int(11) not null,
+            first_name varchar(100) not null default '',
+            last_name varchar(100) not null default '',
+            display_name varchar(50) not null default '',
+            avatar_url varchar(2048) not null default '',
+            bio varchar(1000) not null default '',
+            location varchar(100) not null default '',
+            locale varchar(35) not null default '',
+            timezone varchar(64) not null default '',
+            date_of_birth date null,
+            updated_at datetime not null default current_timestamp,
+            primary key(user_id)
+        )ENGINE=InnoDB DEFAULT CHARSET=utf8mb4
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downProfilesTable(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        DROP TABLE IF EXISTS profiles
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upIdentitiesVerifiedAttributes, downIdentitiesVerifiedAttributes)
+}
+
+func upIdentitiesVerifiedAttributes(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        ALTER TABLE identities
+            ADD COLUMN email_verified tinyint(1) not null default 0 after confirmed,
+            ADD COLUMN phone_number_verified tinyint(1) not null default 0 after email_verified,
+            ADD COLUMN code_attribute varchar(20) not null default '' after code_hash
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	// Confirming the sign up verified the email the code was sent to
+	sql = `
+        UPDATE identities SET email_verified = confirmed
+    `
+	_, err = tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func downIdentitiesVerifiedAttributes(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        ALTER TABLE identities
+            DROP COLUMN code_attribute,
+            DROP COLUMN phone_number_verified,
+            DROP COLUMN email_verified
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(upIdentitiesCodeAttempts, downIdentitiesCodeAttempts)
+}
+
+func upIdentitiesCodeAttempts(tx *sql.Tx) error {
+	// This code is executed when the migration is applied.
+	sql := `
+        ALTER TABLE identities
+            ADD COLUMN code_attempts tinyint unsigned not null default 0 after code_attribute
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downIdentitiesCodeAttempts(tx *sql.Tx) error {
+	// This code is executed when the migration is rolled back.
+	sql := `
+        ALTER TABLE identities
+            DROP COLUMN code_attempts
+    `
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}